@@ -17,9 +17,11 @@ package burmilla
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strings"
 
+	"gopkg.in/yaml.v2"
 	"shanhu.io/g/bosinit"
 	"shanhu.io/g/dock"
 	"shanhu.io/g/errcode"
@@ -96,6 +98,59 @@ func AddSSHKeys(b *Burmilla, keys []string) error {
 	return ConfigMerge(b, newConfig)
 }
 
+// parseConfigMounts parses the mounts in the OS's configuration, which
+// is the YAML output of "ros config get mounts".
+func parseConfigMounts(out string) ([][]string, error) {
+	var mounts [][]string
+	if err := yaml.Unmarshal([]byte(out), &mounts); err != nil {
+		return nil, errcode.Annotate(err, "parse mounts")
+	}
+	return mounts, nil
+}
+
+// ConfigMounts returns the mounts in the OS's configuration. Each mount
+// is in the form of cloud-config, like
+// ["/dev/sdb1", "/mnt/data", "ext4", "defaults"].
+func ConfigMounts(b *Burmilla) ([][]string, error) {
+	out, err := ConfigGet(b, "mounts")
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigMounts(out)
+}
+
+// AddMount adds a mount into the OS's configuration, so that it is
+// mounted on boot. The mount is in the form of cloud-config, like
+// ["/dev/sdb1", "/mnt/data", "ext4", "defaults"].
+func AddMount(b *Burmilla, mount []string) error {
+	if len(mount) < 2 {
+		return errcode.InvalidArgf("invalid mount: %q", mount)
+	}
+	mounts, err := ConfigMounts(b)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		if len(m) < 2 || m[1] != mount[1] {
+			continue
+		}
+		if m[0] != mount[0] {
+			return errcode.InvalidArgf(
+				"%q is already used by %q", mount[1], m[0],
+			)
+		}
+		return nil // already have this
+	}
+
+	// The list is set as a whole; a JSON list is also valid YAML.
+	bs, err := json.Marshal(append(mounts, mount))
+	if err != nil {
+		return errcode.Annotate(err, "encode mounts")
+	}
+	return ConfigSet(b, "mounts", string(bs))
+}
+
 func quoteBashString(s string) string {
 	// Borrowed from github.com/alessio/shellescape.
 	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
@@ -115,21 +170,3 @@ func ConfigMerge(b *Burmilla, config *bosinit.Config) error {
 		"/bin/bash", "-c", script,
 	}))
 }
-
-func isOnDigitalOcean(config *bosinit.Config) bool {
-	r := config.Rancher
-	if r == nil {
-		return false
-	}
-	init := r.CloudInit
-	if init == nil {
-		return false
-	}
-
-	for _, src := range init.DataSources {
-		if src == "digitalocean" {
-			return true
-		}
-	}
-	return false
-}
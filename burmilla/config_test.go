@@ -0,0 +1,47 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package burmilla
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigMounts(t *testing.T) {
+	for _, test := range []struct {
+		out  string
+		want [][]string
+	}{
+		{out: "", want: nil},
+		{out: "[]", want: [][]string{}},
+		{
+			out:  "- - /dev/sdb1\n  - /mnt/data\n  - ext4\n  - \"\"\n",
+			want: [][]string{{"/dev/sdb1", "/mnt/data", "ext4", ""}},
+		},
+	} {
+		got, err := parseConfigMounts(test.out)
+		if err != nil {
+			t.Errorf("parseConfigMounts(%q): %s", test.out, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf(
+				"parseConfigMounts(%q) got %q, want %q",
+				test.out, got, test.want,
+			)
+		}
+	}
+}
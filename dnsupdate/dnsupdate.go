@@ -0,0 +1,321 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package dnsupdate updates DNS records on a DNS server with dynamic
+// updates (RFC 2136), authenticated with TSIG (RFC 8945).
+package dnsupdate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"shanhu.io/g/errcode"
+)
+
+// Config is the config of a DNS server that accepts dynamic updates.
+// This config is JSON marshallable.
+type Config struct {
+	// Server is the address of the DNS server. Port 53 is used when the
+	// port is not specified.
+	Server string
+
+	// Zone is the zone to update, like "example.com".
+	Zone string
+
+	// TSIGKey is the name of the TSIG key.
+	TSIGKey string `json:",omitempty"`
+
+	// TSIGSecret is the base64 encoded secret of the TSIG key.
+	TSIGSecret string `json:",omitempty"`
+
+	// TSIGAlgorithm is the TSIG algorithm. Default is "hmac-sha256".
+	TSIGAlgorithm string `json:",omitempty"`
+}
+
+// Record is a DNS resource record.
+type Record struct {
+	Name  string // Domain name.
+	Type  string // "A", "AAAA" or "TXT".
+	TTL   uint32 // Time to live in seconds.
+	Value string // Address or text; empty matches all records on deleting.
+}
+
+// Update is a dynamic DNS update. Deletes are applied before adds.
+type Update struct {
+	Delete []*Record
+	Add    []*Record
+}
+
+// Client sends dynamic updates to a DNS server.
+type Client struct {
+	server string
+	zone   string
+	key    *tsigKey
+
+	// Dialer dials the DNS server. Uses the default dialer when nil.
+	Dialer *net.Dialer
+
+	// Now returns the current time. Uses time.Now when nil.
+	Now func() time.Time
+}
+
+// NewClient creates a new client.
+func NewClient(config *Config) (*Client, error) {
+	if config.Server == "" {
+		return nil, errcode.InvalidArgf("dns server missing")
+	}
+	if config.Zone == "" {
+		return nil, errcode.InvalidArgf("dns zone missing")
+	}
+	server := config.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	c := &Client{
+		server: server,
+		zone:   Fqdn(config.Zone),
+	}
+	if config.TSIGKey != "" {
+		key, err := newTSIGKey(
+			config.TSIGKey, config.TSIGAlgorithm, config.TSIGSecret,
+		)
+		if err != nil {
+			return nil, errcode.Annotate(err, "invalid tsig key")
+		}
+		c.key = key
+	}
+	return c, nil
+}
+
+// Fqdn returns the fully qualified form of a domain name, which ends with
+// a dot.
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func parseType(t string) (dnsmessage.Type, error) {
+	switch strings.ToUpper(t) {
+	case "A":
+		return dnsmessage.TypeA, nil
+	case "AAAA":
+		return dnsmessage.TypeAAAA, nil
+	case "TXT":
+		return dnsmessage.TypeTXT, nil
+	}
+	return 0, errcode.InvalidArgf("unsupported record type %q", t)
+}
+
+// classNONE is the class for deleting a specific record.
+const classNONE dnsmessage.Class = 254
+
+func addRecord(
+	b *dnsmessage.Builder, r *Record, class dnsmessage.Class, ttl uint32,
+) error {
+	name, err := dnsmessage.NewName(Fqdn(r.Name))
+	if err != nil {
+		return errcode.InvalidArgf("invalid name %q: %s", r.Name, err)
+	}
+	t, err := parseType(r.Type)
+	if err != nil {
+		return err
+	}
+	h := dnsmessage.ResourceHeader{Name: name, Class: class, TTL: ttl}
+
+	if class == dnsmessage.ClassANY {
+		// Deletes the entire record set; no record data.
+		return b.UnknownResource(h, dnsmessage.UnknownResource{Type: t})
+	}
+
+	switch t {
+	case dnsmessage.TypeTXT:
+		return b.TXTResource(h, dnsmessage.TXTResource{
+			TXT: splitTXT(r.Value),
+		})
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		ip, err := netip.ParseAddr(r.Value)
+		if err != nil {
+			return errcode.InvalidArgf("invalid address %q: %s", r.Value, err)
+		}
+		if t == dnsmessage.TypeA {
+			if !ip.Is4() {
+				return errcode.InvalidArgf("%q is not an IPv4 address", r.Value)
+			}
+			return b.AResource(h, dnsmessage.AResource{A: ip.As4()})
+		}
+		if !ip.Is6() || ip.Is4In6() {
+			return errcode.InvalidArgf("%q is not an IPv6 address", r.Value)
+		}
+		return b.AAAAResource(h, dnsmessage.AAAAResource{AAAA: ip.As16()})
+	}
+	return errcode.InvalidArgf("unsupported record type %q", r.Type)
+}
+
+// splitTXT splits a text value into character strings of at most 255
+// bytes.
+func splitTXT(s string) []string {
+	var ret []string
+	for len(s) > 255 {
+		ret = append(ret, s[:255])
+		s = s[255:]
+	}
+	return append(ret, s)
+}
+
+func randID() (uint16, error) {
+	var bs [2]byte
+	if _, err := rand.Read(bs[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(bs[:]), nil
+}
+
+func (c *Client) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c *Client) message(u *Update) ([]byte, error) {
+	id, err := randID()
+	if err != nil {
+		return nil, errcode.Annotate(err, "make message id")
+	}
+	zone, err := dnsmessage.NewName(c.zone)
+	if err != nil {
+		return nil, errcode.InvalidArgf("invalid zone %q: %s", c.zone, err)
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:     id,
+		OpCode: 5, // UPDATE
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  zone,
+		Type:  dnsmessage.TypeSOA,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, errcode.Annotate(err, "add zone")
+	}
+
+	// Prerequisites are in the answer section, and updates are in the
+	// authority section.
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if err := b.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	for _, r := range u.Delete {
+		class := classNONE
+		if r.Value == "" {
+			class = dnsmessage.ClassANY
+		}
+		if err := addRecord(&b, r, class, 0); err != nil {
+			return nil, errcode.Annotate(err, "add delete")
+		}
+	}
+	for _, r := range u.Add {
+		if err := addRecord(&b, r, dnsmessage.ClassINET, r.TTL); err != nil {
+			return nil, errcode.Annotate(err, "add record")
+		}
+	}
+
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	if c.key != nil {
+		return c.key.sign(msg, c.now())
+	}
+	return msg, nil
+}
+
+func (c *Client) exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	d := c.Dialer
+	if d == nil {
+		d = &net.Dialer{Timeout: 10 * time.Second}
+	}
+	conn, err := d.DialContext(ctx, "tcp", c.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// DNS over TCP prefixes messages with a 2-byte length.
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, errcode.Annotate(err, "send update")
+	}
+
+	var n [2]byte
+	if _, err := io.ReadFull(conn, n[:]); err != nil {
+		return nil, errcode.Annotate(err, "read response length")
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, errcode.Annotate(err, "read response")
+	}
+	return resp, nil
+}
+
+// Update sends a dynamic update to the server.
+func (c *Client) Update(ctx context.Context, u *Update) error {
+	msg, err := c.message(u)
+	if err != nil {
+		return errcode.Annotate(err, "build update message")
+	}
+	resp, err := c.exchange(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return errcode.Annotate(err, "parse response")
+	}
+	if h.ID != binary.BigEndian.Uint16(msg) {
+		return errcode.Internalf("response id mismatch")
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return errcode.Internalf("update failed: %s", h.RCode)
+	}
+	return nil
+}
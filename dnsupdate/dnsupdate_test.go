@@ -0,0 +1,177 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dnsupdate
+
+import (
+	"testing"
+
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func serveOne(t *testing.T, lis net.Listener, got chan<- []byte) {
+	conn, err := lis.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	var n [2]byte
+	if _, err := io.ReadFull(conn, n[:]); err != nil {
+		t.Error(err)
+		return
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		t.Error(err)
+		return
+	}
+	got <- msg
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:       binary.BigEndian.Uint16(msg),
+		Response: true,
+		OpCode:   5,
+	})
+	resp, err := b.Finish()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+	if _, err := conn.Write(append(buf, resp...)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	got := make(chan []byte, 1)
+	go serveOne(t, lis, got)
+
+	now := time.Unix(1700000000, 0)
+	c, err := NewClient(&Config{
+		Server:     lis.Addr().String(),
+		Zone:       "example.com",
+		TSIGKey:    "update-key",
+		TSIGSecret: "c2VjcmV0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Now = func() time.Time { return now }
+
+	u := &Update{
+		Delete: []*Record{{Name: "www.example.com", Type: "A"}},
+		Add: []*Record{
+			{Name: "www.example.com", Type: "A", TTL: 60, Value: "1.2.3.4"},
+			{
+				Name:  "_acme-challenge.example.com.",
+				Type:  "TXT",
+				TTL:   60,
+				Value: "token",
+			},
+		},
+	}
+	if err := c.Update(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-got
+
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.OpCode != 5 {
+		t.Errorf("got opcode %d, want 5", h.OpCode)
+	}
+	q, err := p.Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Name.String() != "example.com." || q.Type != dnsmessage.TypeSOA {
+		t.Errorf("got zone %v", q)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		t.Fatal(err)
+	}
+	updates, err := p.AllAuthorities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 3 {
+		t.Fatalf("got %d updates, want 3", len(updates))
+	}
+	if c := updates[0].Header.Class; c != dnsmessage.ClassANY {
+		t.Errorf("delete rrset got class %v", c)
+	}
+	a, ok := updates[1].Body.(*dnsmessage.AResource)
+	if !ok || a.A != [4]byte{1, 2, 3, 4} {
+		t.Errorf("got A record %v", updates[1].Body)
+	}
+	txt, ok := updates[2].Body.(*dnsmessage.TXTResource)
+	if !ok || len(txt.TXT) != 1 || txt.TXT[0] != "token" {
+		t.Errorf("got TXT record %v", updates[2].Body)
+	}
+
+	// Verify the TSIG MAC: the signed part is the message before the TSIG
+	// record, with ARCOUNT being 0.
+	extra, err := p.AllAdditionals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extra) != 1 || extra[0].Header.Type != typeTSIG {
+		t.Fatalf("want one TSIG record, got %v", extra)
+	}
+	rdata := extra[0].Body.(*dnsmessage.UnknownResource).Data
+
+	key, err := newTSIGKey("update-key", "", "c2VjcmV0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unsigned []byte
+	for i := len(msg) - 1; i >= 0; i-- {
+		tail := msg[i:]
+		if bytes.HasPrefix(tail, appendName(nil, key.name)) {
+			unsigned = append([]byte(nil), msg[:i]...)
+			break
+		}
+	}
+	if unsigned == nil {
+		t.Fatal("tsig record not found")
+	}
+	binary.BigEndian.PutUint16(unsigned[10:], 0)
+	mac := key.mac(unsigned, uint64(now.Unix()))
+	if !bytes.Contains(rdata, mac) {
+		t.Errorf("tsig mac mismatch")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dnsupdate
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"strings"
+	"time"
+
+	"shanhu.io/g/errcode"
+)
+
+const (
+	typeTSIG = 250
+	classANY = 255
+
+	tsigFudge = 300 // Seconds of time error permitted.
+)
+
+type tsigKey struct {
+	name   string // Canonical key name.
+	alg    string // Canonical algorithm name.
+	hash   func() hash.Hash
+	secret []byte
+}
+
+func newTSIGKey(name, alg, secret string) (*tsigKey, error) {
+	if alg == "" {
+		alg = "hmac-sha256"
+	}
+	alg = strings.ToLower(Fqdn(alg))
+
+	var h func() hash.Hash
+	switch alg {
+	case "hmac-sha1.":
+		h = sha1.New
+	case "hmac-sha256.":
+		h = sha256.New
+	case "hmac-sha512.":
+		h = sha512.New
+	default:
+		return nil, errcode.InvalidArgf("unsupported algorithm %q", alg)
+	}
+
+	bs, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, errcode.InvalidArgf("decode secret: %s", err)
+	}
+	return &tsigKey{
+		name:   strings.ToLower(Fqdn(name)),
+		alg:    alg,
+		hash:   h,
+		secret: bs,
+	}, nil
+}
+
+// appendName appends a domain name in uncompressed wire format.
+func appendName(buf []byte, name string) []byte {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	return append(buf, 0)
+}
+
+func appendUint48(buf []byte, v uint64) []byte {
+	return append(buf,
+		byte(v>>40), byte(v>>32), byte(v>>24),
+		byte(v>>16), byte(v>>8), byte(v),
+	)
+}
+
+// mac computes the TSIG MAC of a request message.
+func (k *tsigKey) mac(msg []byte, t uint64) []byte {
+	var vars []byte
+	vars = appendName(vars, k.name)
+	vars = binary.BigEndian.AppendUint16(vars, classANY)
+	vars = binary.BigEndian.AppendUint32(vars, 0) // TTL
+	vars = appendName(vars, k.alg)
+	vars = appendUint48(vars, t)
+	vars = binary.BigEndian.AppendUint16(vars, tsigFudge)
+	vars = binary.BigEndian.AppendUint16(vars, 0) // Error
+	vars = binary.BigEndian.AppendUint16(vars, 0) // Other len
+
+	h := hmac.New(k.hash, k.secret)
+	h.Write(msg)
+	h.Write(vars)
+	return h.Sum(nil)
+}
+
+// sign appends a TSIG record to the message. The message must not have
+// any records in the additional section yet.
+func (k *tsigKey) sign(msg []byte, now time.Time) ([]byte, error) {
+	if len(msg) < 12 {
+		return nil, errcode.Internalf("message too short")
+	}
+	if binary.BigEndian.Uint16(msg[10:]) != 0 {
+		return nil, errcode.Internalf("message already has additional records")
+	}
+
+	t := uint64(now.Unix())
+	mac := k.mac(msg, t)
+
+	var rdata []byte
+	rdata = appendName(rdata, k.alg)
+	rdata = appendUint48(rdata, t)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, msg[0:2]...)              // Original ID
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // Error
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // Other len
+
+	signed := make([]byte, len(msg), len(msg)+len(rdata)+64)
+	copy(signed, msg)
+	signed = appendName(signed, k.name)
+	signed = binary.BigEndian.AppendUint16(signed, typeTSIG)
+	signed = binary.BigEndian.AppendUint16(signed, classANY)
+	signed = binary.BigEndian.AppendUint32(signed, 0) // TTL
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+
+	binary.BigEndian.PutUint16(signed[10:], 1) // ARCOUNT
+	return signed, nil
+}
@@ -0,0 +1,287 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry is an entry of the access log.
+type AccessLogEntry struct {
+	Time     time.Time
+	Host     string
+	Method   string
+	Path     string
+	Status   int
+	Bytes    int64
+	Duration time.Duration // In nanoseconds.
+	RemoteIP string
+
+	// Via is tagTCP or tagFabrics, depending on how the request came in.
+	Via string
+
+	// Upstream is where the request is served: an address for proxied
+	// requests, HomeHost for the home server, or "!"-prefixed host for
+	// redirects. Empty when the host is not found.
+	Upstream string `json:",omitempty"`
+
+	// Route is the host map key that the host matched, like
+	// "*.example.com", or LocalRoute for local names. Empty when the host
+	// is not found.
+	Route string `json:",omitempty"`
+}
+
+const (
+	accessLogMaxSize = 10 << 20 // Rotates after this size.
+	accessLogKeep    = 3        // Number of rotated files to keep.
+	accessLogRecent  = 1000     // Number of recent entries kept in memory.
+)
+
+// accessLogger writes access log entries as JSON lines to a rotated file,
+// and keeps the recent entries in memory.
+type accessLogger struct {
+	path string // Empty for not writing to a file.
+
+	// observe is called on each entry when set.
+	observe func(entry *AccessLogEntry)
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	recent []*AccessLogEntry // Ring buffer.
+	next   int
+}
+
+func newAccessLogger(p string) *accessLogger {
+	return &accessLogger{path: p}
+}
+
+func (l *accessLogger) open() error {
+	f, err := os.OpenFile(
+		l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600,
+	)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+func (l *accessLogger) rotate() error {
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+	for i := accessLogKeep - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", l.path, i)
+		to := fmt.Sprintf("%s.%d", l.path, i+1)
+		if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+func (l *accessLogger) writeFile(bs []byte) error {
+	if l.f == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	if l.size+int64(len(bs)) > accessLogMaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(bs)
+	l.size += int64(n)
+	return err
+}
+
+func (l *accessLogger) log(entry *AccessLogEntry) {
+	if l.observe != nil {
+		l.observe(entry)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.recent) < accessLogRecent {
+		l.recent = append(l.recent, entry)
+	} else {
+		l.recent[l.next] = entry
+		l.next = (l.next + 1) % accessLogRecent
+	}
+
+	if l.path == "" {
+		return
+	}
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("marshal access log: ", err)
+		return
+	}
+	if err := l.writeFile(append(bs, '\n')); err != nil {
+		log.Println("write access log: ", err)
+	}
+}
+
+// list returns at most n recent entries, the latest first.
+func (l *accessLogger) list(n int) []*AccessLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	total := len(l.recent)
+	if n <= 0 || n > total {
+		n = total
+	}
+	entries := make([]*AccessLogEntry, 0, n)
+	for i := 0; i < n; i++ {
+		idx := (l.next - 1 - i + 2*total) % total
+		entries = append(entries, l.recent[idx])
+	}
+	return entries
+}
+
+func (l *accessLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// splitRemoteAddr splits the remote address of a request into the IP and
+// the tag of the listener that the request came in.
+func splitRemoteAddr(addr string) (ip, via string) {
+	via = tagTCP
+	if strings.HasPrefix(addr, "|") {
+		addr = addr[1:]
+		via = tagFabrics
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, via
+	}
+	return host, via
+}
+
+type accessRecordKey struct{}
+
+// accessRecord is attached to the request context so that the handler can
+// fill in the fields that the logging middleware does not know.
+type accessRecord struct {
+	upstream string
+	route    string
+}
+
+func setAccessUpstream(ctx context.Context, upstream string) {
+	if r, ok := ctx.Value(accessRecordKey{}).(*accessRecord); ok {
+		r.upstream = upstream
+	}
+}
+
+func setAccessRoute(ctx context.Context, route string) {
+	if r, ok := ctx.Value(accessRecordKey{}).(*accessRecord); ok {
+		r.route = route
+	}
+}
+
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogWriter) Write(bs []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(bs)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap is for http.ResponseController, so that flushing and hijacking
+// still work through the wrapper.
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (l *accessLogger) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := new(accessRecord)
+		ctx := context.WithValue(req.Context(), accessRecordKey{}, rec)
+		lw := &accessLogWriter{ResponseWriter: w}
+
+		// Read the fields before serving, as handlers might change them.
+		ip, via := splitRemoteAddr(req.RemoteAddr)
+		host := strings.TrimSuffix(req.Host, ".")
+		method := req.Method
+		p := req.URL.Path
+
+		h.ServeHTTP(lw, req.WithContext(ctx))
+
+		status := lw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		l.log(&AccessLogEntry{
+			Time:     start.UTC(),
+			Host:     host,
+			Method:   method,
+			Path:     p,
+			Status:   status,
+			Bytes:    lw.bytes,
+			Duration: time.Since(start),
+			RemoteIP: ip,
+			Via:      via,
+			Upstream: rec.upstream,
+			Route:    rec.route,
+		})
+	})
+}
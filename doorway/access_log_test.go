@@ -0,0 +1,91 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccessLogger(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "access.log")
+	l := newAccessLogger(p)
+	defer l.Close()
+
+	h := l.wrap(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			setAccessUpstream(req.Context(), "app:80")
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte("hello"))
+		},
+	))
+
+	for _, addr := range []string{"1.2.3.4:5678", "|5.6.7.8:9012"} {
+		req := httptest.NewRequest("GET", "/path", nil)
+		req.Host = "example.com."
+		req.RemoteAddr = addr
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries := l.list(0)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	got := entries[0]
+	if got.Host != "example.com" || got.Path != "/path" ||
+		got.Status != http.StatusTeapot || got.Bytes != 5 ||
+		got.RemoteIP != "5.6.7.8" || got.Via != tagFabrics ||
+		got.Upstream != "app:80" {
+		t.Errorf("got entry %+v", got)
+	}
+	if entries[1].Via != tagTCP {
+		t.Errorf("got via %q, want %q", entries[1].Via, tagTCP)
+	}
+
+	if n := len(l.list(1)); n != 1 {
+		t.Errorf("list(1) got %d entries", n)
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() == 0 {
+		t.Error("access log file is empty")
+	}
+}
+
+func TestAccessLoggerRecent(t *testing.T) {
+	l := newAccessLogger("")
+	for i := 0; i < accessLogRecent+10; i++ {
+		l.log(&AccessLogEntry{Status: i})
+	}
+	entries := l.list(0)
+	if len(entries) != accessLogRecent {
+		t.Fatalf("got %d entries, want %d", len(entries), accessLogRecent)
+	}
+	if want := accessLogRecent + 9; entries[0].Status != want {
+		t.Errorf("latest got %d, want %d", entries[0].Status, want)
+	}
+	if entries[accessLogRecent-1].Status != 10 {
+		t.Errorf(
+			"oldest got %d, want 10", entries[accessLogRecent-1].Status,
+		)
+	}
+}
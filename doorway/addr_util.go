@@ -17,8 +17,18 @@ package doorway
 
 import (
 	"net"
+	"strings"
 )
 
 func lisAddr(lis net.Listener) string {
 	return lis.Addr().String()
 }
+
+// hostName returns the host name of a Host header, without the port and
+// without the brackets around an IPv6 address.
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"testing"
+)
+
+func TestHostName(t *testing.T) {
+	for _, test := range []struct {
+		host, want string
+	}{
+		{"example.com", "example.com"},
+		{"example.com:8080", "example.com"},
+		{"192.168.1.2:8080", "192.168.1.2"},
+		{"[2001:db8::1]:8080", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+	} {
+		if got := hostName(test.host); got != test.want {
+			t.Errorf("hostName(%q), got %q, want %q", test.host, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+)
+
+// DefaultAdminAddr is the default address of the admin server. The admin
+// server serves status and stats of doorway, including metrics for
+// Prometheus on /metrics. As other containers on the network can reach
+// it, all requests must carry the admin token as a bearer token.
+const DefaultAdminAddr = ":8090"
+
+// AccessLogsRequest is the request to list recent access logs.
+type AccessLogsRequest struct {
+	N int // Max number of entries; 0 for all.
+}
+
+type adminServer struct {
+	addr      string
+	token     string
+	accessLog *accessLogger
+	server    *server
+	fabrics   *fabricsConfig // Nil when not using fabrics.
+}
+
+func (s *adminServer) apiAccessLogs(c *aries.C, req *AccessLogsRequest) (
+	[]*AccessLogEntry, error,
+) {
+	return s.accessLog.list(req.N), nil
+}
+
+func (s *adminServer) apiUpstreams(c *aries.C) ([]*UpstreamStatus, error) {
+	return s.server.health.list(), nil
+}
+
+func (s *adminServer) apiFabrics(c *aries.C) (*FabricsStatus, error) {
+	if s.fabrics == nil {
+		return &FabricsStatus{}, nil
+	}
+	return s.fabrics.status(), nil
+}
+
+// maxMaintenanceMessage is the max length of a maintenance message.
+const maxMaintenanceMessage = 1000
+
+func checkMaintenance(m hostMap, hosts map[string]*HostMaintenance) error {
+	for host, mt := range hosts {
+		if !hostMapHas(m, host) {
+			return errcode.InvalidArgf("host %q not in host map", host)
+		}
+		if mt != nil && len(mt.Message) > maxMaintenanceMessage {
+			return errcode.InvalidArgf("message of %q too long", host)
+		}
+	}
+	return nil
+}
+
+func (s *adminServer) apiSetMaintenance(
+	c *aries.C, hosts map[string]*HostMaintenance,
+) error {
+	if err := checkMaintenance(s.server.hostMap, hosts); err != nil {
+		return err
+	}
+	s.server.maintenance.set(hosts)
+	return nil
+}
+
+func (s *adminServer) apiMaintenance(c *aries.C) (
+	map[string]*HostMaintenance, error,
+) {
+	return s.server.maintenance.list(), nil
+}
+
+func (s *adminServer) router() *aries.Router {
+	r := aries.NewRouter()
+	r.Call("access-logs", s.apiAccessLogs)
+	r.Call("upstreams", s.apiUpstreams)
+	r.Call("fabrics", s.apiFabrics)
+	r.Call("maintenance", s.apiMaintenance)
+	r.Call("set-maintenance", s.apiSetMaintenance)
+	r.Get("metrics", stats.serve)
+	return r
+}
+
+// adminAuth checks the admin token of requests before passing them to
+// the admin router.
+type adminAuth struct {
+	token  string
+	router *aries.Router
+}
+
+func (a *adminAuth) Serve(c *aries.C) error {
+	const prefix = "Bearer "
+	auth := c.Req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return errcode.Unauthorizedf("admin token required")
+	}
+	got := strings.TrimPrefix(auth, prefix)
+	if subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) != 1 {
+		return errcode.Unauthorizedf("invalid admin token")
+	}
+	return a.router.Serve(c)
+}
+
+func runAdminServer(s *adminServer) {
+	log.Printf("starts admin on %q", s.addr)
+	auth := &adminAuth{token: s.token, router: s.router()}
+	for {
+		server := &http.Server{
+			Addr:    s.addr,
+			Handler: aries.Serve(auth),
+		}
+		if err := server.ListenAndServe(); err != nil {
+			log.Print(err)
+		}
+		server.Close()
+		time.Sleep(time.Second)
+	}
+}
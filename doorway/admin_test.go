@@ -0,0 +1,47 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckMaintenance(t *testing.T) {
+	m := mustNewMemHostMap(t, map[string]*HostMapEntry{
+		"nextcloud.example.com": {To: "nextcloud:80"},
+		"*.example.com":         {To: "all:80"},
+	})
+
+	ok := map[string]*HostMaintenance{
+		"nextcloud.example.com": {Message: "upgrading"},
+	}
+	if err := checkMaintenance(m, ok); err != nil {
+		t.Errorf("check valid maintenance: %s", err)
+	}
+
+	for _, hosts := range []map[string]*HostMaintenance{
+		{"bank.example.org": {Message: "log in here"}},
+		{"a.example.com": nil}, // Only matched by a wildcard.
+		{"nextcloud.example.com": {
+			Message: strings.Repeat("x", maxMaintenanceMessage+1),
+		}},
+	} {
+		if err := checkMaintenance(m, hosts); err == nil {
+			t.Errorf("check %+v, got nil error", hosts)
+		}
+	}
+}
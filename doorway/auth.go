@@ -0,0 +1,217 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+)
+
+// HostAuth is the authentication required before proxying to a host.
+// When both are set, basic auth is checked first.
+type HostAuth struct {
+	// Basic is a list of htpasswd style "user:hash" entries for HTTP basic
+	// auth. Only bcrypt hashes are supported.
+	Basic []string `json:",omitempty"`
+
+	// Realm is the realm of basic auth.
+	Realm string `json:",omitempty"`
+
+	// Forward is the URL for forward auth. Doorway sends a GET request
+	// with the cookies and credentials of the original request to the URL.
+	// A 2xx response allows the request. Otherwise, the response is sent
+	// back to the client, so the auth service can redirect to its login
+	// page.
+	Forward string `json:",omitempty"`
+}
+
+type hostAuth struct {
+	basic   map[string][]byte // user to bcrypt hash
+	realm   string
+	forward string
+}
+
+func newHostAuth(a *HostAuth) *hostAuth {
+	if a == nil {
+		return nil
+	}
+	basic := make(map[string][]byte)
+	for _, line := range a.Basic {
+		user, hash, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok || user == "" {
+			continue
+		}
+		basic[user] = []byte(hash)
+	}
+	realm := a.Realm
+	if realm == "" {
+		realm = "doorway"
+	}
+	return &hostAuth{
+		basic:   basic,
+		realm:   realm,
+		forward: a.Forward,
+	}
+}
+
+// authCache caches the basic auth credentials that passed the check, as
+// bcrypt is slow by design.
+type authCache struct {
+	mu sync.Mutex
+	m  map[[sha256.Size]byte]bool
+}
+
+const authCacheSize = 1000
+
+func newAuthCache() *authCache {
+	return &authCache{m: make(map[[sha256.Size]byte]bool)}
+}
+
+func authCacheKey(hash []byte, pass string) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(hash)
+	h.Write([]byte{0})
+	h.Write([]byte(pass))
+	var k [sha256.Size]byte
+	h.Sum(k[:0])
+	return k
+}
+
+func (c *authCache) check(hash []byte, pass string) bool {
+	k := authCacheKey(hash, pass)
+	c.mu.Lock()
+	ok := c.m[k]
+	c.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.m) >= authCacheSize {
+		c.m = make(map[[sha256.Size]byte]bool)
+	}
+	c.m[k] = true
+	return true
+}
+
+func (s *server) checkBasicAuth(c *aries.C, a *hostAuth) bool {
+	user, pass, ok := c.Req.BasicAuth()
+	if ok {
+		if hash, found := a.basic[user]; found {
+			if s.authCache.check(hash, pass) {
+				return true
+			}
+		}
+	}
+
+	c.Resp.Header().Set(
+		"WWW-Authenticate", `Basic realm="`+a.realm+`", charset="UTF-8"`,
+	)
+	http.Error(c.Resp, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// forwardAuthHeaders are the response headers of the forward auth service
+// that are passed back to the client on rejection.
+var forwardAuthHeaders = []string{
+	"Content-Type",
+	"Location",
+	"Set-Cookie",
+	"WWW-Authenticate",
+}
+
+func (s *server) checkForwardAuth(c *aries.C, a *hostAuth) (bool, error) {
+	req, err := http.NewRequestWithContext(
+		c.Req.Context(), http.MethodGet, a.forward, nil,
+	)
+	if err != nil {
+		return false, errcode.Annotate(err, "make forward auth request")
+	}
+	for _, k := range []string{"Cookie", "Authorization"} {
+		for _, v := range c.Req.Header.Values(k) {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Method", c.Req.Method)
+	req.Header.Set("X-Forwarded-Host", c.Req.Host)
+	req.Header.Set("X-Forwarded-Uri", c.Req.URL.RequestURI())
+	if ip := aries.RemoteIPString(c); ip != "" {
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+
+	resp, err := s.authClient.Do(req)
+	if err != nil {
+		return false, errcode.Annotate(err, "forward auth")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return true, nil
+	}
+
+	h := c.Resp.Header()
+	for _, k := range forwardAuthHeaders {
+		for _, v := range resp.Header.Values(k) {
+			h.Add(k, v)
+		}
+	}
+	c.Resp.WriteHeader(resp.StatusCode)
+	const maxBody = 1 << 20
+	body := io.LimitReader(resp.Body, maxBody)
+	if _, err := io.Copy(c.Resp, body); err != nil {
+		return false, errcode.Annotate(err, "copy forward auth response")
+	}
+	return false, nil
+}
+
+// checkAuth checks the authentication of a request. When it returns false
+// without an error, the response is already written.
+func (s *server) checkAuth(c *aries.C, a *hostAuth) (bool, error) {
+	if a == nil {
+		return true, nil
+	}
+	if len(a.basic) > 0 && !s.checkBasicAuth(c, a) {
+		return false, nil
+	}
+	if a.forward != "" {
+		return s.checkForwardAuth(c, a)
+	}
+	return true, nil
+}
+
+func newAuthClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			// Redirects are sent back to the client.
+			return http.ErrUseLastResponse
+		},
+	}
+}
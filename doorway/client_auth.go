@@ -0,0 +1,133 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+)
+
+// ClientAuthConfig is the config for verifying client certificates of
+// hosts that have HostMapEntry.ClientCert set.
+type ClientAuthConfig struct {
+	// CACerts are the PEM encoded certificates of the CAs that sign client
+	// certificates.
+	CACerts string
+
+	// Revoked are the serial numbers of revoked client certificates, in
+	// lower case hex.
+	Revoked []string `json:",omitempty"`
+}
+
+// CertSerial returns the serial number of a certificate in the format
+// used in ClientAuthConfig.Revoked.
+func CertSerial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+type clientAuth struct {
+	pool    *x509.CertPool
+	revoked map[string]bool
+}
+
+func newClientAuth(config *ClientAuthConfig) (*clientAuth, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(config.CACerts)) {
+		return nil, errcode.InvalidArgf("no valid client CA certificate")
+	}
+	revoked := make(map[string]bool)
+	for _, serial := range config.Revoked {
+		revoked[strings.ToLower(serial)] = true
+	}
+	return &clientAuth{pool: pool, revoked: revoked}, nil
+}
+
+// verifyConnection checks if the client certificate is revoked. It is
+// called on every handshake, including resumed ones.
+func (a *clientAuth) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errcode.Unauthorizedf("client certificate missing")
+	}
+	serial := CertSerial(state.PeerCertificates[0])
+	if a.revoked[serial] {
+		return errcode.Unauthorizedf("client certificate %s revoked", serial)
+	}
+	return nil
+}
+
+// isACMEHello checks if the client hello is for an ACME TLS-ALPN challenge.
+func isACMEHello(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapClientAuth returns a TLS config that requests and verifies client
+// certificates for hosts that require them, based on the SNI server name.
+// ACME TLS-ALPN challenges are never asked for client certificates, so
+// that certificates can be issued and renewed for those hosts.
+func (s *server) wrapClientAuth(base *tls.Config) *tls.Config {
+	if s.clientAuth == nil {
+		return base
+	}
+
+	mtls := base.Clone()
+	mtls.ClientAuth = tls.RequireAndVerifyClientCert
+	mtls.ClientCAs = s.clientAuth.pool
+	mtls.VerifyConnection = s.clientAuth.verifyConnection
+
+	config := base.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (
+		*tls.Config, error,
+	) {
+		if isACMEHello(hello) {
+			return nil, nil
+		}
+		name := strings.TrimSuffix(hello.ServerName, ".")
+		if s.hostMap.needClientCert(name) {
+			return mtls, nil
+		}
+		return nil, nil
+	}
+	return config
+}
+
+// checkClientCert checks if the request carries a verified client
+// certificate when the host requires one. A request can reach here
+// without one when the connection was set up for another host, in which
+// case the client is asked to open a new connection for this host.
+func (s *server) checkClientCert(c *aries.C, host string) (bool, error) {
+	if !s.hostMap.needClientCert(host) {
+		return true, nil
+	}
+	if s.clientAuth == nil {
+		return false, errcode.Unauthorizedf("client auth not configured")
+	}
+	if state := c.Req.TLS; state != nil && len(state.VerifiedChains) > 0 {
+		return true, nil
+	}
+	c.Resp.WriteHeader(http.StatusMisdirectedRequest)
+	return false, nil
+}
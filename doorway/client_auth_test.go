@@ -0,0 +1,208 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func testCert(
+	t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, tmpl, parent, key.Public(), parentKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestClientAuth(t *testing.T) {
+	now := time.Now()
+	ca, caKey := testCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	issue := func(serial int64) tls.Certificate {
+		cert, key := testCert(t, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "user"},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{
+				x509.ExtKeyUsageClientAuth,
+				x509.ExtKeyUsageServerAuth,
+			},
+			DNSNames: []string{"admin.example.com", "www.example.com"},
+		}, ca, caKey)
+		return tls.Certificate{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+			Leaf:        cert,
+		}
+	}
+	serverCert := issue(2)
+	goodCert := issue(3)
+	revokedCert := issue(4)
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	a, err := newClientAuth(&ClientAuthConfig{
+		CACerts: string(caPEM),
+		Revoked: []string{CertSerial(revokedCert.Leaf)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		hostMap: mustNewMemHostMap(t, map[string]*HostMapEntry{
+			"admin.example.com": {To: HomeHost, ClientCert: true},
+			"www.example.com":   {To: "front:8080"},
+		}),
+		clientAuth: a,
+	}
+	config := s.wrapClientAuth(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	handshake := func(host string, cert *tls.Certificate) error {
+		client, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		server, err := lis.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errs := make(chan error, 1)
+		go func() {
+			defer server.Close()
+			conn := tls.Server(server, config)
+			err := conn.Handshake()
+			if err == nil {
+				// Read for the client's verdict on the handshake.
+				_, err = conn.Read(make([]byte, 1))
+			}
+			errs <- err
+		}()
+
+		clientConfig := &tls.Config{ServerName: host, RootCAs: roots}
+		if cert != nil {
+			clientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		conn := tls.Client(client, clientConfig)
+		if err := conn.Handshake(); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte{0}); err != nil {
+			return err
+		}
+		return <-errs
+	}
+
+	for _, test := range []struct {
+		host string
+		cert *tls.Certificate
+		ok   bool
+	}{
+		{"admin.example.com", &goodCert, true},
+		{"admin.example.com", nil, false},
+		{"admin.example.com", &revokedCert, false},
+		{"www.example.com", nil, true},
+	} {
+		err := handshake(test.host, test.cert)
+		if test.ok && err != nil {
+			t.Errorf("handshake for %q: %s", test.host, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("handshake for %q: want error, got nil", test.host)
+		}
+	}
+}
+
+func TestClientAuthACME(t *testing.T) {
+	s := &server{
+		hostMap: mustNewMemHostMap(t, map[string]*HostMapEntry{
+			"admin.example.com": {To: HomeHost, ClientCert: true},
+		}),
+		clientAuth: &clientAuth{pool: x509.NewCertPool()},
+	}
+	config := s.wrapClientAuth(&tls.Config{})
+
+	for _, test := range []struct {
+		protos []string
+		mtls   bool
+	}{
+		{nil, true},
+		{[]string{"h2", "http/1.1"}, true},
+		{[]string{acme.ALPNProto}, false},
+	} {
+		got, err := config.GetConfigForClient(&tls.ClientHelloInfo{
+			ServerName:      "admin.example.com",
+			SupportedProtos: test.protos,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mtls := got != nil; mtls != test.mtls {
+			t.Errorf(
+				"protos %q, got client auth %t, want %t",
+				test.protos, mtls, test.mtls,
+			)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"shanhu.io/g/errcode"
+)
+
+// DNSSolver publishes DNS TXT records for ACME DNS-01 challenges.
+type DNSSolver interface {
+	// Present publishes a TXT record with the given value at fqdn.
+	Present(ctx C, fqdn, value string) error
+
+	// CleanUp removes the TXT record published by Present.
+	CleanUp(ctx C, fqdn, value string) error
+}
+
+// DNS01Config is the configuration for getting certificates with ACME
+// DNS-01 challenges rather than TLS-ALPN or HTTP challenges. Exactly one
+// solver needs to be specified. This config is JSON marshallable.
+type DNS01Config struct {
+	// Domains to get certificates for. Wildcard domains are allowed. When
+	// empty, all domains in the host map are used.
+	Domains []string `json:",omitempty"`
+
+	RFC2136 *RFC2136Config    `json:",omitempty"`
+	Webhook *DNSWebhookConfig `json:",omitempty"`
+
+	// DirectoryURL is the ACME directory. Default using Letsencrypt.
+	DirectoryURL string `json:",omitempty"`
+
+	// PropagationSeconds is the time to wait for the TXT records to
+	// propagate before accepting the challenges. Default is 60 seconds.
+	PropagationSeconds int `json:",omitempty"`
+}
+
+func newDNSSolver(config *DNS01Config) (DNSSolver, error) {
+	if config.RFC2136 != nil && config.Webhook != nil {
+		return nil, errcode.InvalidArgf("more than one dns solver")
+	}
+	if config.RFC2136 != nil {
+		return newRFC2136Solver(config.RFC2136)
+	}
+	if config.Webhook != nil {
+		return newDNSWebhookSolver(config.Webhook)
+	}
+	return nil, errcode.InvalidArgf("dns solver missing")
+}
+
+const (
+	dns01RenewBefore   = 30 * 24 * time.Hour
+	dns01CheckInterval = 12 * time.Hour
+	dns01RetryInterval = time.Hour
+)
+
+// dns01Manager manages certificates that are issued with DNS-01
+// challenges. Certificates are saved in the autocert cache.
+type dns01Manager struct {
+	domains      func() []string
+	solver       DNSSolver
+	cache        autocert.Cache
+	directoryURL string
+	propagation  time.Duration
+
+	mu    sync.RWMutex
+	certs map[string]*tls.Certificate
+}
+
+func newDNS01Manager(
+	config *DNS01Config, solver DNSSolver, cache autocert.Cache,
+	hostMapDomains func() []string,
+) *dns01Manager {
+	domains := hostMapDomains
+	if len(config.Domains) > 0 {
+		list := append([]string(nil), config.Domains...)
+		domains = func() []string { return list }
+	}
+
+	propagation := time.Duration(config.PropagationSeconds) * time.Second
+	if propagation <= 0 {
+		propagation = time.Minute
+	}
+	dirURL := config.DirectoryURL
+	if dirURL == "" {
+		dirURL = acme.LetsEncryptURL
+	}
+
+	return &dns01Manager{
+		domains:      domains,
+		solver:       solver,
+		cache:        cache,
+		directoryURL: dirURL,
+		propagation:  propagation,
+		certs:        make(map[string]*tls.Certificate),
+	}
+}
+
+func dns01CacheKey(domain string) string { return domain + "+dns01" }
+
+func (m *dns01Manager) cert(name string) *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	k := matchCertName(name, func(k string) bool {
+		_, ok := m.certs[k]
+		return ok
+	})
+	if k == "" {
+		return nil
+	}
+	return m.certs[k]
+}
+
+func (m *dns01Manager) setCert(domain string, cert *tls.Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certs[domain] = cert
+}
+
+// wrap serves certificates issued with DNS-01 challenges, and falls back
+// to f for other names.
+func (m *dns01Manager) wrap(f getCertFunc) getCertFunc {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.TrimSuffix(hello.ServerName, ".")
+		if cert := m.cert(name); cert != nil {
+			return cert, nil
+		}
+		return f(hello)
+	}
+}
+
+func (m *dns01Manager) loadCached(ctx C, domain string) (
+	*tls.Certificate, error,
+) {
+	bs, err := m.cache.Get(ctx, dns01CacheKey(domain))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(bs, bs)
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse cached cert")
+	}
+	return &cert, nil
+}
+
+func needsRenew(cert *tls.Certificate, now time.Time) bool {
+	if cert == nil || cert.Leaf == nil {
+		return true
+	}
+	return now.Add(dns01RenewBefore).After(cert.Leaf.NotAfter)
+}
+
+// check loads certificates from the cache, and issues new ones for the
+// domains that are missing certificates or expiring soon. It returns true
+// if all certificates are good.
+func (m *dns01Manager) check(ctx C) bool {
+	domains := m.domains()
+	sort.Strings(domains)
+
+	ok := true
+	now := time.Now()
+	for _, domain := range domains {
+		cert := m.cert(domain)
+		if cert == nil {
+			cached, err := m.loadCached(ctx, domain)
+			if err != nil && err != autocert.ErrCacheMiss {
+				log.Printf("load dns01 cert for %q: %s", domain, err)
+			}
+			if cached != nil {
+				cert = cached
+				m.setCert(domain, cert)
+			}
+		}
+		if !needsRenew(cert, now) {
+			continue
+		}
+
+		log.Printf("get cert for %q via dns01", domain)
+		cert, err := m.obtain(ctx, domain)
+		if err != nil {
+			log.Printf("get dns01 cert for %q: %s", domain, err)
+			ok = false
+			continue
+		}
+		m.setCert(domain, cert)
+	}
+	return ok
+}
+
+func (m *dns01Manager) bg(ctx C) {
+	for {
+		wait := dns01CheckInterval
+		if !m.check(ctx) {
+			wait = dns01RetryInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// accountKey loads the ACME account key from the cache. It uses the same
+// key as autocert.
+func (m *dns01Manager) accountKey(ctx C) (crypto.Signer, error) {
+	const keyName = "acme_account+key"
+	bs, err := m.cache.Get(ctx, keyName)
+	if err == nil {
+		b, _ := pem.Decode(bs)
+		if b == nil {
+			return nil, errcode.Internalf("invalid account key")
+		}
+		return x509.ParseECPrivateKey(b.Bytes)
+	}
+	if err != autocert.ErrCacheMiss {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	b := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if err := m.cache.Put(ctx, keyName, pem.EncodeToMemory(b)); err != nil {
+		return nil, errcode.Annotate(err, "save account key")
+	}
+	return key, nil
+}
+
+func findChallenge(z *acme.Authorization, typ string) *acme.Challenge {
+	for _, c := range z.Challenges {
+		if c.Type == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+func (m *dns01Manager) sleep(ctx C, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (m *dns01Manager) authorize(
+	ctx C, client *acme.Client, order *acme.Order,
+) error {
+	type record struct{ fqdn, value string }
+	var records []*record
+	defer func() {
+		for _, r := range records {
+			if err := m.solver.CleanUp(ctx, r.fqdn, r.value); err != nil {
+				log.Printf("clean up dns record %q: %s", r.fqdn, err)
+			}
+		}
+	}()
+
+	var challenges []*acme.Challenge
+	var authzURLs []string
+	for _, u := range order.AuthzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return errcode.Annotate(err, "get authorization")
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		chal := findChallenge(z, "dns-01")
+		if chal == nil {
+			return errcode.Internalf(
+				"no dns-01 challenge for %q", z.Identifier.Value,
+			)
+		}
+		value, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return errcode.Annotate(err, "make challenge record")
+		}
+		// For wildcard domains, the identifier is the base domain.
+		fqdn := "_acme-challenge." + z.Identifier.Value + "."
+		if err := m.solver.Present(ctx, fqdn, value); err != nil {
+			return errcode.Annotatef(err, "publish %q", fqdn)
+		}
+		records = append(records, &record{fqdn: fqdn, value: value})
+		challenges = append(challenges, chal)
+		authzURLs = append(authzURLs, z.URI)
+	}
+	if len(challenges) == 0 {
+		return nil
+	}
+
+	if err := m.sleep(ctx, m.propagation); err != nil {
+		return err
+	}
+
+	for _, chal := range challenges {
+		if _, err := client.Accept(ctx, chal); err != nil {
+			return errcode.Annotate(err, "accept challenge")
+		}
+	}
+	for _, u := range authzURLs {
+		if _, err := client.WaitAuthorization(ctx, u); err != nil {
+			return errcode.Annotate(err, "wait authorization")
+		}
+	}
+	return nil
+}
+
+func (m *dns01Manager) obtain(ctx C, domain string) (
+	*tls.Certificate, error,
+) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	accountKey, err := m.accountKey(ctx)
+	if err != nil {
+		return nil, errcode.Annotate(err, "load account key")
+	}
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: m.directoryURL,
+	}
+	if _, err := client.Register(
+		ctx, new(acme.Account), acme.AcceptTOS,
+	); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, errcode.Annotate(err, "register account")
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, errcode.Annotate(err, "authorize order")
+	}
+	if err := m.authorize(ctx, client, order); err != nil {
+		return nil, err
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, errcode.Annotate(err, "wait order")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errcode.Annotate(err, "generate cert key")
+	}
+	csr, err := x509.CreateCertificateRequest(
+		rand.Reader,
+		&x509.CertificateRequest{DNSNames: []string{domain}},
+		key,
+	)
+	if err != nil {
+		return nil, errcode.Annotate(err, "create csr")
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, errcode.Annotate(err, "create cert")
+	}
+
+	// Same format as autocert: the private key followed by the chain.
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, errcode.Annotate(err, "marshal cert key")
+	}
+	buf := new(bytes.Buffer)
+	pem.Encode(buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, der := range chain {
+		pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	bs := buf.Bytes()
+
+	cert, err := tls.X509KeyPair(bs, bs)
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse issued cert")
+	}
+	if err := m.cache.Put(ctx, dns01CacheKey(domain), bs); err != nil {
+		return nil, errcode.Annotate(err, "save cert")
+	}
+	return &cert, nil
+}
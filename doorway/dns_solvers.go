@@ -0,0 +1,135 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/homedrv/drv/dnsupdate"
+)
+
+// RFC2136Config is the config of a DNS server that accepts TSIG
+// authenticated dynamic updates.
+type RFC2136Config = dnsupdate.Config
+
+type rfc2136Solver struct {
+	client *dnsupdate.Client
+}
+
+func newRFC2136Solver(config *RFC2136Config) (*rfc2136Solver, error) {
+	client, err := dnsupdate.NewClient(config)
+	if err != nil {
+		return nil, errcode.Annotate(err, "make rfc2136 client")
+	}
+	return &rfc2136Solver{client: client}, nil
+}
+
+func (s *rfc2136Solver) txt(fqdn, value string) *dnsupdate.Record {
+	return &dnsupdate.Record{
+		Name:  fqdn,
+		Type:  "TXT",
+		TTL:   60,
+		Value: value,
+	}
+}
+
+func (s *rfc2136Solver) Present(ctx C, fqdn, value string) error {
+	return s.client.Update(ctx, &dnsupdate.Update{
+		Add: []*dnsupdate.Record{s.txt(fqdn, value)},
+	})
+}
+
+func (s *rfc2136Solver) CleanUp(ctx C, fqdn, value string) error {
+	return s.client.Update(ctx, &dnsupdate.Update{
+		Delete: []*dnsupdate.Record{s.txt(fqdn, value)},
+	})
+}
+
+// DNSWebhookConfig is the config of a generic HTTP webhook for publishing
+// DNS records. Doorway posts a DNSWebhookRequest to the URL.
+type DNSWebhookConfig struct {
+	URL string
+
+	// Token is sent as a bearer token when not empty.
+	Token string `json:",omitempty"`
+}
+
+// DNSWebhookRequest is the request that doorway sends to a DNS webhook.
+type DNSWebhookRequest struct {
+	Action string // "present" or "cleanup"
+	FQDN   string
+	Value  string
+}
+
+type dnsWebhookSolver struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newDNSWebhookSolver(config *DNSWebhookConfig) (*dnsWebhookSolver, error) {
+	if config.URL == "" {
+		return nil, errcode.InvalidArgf("webhook url missing")
+	}
+	return &dnsWebhookSolver{
+		url:    config.URL,
+		token:  config.Token,
+		client: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func (s *dnsWebhookSolver) call(ctx C, action, fqdn, value string) error {
+	bs, err := json.Marshal(&DNSWebhookRequest{
+		Action: action,
+		FQDN:   fqdn,
+		Value:  value,
+	})
+	if err != nil {
+		return errcode.Annotate(err, "marshal request")
+	}
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, s.url, bytes.NewReader(bs),
+	)
+	if err != nil {
+		return errcode.Annotate(err, "make request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errcode.Internalf("webhook got status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *dnsWebhookSolver) Present(ctx C, fqdn, value string) error {
+	return s.call(ctx, "present", fqdn, value)
+}
+
+func (s *dnsWebhookSolver) CleanUp(ctx C, fqdn, value string) error {
+	return s.call(ctx, "cleanup", fqdn, value)
+}
@@ -27,6 +27,7 @@ import (
 	"shanhu.io/g/netutil"
 	"shanhu.io/g/sniproxy"
 	fabdial "shanhu.io/homedrv/drv/fabricsdial"
+	"shanhu.io/homedrv/drv/homedial"
 )
 
 // Config is the config of a doorway.
@@ -44,6 +45,12 @@ type Config struct {
 	// Local address to listen on.
 	LocalAddr string
 
+	// TrustedProxies are the CIDRs, IPs or IP presets of load balancers
+	// that are allowed to send PROXY protocol headers to the local
+	// listener. Connections from these peers may start with a PROXY
+	// protocol v1 or v2 header that carries the real client address.
+	TrustedProxies []string
+
 	Fabrics         *FabricsConfig // Config for dialing fabrics.
 	FabricsIdentity Identity       // Identity for dialing fabrics.
 
@@ -56,6 +63,24 @@ type Config struct {
 
 	// ListenDone is the callback function when listen is done.
 	ListenDone func()
+
+	// AccessLog is the file path to write access logs to. Recent entries
+	// are always kept in memory, even when this is empty.
+	AccessLog string
+
+	// AdminAddr is the address for the admin server, which serves
+	// stats like access logs. Empty for not serving.
+	AdminAddr string
+
+	// AdminToken is the bearer token that requests to the admin server
+	// must carry. The admin server is not served when it is empty.
+	AdminToken string
+
+	// PortForwards are extra TCP and UDP ports to listen on and forward.
+	PortForwards []*PortForward
+
+	// Dial is the config for dialing HomeDrive servers.
+	Dial *homedial.Config
 }
 
 type internalConfig struct {
@@ -64,22 +89,29 @@ type internalConfig struct {
 	listen     *listenConfig
 	listenDone func()
 	tlsConfig  *tls.Config
+	accessLog  string
+	adminAddr  string
+	adminToken string
+
+	portForwards []*PortForward
 }
 
 func makeInternalConfig(config *Config) *internalConfig {
 	lisConfig := new(listenConfig)
 	if config.LocalAddr != "" {
-		lisConfig.local = &localListenConfig{addr: config.LocalAddr}
+		lisConfig.local = &localListenConfig{
+			addr:           config.LocalAddr,
+			trustedProxies: config.TrustedProxies,
+		}
 	}
 	if config.FabricsDialer != nil {
 		lisConfig.fabrics = &fabricsConfig{
 			dialer: config.FabricsDialer,
 		}
 	} else if config.Fabrics != nil {
-		lisConfig.fabrics = &fabricsConfig{
-			FabricsConfig: config.Fabrics,
-			identity:      config.FabricsIdentity,
-		}
+		lisConfig.fabrics = newFabricsConfig(
+			config.Fabrics, config.FabricsIdentity,
+		)
 	}
 
 	return &internalConfig{
@@ -88,11 +120,19 @@ func makeInternalConfig(config *Config) *internalConfig {
 		listen:     lisConfig,
 		tlsConfig:  config.TLSConfig,
 		listenDone: config.ListenDone,
+		accessLog:  config.AccessLog,
+		adminAddr:  config.AdminAddr,
+		adminToken: config.AdminToken,
+
+		portForwards: config.PortForwards,
 	}
 }
 
 // Serve serves doorway with the given config.
 func Serve(ctx C, config *Config) error {
+	if err := homedial.SetConfig(config.Dial); err != nil {
+		return errcode.Annotate(err, "set dial config")
+	}
 	if config.HTTPServer != nil {
 		http := newHTTPServer(config.HTTPServer)
 		go runHTTPServer(http)
@@ -123,18 +163,48 @@ func serve(ctx C, config *internalConfig) error {
 		config.listenDone()
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go server.health.bg(ctx)
+
+	if err := listenPortForwards(ctx, config.portForwards); err != nil {
+		return errcode.Annotate(err, "listen for port forwarding")
+	}
+
 	tlsConfig := config.tlsConfig
 	if tlsConfig == nil {
 		tlsConfig = server.autoTLSConfig()
+		if server.dns01 != nil {
+			go server.dns01.bg(ctx)
+		}
+	}
+	tlsConfig = server.wrapClientAuth(tlsConfig)
+
+	accessLog := newAccessLogger(config.accessLog)
+	accessLog.observe = stats.observeRequest
+	defer accessLog.Close()
+
+	if config.adminAddr != "" {
+		if config.adminToken == "" {
+			log.Println("admin server disabled: no admin token")
+		} else {
+			admin := &adminServer{
+				addr:      config.adminAddr,
+				token:     config.adminToken,
+				accessLog: accessLog,
+				server:    server,
+				fabrics:   config.listen.fabrics,
+			}
+			go runAdminServer(admin)
+		}
 	}
-
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
 
 	log.Printf("starts https on %q", lisAddr(httpsLis))
 	https := &http.Server{
 		TLSConfig: tlsConfig,
-		Handler:   aries.Serve(server),
+		Handler:   accessLog.wrap(aries.Serve(server)),
+		ErrorLog:  stats.errorLog(),
 	}
 	go func() {
 		<-ctx.Done()
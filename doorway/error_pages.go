@@ -0,0 +1,232 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"shanhu.io/g/errcode"
+)
+
+// Names of the error pages. Custom error pages are read from the etc
+// directory as <name>.html, and are parsed as html/template templates
+// with an ErrorPageData.
+const (
+	ErrorPageUnavailable = "unavailable"
+	ErrorPageMaintenance = "maintenance"
+)
+
+// ErrorPageData is the data for rendering an error page.
+type ErrorPageData struct {
+	Host    string
+	Message string
+	ETA     time.Time // Zero when unknown.
+}
+
+const defaultUnavailablePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>Service is starting</title>
+<style>
+body { font-family: sans-serif; text-align: center; padding-top: 15vh;
+  color: #333; background: #f5f5f5; }
+</style>
+</head>
+<body>
+<h1>Service is starting</h1>
+<p>{{.Host}} is not available right now. Please try again in a moment.</p>
+{{with .Message}}<p>{{.}}</p>{{end}}
+<p><small>HomeDrive</small></p>
+</body>
+</html>
+`
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="60">
+<title>Under maintenance</title>
+<style>
+body { font-family: sans-serif; text-align: center; padding-top: 15vh;
+  color: #333; background: #f5f5f5; }
+</style>
+</head>
+<body>
+<h1>Under maintenance</h1>
+<p>{{.Host}} is being upgraded. It will be back shortly.</p>
+{{with .Message}}<p>{{.}}</p>{{end}}
+{{if not .ETA.IsZero}}<p>Expected to be back at
+<time datetime="{{.ETA.Format "2006-01-02T15:04:05Z07:00"}}">
+{{.ETA.Format "Jan 2, 15:04 MST"}}</time>.</p>{{end}}
+<p><small>HomeDrive</small></p>
+</body>
+</html>
+`
+
+type errorPages struct {
+	unavailable *template.Template
+	maintenance *template.Template
+}
+
+func parseErrorPage(name, custom, def string) (*template.Template, error) {
+	if custom == "" {
+		custom = def
+	}
+	t, err := template.New(name).Parse(custom)
+	if err != nil {
+		return nil, errcode.Annotatef(err, "parse %q page", name)
+	}
+	return t, nil
+}
+
+// newErrorPages creates the error pages. pages maps names to custom
+// templates; pages that are not in the map use the default templates.
+func newErrorPages(pages map[string]string) (*errorPages, error) {
+	unavailable, err := parseErrorPage(
+		ErrorPageUnavailable, pages[ErrorPageUnavailable],
+		defaultUnavailablePage,
+	)
+	if err != nil {
+		return nil, err
+	}
+	maintenance, err := parseErrorPage(
+		ErrorPageMaintenance, pages[ErrorPageMaintenance],
+		defaultMaintenancePage,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &errorPages{
+		unavailable: unavailable,
+		maintenance: maintenance,
+	}, nil
+}
+
+func serveErrorPage(
+	w http.ResponseWriter, t *template.Template, code int,
+	retryAfter int, data *ErrorPageData,
+) {
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		log.Printf("render %q page: %s", t.Name(), err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	if retryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+func (p *errorPages) serveUnavailable(
+	w http.ResponseWriter, code int, host string,
+) {
+	data := &ErrorPageData{Host: host}
+	serveErrorPage(w, p.unavailable, code, 30, data)
+}
+
+func (p *errorPages) serveMaintenance(
+	w http.ResponseWriter, host string, m *HostMaintenance,
+) {
+	data := &ErrorPageData{
+		Host:    host,
+		Message: m.Message,
+		ETA:     m.ETA,
+	}
+	retryAfter := 60
+	if wait := time.Until(m.ETA); wait > time.Minute {
+		retryAfter = int(wait.Seconds())
+	}
+	serveErrorPage(
+		w, p.maintenance, http.StatusServiceUnavailable, retryAfter, data,
+	)
+}
+
+// HostMaintenance marks a host as under maintenance.
+type HostMaintenance struct {
+	Message string    `json:",omitempty"`
+	ETA     time.Time // Estimated time of finishing; might be zero.
+
+	// Expires is when the mark is dropped automatically, in case the
+	// mark is never cleared. Zero for never.
+	Expires time.Time
+}
+
+func (m *HostMaintenance) expired(now time.Time) bool {
+	return !m.Expires.IsZero() && now.After(m.Expires)
+}
+
+// maintenanceSet is the set of hosts that are under maintenance.
+type maintenanceSet struct {
+	mu    sync.RWMutex
+	hosts map[string]*HostMaintenance
+}
+
+func newMaintenanceSet(hosts map[string]*HostMaintenance) *maintenanceSet {
+	s := new(maintenanceSet)
+	s.set(hosts)
+	return s
+}
+
+func (s *maintenanceSet) get(host string) *HostMaintenance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := s.hosts[host]
+	if m == nil || m.expired(time.Now()) {
+		return nil
+	}
+	return m
+}
+
+func (s *maintenanceSet) set(hosts map[string]*HostMaintenance) {
+	m := make(map[string]*HostMaintenance)
+	for host, mt := range hosts {
+		if mt == nil {
+			mt = new(HostMaintenance)
+		}
+		m[host] = mt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hosts = m
+}
+
+func (s *maintenanceSet) list() map[string]*HostMaintenance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := make(map[string]*HostMaintenance)
+	for host, mt := range s.hosts {
+		m[host] = mt
+	}
+	return m
+}
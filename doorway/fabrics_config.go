@@ -19,6 +19,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/websocket"
 	"shanhu.io/g/errcode"
@@ -34,6 +35,17 @@ type FabricsConfig struct {
 	User string
 	Host string `json:",omitempty"` // Default using fabrics.homedrive.io
 
+	// Servers is the list of fabrics servers to choose from. When not
+	// empty, Host is ignored. Doorway connects to the server with the
+	// lowest latency, and falls back to other servers when it cannot
+	// connect.
+	Servers []*FabricsServer `json:",omitempty"`
+
+	// Backoff is the backoff for reconnecting to fabrics. Default starts
+	// with one second, doubles on each failure, up to one minute. Fields
+	// that are not set use the defaults.
+	Backoff *ReconnectBackoff `json:",omitempty"`
+
 	InsecurelyDialTo string `json:",omitempty"`
 }
 
@@ -44,6 +56,13 @@ func (c *FabricsConfig) host() string {
 	return c.Host
 }
 
+func (c *FabricsConfig) servers() []*FabricsServer {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+	return []*FabricsServer{{Host: c.host()}}
+}
+
 type fabricsConfig struct {
 	// Explicit dialer creater. Will use this dialer instead of the User:Host
 	// when this is explicitly specified.
@@ -51,22 +70,62 @@ type fabricsConfig struct {
 
 	*FabricsConfig
 	identity Identity
+
+	// selector selects the fabrics server to connect to. Not used when
+	// dialer is specified.
+	selector *fabricsSelector
+
+	// listener is the listener connected to fabrics, set after listening.
+	// It is read by the admin server, so is guarded by mu.
+	mu       sync.Mutex
+	listener *reconnectListener
 }
 
-func makeFabricsDialer(ctx C, config *fabricsConfig) (
-	*fabdial.Dialer, error,
-) {
-	if config.dialer != nil {
-		return config.dialer, nil
+func (c *fabricsConfig) setListener(lis *reconnectListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listener = lis
+}
+
+func (c *fabricsConfig) getListener() *reconnectListener {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.listener
+}
+
+func (c *fabricsConfig) status() *FabricsStatus {
+	status := new(FabricsStatus)
+	if c.selector != nil {
+		status = c.selector.list()
+	}
+	if lis := c.getListener(); lis != nil {
+		status.Tunnel = lis.Status()
 	}
+	return status
+}
 
+func newFabricsConfig(c *FabricsConfig, id Identity) *fabricsConfig {
+	selector := newFabricsSelector(c.servers())
+	if c.InsecurelyDialTo == "" {
+		selector.probe = probeFabrics
+	}
+	return &fabricsConfig{
+		FabricsConfig: c,
+		identity:      id,
+		selector:      selector,
+	}
+}
+
+func makeFabricsDialer(ctx C, config *fabricsConfig, host string) (
+	*fabdial.Dialer, error,
+) {
 	key, err := config.identity.Load(ctx)
 	if err != nil {
 		return nil, errcode.Annotate(err, "read fabrics key")
 	}
 
 	router := &fabdial.SimpleRouter{
-		Host: config.host(),
+		Host: host,
 		User: config.User,
 		Key:  key,
 	}
@@ -87,23 +146,70 @@ func makeFabricsDialer(ctx C, config *fabricsConfig) (
 	return dialer, nil
 }
 
-func listenFabrics(ctx C, config *fabricsConfig) (*tagListener, error) {
-	d, err := makeFabricsDialer(ctx, config)
-	if err != nil {
-		return nil, err
+// fabricsDialFunc returns a function that dials the fabrics servers in the
+// order of their ranks, until one of them succeeds.
+func fabricsDialFunc(ctx C, config *fabricsConfig) func() (
+	net.Listener, error,
+) {
+	if config.dialer != nil {
+		return func() (net.Listener, error) {
+			ep, err := config.dialer.Dial(ctx)
+			if err != nil {
+				return nil, errcode.Annotatef(err, "dial proxy")
+			}
+			return &fabricsListener{Endpoint: ep}, nil
+		}
 	}
-	lis, err := newReconnectListener(
-		func() (net.Listener, error) {
+
+	sel := config.selector
+	dialers := make(map[string]*fabdial.Dialer)
+	return func() (net.Listener, error) {
+		var lastErr error
+		for _, host := range sel.rank(ctx) {
+			d, ok := dialers[host]
+			if !ok {
+				var err error
+				d, err = makeFabricsDialer(ctx, config, host)
+				if err != nil {
+					return nil, err
+				}
+				dialers[host] = d
+			}
 			ep, err := d.Dial(ctx)
 			if err != nil {
-				return nil, errcode.Annotatef(err, "dial proxy")
+				log.Printf("dial fabrics server %q: %s", host, err)
+				sel.fail(host, err)
+				lastErr = err
+				continue
 			}
+			sel.setActive(host)
 			return &fabricsListener{Endpoint: ep}, nil
-		},
-		func(err error) { log.Println("fabrics connection: ", err) },
+		}
+		return nil, errcode.Annotatef(lastErr, "dial proxy")
+	}
+}
+
+func listenFabrics(ctx C, config *fabricsConfig) (*tagListener, error) {
+	onError := func(err error) {
+		log.Println("fabrics connection: ", err)
+		if config.selector != nil {
+			config.selector.setActive("")
+		}
+	}
+	var backoffConfig *ReconnectBackoff
+	if config.FabricsConfig != nil {
+		backoffConfig = config.Backoff
+	}
+	backoff, err := backoffConfig.backoff()
+	if err != nil {
+		return nil, errcode.Annotate(err, "fabrics backoff")
+	}
+	lis, err := newReconnectListener(
+		fabricsDialFunc(ctx, config), onError, backoff,
 	)
 	if err != nil {
 		return nil, errcode.Annotatef(err, "dial fabrics")
 	}
+	config.setListener(lis)
 	return newTagListener(lis, tagFabrics), nil
 }
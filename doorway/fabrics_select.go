@@ -0,0 +1,193 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"shanhu.io/homedrv/drv/homedial"
+)
+
+// FabricsServer is a fabrics server that doorway can connect to.
+type FabricsServer struct {
+	Host string
+
+	// Weight biases the selection towards this server. The measured
+	// latency is divided by the weight when comparing servers. Default is
+	// 1.
+	Weight int `json:",omitempty"`
+}
+
+// FabricsServerStatus is the status of a fabrics server.
+type FabricsServerStatus struct {
+	Host      string
+	Weight    int
+	Reachable bool
+	Latency   time.Duration // Time to open a TCP connection.
+	Checked   time.Time
+	Error     string `json:",omitempty"`
+}
+
+// FabricsStatus is the status of the fabrics servers.
+type FabricsStatus struct {
+	Active  string // The connected server. Empty when not connected.
+	Since   time.Time
+	Servers []*FabricsServerStatus
+
+	// Tunnel is the status of the connection to fabrics.
+	Tunnel *ReconnectStatus `json:",omitempty"`
+}
+
+const fabricsProbeTimeout = 3 * time.Second
+
+func probeFabrics(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, fabricsProbeTimeout)
+	defer cancel()
+	conn, err := homedial.Dial(ctx, "tcp", net.JoinHostPort(host, "443"))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// fabricsSelector ranks the fabrics servers by latency and tracks the
+// server that is connected.
+type fabricsSelector struct {
+	servers []*FabricsServer
+
+	// probe checks if a server is reachable. Nil for not measuring, and
+	// servers are always tried in the configured order.
+	probe func(ctx context.Context, host string) error
+
+	mu     sync.Mutex
+	status map[string]*FabricsServerStatus
+	active string
+	since  time.Time
+}
+
+func newFabricsSelector(servers []*FabricsServer) *fabricsSelector {
+	status := make(map[string]*FabricsServerStatus)
+	for _, s := range servers {
+		status[s.Host] = &FabricsServerStatus{
+			Host:      s.Host,
+			Weight:    s.Weight,
+			Reachable: true, // Assume reachable before checking.
+		}
+	}
+	return &fabricsSelector{
+		servers: servers,
+		status:  status,
+	}
+}
+
+func (s *fabricsSelector) record(host string, lat time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status[host]
+	st.Checked = time.Now()
+	st.Latency = lat
+	if err != nil {
+		st.Reachable = false
+		st.Error = err.Error()
+	} else {
+		st.Reachable = true
+		st.Error = ""
+	}
+}
+
+// measure probes all the servers concurrently.
+func (s *fabricsSelector) measure(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, server := range s.servers {
+		host := server.Host
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := s.probe(ctx, host)
+			s.record(host, time.Since(start), err)
+		}()
+	}
+	wg.Wait()
+}
+
+// rank returns the hosts of the servers, best first. Reachable servers go
+// before unreachable ones; then servers with lower latency per weight go
+// first. Ties keep the configured order.
+func (s *fabricsSelector) rank(ctx context.Context) []string {
+	if s.probe != nil && len(s.servers) > 1 {
+		s.measure(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := func(server *FabricsServer) time.Duration {
+		w := server.Weight
+		if w <= 0 {
+			w = 1
+		}
+		return s.status[server.Host].Latency / time.Duration(w)
+	}
+
+	servers := make([]*FabricsServer, len(s.servers))
+	copy(servers, s.servers)
+	sort.SliceStable(servers, func(i, j int) bool {
+		a := s.status[servers[i].Host]
+		b := s.status[servers[j].Host]
+		if a.Reachable != b.Reachable {
+			return a.Reachable
+		}
+		return score(servers[i]) < score(servers[j])
+	})
+
+	var hosts []string
+	for _, server := range servers {
+		hosts = append(hosts, server.Host)
+	}
+	return hosts
+}
+
+// fail marks a server as unreachable after failing to connect to it.
+func (s *fabricsSelector) fail(host string, err error) {
+	s.record(host, 0, err)
+}
+
+func (s *fabricsSelector) setActive(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == host {
+		return
+	}
+	s.active = host
+	s.since = time.Now()
+}
+
+func (s *fabricsSelector) list() *FabricsStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := &FabricsStatus{Active: s.active, Since: s.since}
+	for _, server := range s.servers {
+		st := *s.status[server.Host]
+		ret.Servers = append(ret.Servers, &st)
+	}
+	return ret
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFabricsSelector(t *testing.T) {
+	s := newFabricsSelector([]*FabricsServer{
+		{Host: "d.example.com"},
+		{Host: "a.example.com"},
+		{Host: "b.example.com"},
+		{Host: "c.example.com", Weight: 3},
+	})
+	s.record("a.example.com", 30*time.Millisecond, nil)
+	s.record("b.example.com", 10*time.Millisecond, nil)
+	s.record("c.example.com", 25*time.Millisecond, nil)
+	s.record("d.example.com", 0, errors.New("unreachable"))
+
+	got := s.rank(context.Background())
+	want := []string{
+		"c.example.com", // 25ms / 3
+		"b.example.com",
+		"a.example.com",
+		"d.example.com", // unreachable
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got rank %q, want %q", got, want)
+	}
+
+	s.setActive("c.example.com")
+	s.fail("c.example.com", errors.New("connection refused"))
+	got = s.rank(context.Background())
+	if got[0] != "b.example.com" {
+		t.Errorf("got first %q after failure, want b.example.com", got[0])
+	}
+	if status := s.list(); status.Active != "c.example.com" {
+		t.Errorf("got active %q, want c.example.com", status.Active)
+	}
+
+	// Probing brings servers back.
+	s.probe = func(_ context.Context, host string) error {
+		if host == "a.example.com" {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+	got = s.rank(context.Background())
+	if last := got[len(got)-1]; last != "a.example.com" {
+		t.Errorf("got last %q after probing, want a.example.com", last)
+	}
+	for _, st := range s.list().Servers {
+		want := st.Host != "a.example.com"
+		if st.Reachable != want {
+			t.Errorf(
+				"%q got reachable %t, want %t",
+				st.Host, st.Reachable, want,
+			)
+		}
+	}
+}
@@ -40,6 +40,18 @@ func forwardToHTTP(req *http.Request, host string) {
 	u.Host = host
 }
 
+func stripURLPrefix(u *url.URL, prefix string) {
+	// The prefix was matched on the cleaned path.
+	if p := cleanPath(u.Path); p != u.Path {
+		u.Path = p
+		u.RawPath = ""
+	}
+	u.Path = stripPathPrefix(u.Path, prefix)
+	if u.RawPath != "" {
+		u.RawPath = stripPathPrefix(u.RawPath, prefix)
+	}
+}
+
 var sinkURL = &url.URL{
 	Scheme: "http",
 	Host:   "localhost",
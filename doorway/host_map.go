@@ -16,8 +16,12 @@
 package doorway
 
 import (
+	"path"
+	"sort"
 	"strings"
 	"sync"
+
+	"shanhu.io/g/errcode"
 )
 
 // HomeHost is the destination mapping that maps to doorway's internal
@@ -34,64 +38,255 @@ const (
 type hostEntry struct {
 	host string
 	typ  int
+
+	// key is the host map key that the host matched, like
+	// "*.example.com". It is set by mapHost.
+	key string
+
+	// prefix is the path prefix that this entry matches. Empty for the
+	// default entry of a host.
+	prefix      string
+	stripPrefix bool
+
+	auth     *hostAuth
+	ipFilter *ipFilter
+
+	// clientCert is true when the host requires client certificates.
+	clientCert bool
 }
 
 type hostMap interface {
-	mapHost(from string) *hostEntry
+	// mapHost maps a request host and path to an entry. When the host
+	// does not have an exact entry, the most specific wildcard entry is
+	// used.
+	mapHost(host, p string) *hostEntry
+
+	// hasHost checks if the host has an exact entry. Hosts that are only
+	// matched by wildcard entries are not included.
+	hasHost(host string) bool
+
+	// needClientCert checks if the host requires client certificates.
+	// Wildcard entries are matched like in mapHost.
+	needClientCert(host string) bool
+}
+
+// hostRoutes are all the entries of a host.
+type hostRoutes struct {
+	def    *hostEntry   // Entry when no prefix matches; might be nil.
+	routes []*hostEntry // Sorted by prefix length, longest first.
+
+	clientCert bool // Requires client certificates.
 }
 
 type memHostMap struct {
 	mu sync.RWMutex
 
-	m map[string]*hostEntry
+	m map[string]*hostRoutes
 }
 
-func newMemHostMap(m map[string]string) *memHostMap {
-	entries := make(map[string]*hostEntry)
+func parseHostDest(to string) *hostEntry {
+	if to == HomeHost {
+		return &hostEntry{typ: hostHome}
+	}
+	if strings.HasPrefix(to, "!") {
+		return &hostEntry{
+			typ:  hostRedirect,
+			host: strings.TrimPrefix(to, "!"),
+		}
+	}
+	return &hostEntry{
+		typ:  hostProxy,
+		host: to,
+	}
+}
 
-	for from, to := range m {
-		if to == HomeHost {
-			entries[from] = &hostEntry{
-				typ: hostHome,
-			}
-		} else if strings.HasPrefix(to, "!") {
-			entries[from] = &hostEntry{
-				typ:  hostRedirect,
-				host: strings.TrimPrefix(to, "!"),
-			}
+func normPathPrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		return "/" + prefix
+	}
+	return prefix
+}
+
+func newHostRoutes(entry *HostMapEntry) (*hostRoutes, error) {
+	filter, err := newIPFilter(entry.IPAllow, entry.IPDeny)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &hostRoutes{clientCert: entry.ClientCert}
+	auth := newHostAuth(entry.Auth)
+	if entry.To != "" {
+		r.def = parseHostDest(entry.To)
+		r.def.auth = auth
+		r.def.ipFilter = filter
+		r.def.clientCert = entry.ClientCert
+	}
+	for _, route := range entry.Routes {
+		e := parseHostDest(route.To)
+		e.prefix = normPathPrefix(route.Prefix)
+		e.stripPrefix = route.StripPrefix
+		e.auth = auth
+		if route.Auth != nil {
+			e.auth = newHostAuth(route.Auth)
+		}
+		routeFilter, err := newIPFilter(route.IPAllow, route.IPDeny)
+		if err != nil {
+			return nil, errcode.Annotatef(err, "route %q", route.Prefix)
+		}
+		if routeFilter != nil {
+			routeFilter.outer = filter
+			e.ipFilter = routeFilter
 		} else {
-			entries[from] = &hostEntry{
-				typ:  hostProxy,
-				host: to,
-			}
+			e.ipFilter = filter
+		}
+		e.clientCert = entry.ClientCert
+		r.routes = append(r.routes, e)
+	}
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].prefix) > len(r.routes[j].prefix)
+	})
+	return r, nil
+}
+
+func newMemHostMap(m map[string]*HostMapEntry) (*memHostMap, error) {
+	entries := make(map[string]*hostRoutes)
+	for from, entry := range m {
+		if entry == nil {
+			continue
 		}
+		r, err := newHostRoutes(entry)
+		if err != nil {
+			return nil, errcode.Annotatef(err, "host %q", from)
+		}
+		entries[from] = r
 	}
+	return &memHostMap{m: entries}, nil
+}
 
-	return &memHostMap{m: entries}
+// cleanPath cleans the dot segments and repeated slashes in a request
+// path, while keeping the trailing slash, like http.ServeMux does.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
 }
 
-func (m *memHostMap) mapHost(from string) *hostEntry {
+// matchPathPrefix checks if the cleaned path p is under prefix. Prefixes
+// only match on segment boundaries, and the trailing slash of a prefix
+// is optional, so both "/git" and "/git/" match "/git" and "/git/repo",
+// but not "/gitx".
+func matchPathPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+func stripPathPrefix(p, prefix string) string {
+	p = strings.TrimPrefix(p, strings.TrimSuffix(prefix, "/"))
+	if !strings.HasPrefix(p, "/") {
+		return "/" + p
+	}
+	return p
+}
+
+func (r *hostRoutes) match(p string) *hostEntry {
+	for _, route := range r.routes {
+		if matchPathPrefix(p, route.prefix) {
+			return route
+		}
+	}
+	return r.def
+}
+
+func (m *memHostMap) mapHost(host, p string) *hostEntry {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	to, ok := m.m[from]
-	if !ok {
+	k := matchWildcard(host, func(k string) bool {
+		_, ok := m.m[k]
+		return ok
+	})
+	if k == "" {
+		return nil
+	}
+	to := m.m[k].match(cleanPath(p))
+	if to == nil {
 		return nil
 	}
 	cp := *to
+	cp.key = k
 	return &cp
 }
 
-func hostMapToProxy(m hostMap, from string) string {
-	entry := m.mapHost(from)
-	if entry == nil {
-		return ""
+func (m *memHostMap) hosts() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var hosts []string
+	for host := range m.m {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// upstreams returns the proxy destinations in the host map, and the hosts
+// that use each destination.
+func (m *memHostMap) upstreams() map[string][]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ups := make(map[string][]string)
+	add := func(host string, e *hostEntry) {
+		if e == nil || e.typ != hostProxy {
+			return
+		}
+		hosts := ups[e.host]
+		if n := len(hosts); n > 0 && hosts[n-1] == host {
+			return
+		}
+		ups[e.host] = append(hosts, host)
+	}
+	for host, r := range m.m {
+		add(host, r.def)
+		for _, route := range r.routes {
+			add(host, route)
+		}
+	}
+	for _, hosts := range ups {
+		sort.Strings(hosts)
 	}
-	if entry.typ != hostProxy {
-		return ""
+	return ups
+}
+
+func (m *memHostMap) hasHost(host string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.m[host]
+	return ok
+}
+
+func (m *memHostMap) needClientCert(host string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	k := matchWildcard(host, func(k string) bool {
+		_, ok := m.m[k]
+		return ok
+	})
+	if k == "" {
+		return false
 	}
-	return entry.host
+	return m.m[k].clientCert
 }
 
-func hostMapHas(m hostMap, from string) bool {
-	return m.mapHost(from) != nil
+func hostMapHas(m hostMap, host string) bool {
+	return m.hasHost(host)
 }
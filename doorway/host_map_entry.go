@@ -0,0 +1,99 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// HostRoute routes requests under a path prefix of a host to a destination.
+type HostRoute struct {
+	// Prefix is the path prefix to match, like "/git/". Prefixes match on
+	// path segment boundaries; "/git/" and "/git" both match "/git" and
+	// "/git/repo", but not "/gitx".
+	Prefix string
+
+	// To is the destination. It uses the same syntax as HostMapEntry.To.
+	To string
+
+	// StripPrefix removes the prefix from the path before forwarding the
+	// request to the destination.
+	StripPrefix bool `json:",omitempty"`
+
+	// Auth overrides the auth of the host for this route when not nil.
+	Auth *HostAuth `json:",omitempty"`
+
+	// IPAllow and IPDeny are the IP allow and deny lists of the route.
+	// They are checked in addition to the ones of the host.
+	IPAllow []string `json:",omitempty"`
+	IPDeny  []string `json:",omitempty"`
+}
+
+// HostMapEntry is an entry in the host map. In the host map file, an entry
+// that only has a default destination can be written as a plain string.
+type HostMapEntry struct {
+	// To is the default destination of the host. It can be HomeHost, a
+	// "!"-prefixed host to redirect to, or an address to proxy to.
+	To string `json:",omitempty"`
+
+	// Routes are path prefix routes. The longest matching prefix wins. When
+	// no route matches, the request goes to To.
+	Routes []*HostRoute `json:",omitempty"`
+
+	// Auth is the authentication required for the host.
+	Auth *HostAuth `json:",omitempty"`
+
+	// IPAllow and IPDeny are the IP allow and deny lists of the host. They
+	// can have CIDRs, IP addresses and named presets like IPPresetLAN.
+	// When IPAllow is not empty, only IPs in the list can visit the host.
+	IPAllow []string `json:",omitempty"`
+	IPDeny  []string `json:",omitempty"`
+
+	// ClientCert requires clients to present a certificate signed by the
+	// client CA in ServerConfig.ClientAuth.
+	ClientCert bool `json:",omitempty"`
+}
+
+func (e *HostMapEntry) isPlain() bool {
+	return len(e.Routes) == 0 && e.Auth == nil &&
+		len(e.IPAllow) == 0 && len(e.IPDeny) == 0 && !e.ClientCert
+}
+
+type hostMapEntryJSON HostMapEntry
+
+// MarshalJSON marshals the entry into a plain string when it only has a
+// default destination.
+func (e *HostMapEntry) MarshalJSON() ([]byte, error) {
+	if e.isPlain() {
+		return json.Marshal(e.To)
+	}
+	return json.Marshal((*hostMapEntryJSON)(e))
+}
+
+// UnmarshalJSON unmarshals the entry from either a plain string or a JSON
+// object.
+func (e *HostMapEntry) UnmarshalJSON(bs []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(bs), []byte(`"`)) {
+		var to string
+		if err := json.Unmarshal(bs, &to); err != nil {
+			return err
+		}
+		*e = HostMapEntry{To: to}
+		return nil
+	}
+	return json.Unmarshal(bs, (*hostMapEntryJSON)(e))
+}
@@ -0,0 +1,262 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func mustNewMemHostMap(
+	t *testing.T, entries map[string]*HostMapEntry,
+) *memHostMap {
+	t.Helper()
+	m, err := newMemHostMap(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMemHostMap(t *testing.T) {
+	m := mustNewMemHostMap(t, map[string]*HostMapEntry{
+		"example.com": {
+			To: "front:8080",
+			Routes: []*HostRoute{
+				{Prefix: "/git/", To: "gitea:3000", StripPrefix: true},
+				{Prefix: "/git/admin/", To: HomeHost},
+				{Prefix: "media/", To: "jellyfin:8096"},
+			},
+		},
+		"git.example.com": {To: "!example.com"},
+		"only.example.com": {
+			Routes: []*HostRoute{{Prefix: "/app/", To: "app:80"}},
+		},
+	})
+
+	for _, test := range []struct {
+		host, path string
+		typ        int
+		to         string
+	}{
+		{"example.com", "/", hostProxy, "front:8080"},
+		{"example.com", "/gitx", hostProxy, "front:8080"},
+		{"example.com", "/git", hostProxy, "gitea:3000"},
+		{"example.com", "/git/repo", hostProxy, "gitea:3000"},
+		{"example.com", "/git/admin/x", hostHome, ""},
+		{"example.com", "/git/x/../admin/", hostHome, ""},
+		{"example.com", "/git//admin", hostHome, ""},
+		{"example.com", "/mediax", hostProxy, "front:8080"},
+		{"example.com", "/media/a.mp4", hostProxy, "jellyfin:8096"},
+		{"git.example.com", "/x", hostRedirect, "example.com"},
+		{"only.example.com", "/app/x", hostProxy, "app:80"},
+	} {
+		got := m.mapHost(test.host, test.path)
+		if got == nil {
+			t.Errorf("mapHost(%q, %q) got nil", test.host, test.path)
+			continue
+		}
+		if got.typ != test.typ || got.host != test.to {
+			t.Errorf(
+				"mapHost(%q, %q) got (%d, %q), want (%d, %q)",
+				test.host, test.path, got.typ, got.host, test.typ, test.to,
+			)
+		}
+	}
+
+	if got := m.mapHost("only.example.com", "/"); got != nil {
+		t.Errorf("want nil for unrouted path, got %+v", got)
+	}
+	if !hostMapHas(m, "only.example.com") {
+		t.Errorf("only.example.com should be in the host map")
+	}
+	if hostMapHas(m, "other.example.com") {
+		t.Errorf("other.example.com should not be in the host map")
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	for _, test := range []struct {
+		path, want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/a/b/", "/a/b/"},
+		{"/a/../admin/", "/admin/"},
+		{"//a//b", "/a/b"},
+		{"a/./b", "/a/b"},
+	} {
+		if got := cleanPath(test.path); got != test.want {
+			t.Errorf(
+				"cleanPath(%q) got %q, want %q",
+				test.path, got, test.want,
+			)
+		}
+	}
+}
+
+func TestStripPathPrefix(t *testing.T) {
+	for _, test := range []struct {
+		path, prefix, want string
+	}{
+		{"/git/repo", "/git/", "/repo"},
+		{"/git", "/git/", "/"},
+		{"/git/", "/git/", "/"},
+		{"/media/a/b", "/media", "/a/b"},
+	} {
+		got := stripPathPrefix(test.path, test.prefix)
+		if got != test.want {
+			t.Errorf(
+				"stripPathPrefix(%q, %q) got %q, want %q",
+				test.path, test.prefix, got, test.want,
+			)
+		}
+	}
+}
+
+func TestHostMapEntryJSON(t *testing.T) {
+	m := make(map[string]*HostMapEntry)
+	bs := []byte(`{
+		"a.com": "~",
+		"b.com": {"To": "b:80", "Routes": [{"Prefix": "/x/", "To": "x:80"}]}
+	}`)
+	if err := json.Unmarshal(bs, &m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m["a.com"].To; got != HomeHost {
+		t.Errorf("a.com got %q, want %q", got, HomeHost)
+	}
+	if b := m["b.com"]; b.To != "b:80" || len(b.Routes) != 1 {
+		t.Errorf("b.com got %+v", b)
+	}
+
+	out, err := json.Marshal(m["a.com"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `"~"` {
+		t.Errorf("plain entry marshals to %s", out)
+	}
+}
+
+func TestMemHostMapWildcard(t *testing.T) {
+	m := mustNewMemHostMap(t, map[string]*HostMapEntry{
+		"*.example.com":     {To: "all:80"},
+		"*.dev.example.com": {To: "dev:80"},
+		"www.example.com":   {To: "www:80"},
+	})
+
+	for _, test := range []struct {
+		host, to string
+	}{
+		{"www.example.com", "www:80"},
+		{"a.example.com", "all:80"},
+		{"a.b.example.com", "all:80"},
+		{"a.dev.example.com", "dev:80"},
+		{"dev.example.com", "all:80"},
+		{"example.com", ""},
+		{"a.example.org", ""},
+	} {
+		got := m.mapHost(test.host, "/")
+		if test.to == "" {
+			if got != nil {
+				t.Errorf("mapHost(%q) want nil, got %+v", test.host, got)
+			}
+			continue
+		}
+		if got == nil || got.host != test.to {
+			t.Errorf("mapHost(%q) got %+v, want %q", test.host, got, test.to)
+		}
+	}
+
+	if got := m.mapHost("a.b.example.com", "/"); got.key != "*.example.com" {
+		t.Errorf("got key %q, want %q", got.key, "*.example.com")
+	}
+
+	if hostMapHas(m, "a.example.com") {
+		t.Errorf("wildcard matched host should not be whitelisted")
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	f, err := newIPFilter(
+		[]string{IPPresetLAN, "203.0.113.7"},
+		[]string{"192.168.9.0/24"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.2", true},
+		{"10.1.2.3", true},
+		{"fe80::1", true},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"8.8.8.8", false},
+		{"192.168.9.1", false},
+	} {
+		got := f.check(net.ParseIP(test.ip))
+		if got != test.want {
+			t.Errorf("check(%q) got %t, want %t", test.ip, got, test.want)
+		}
+	}
+
+	if _, err := newIPFilter([]string{"not-an-ip"}, nil); err == nil {
+		t.Error("want error for invalid entry")
+	}
+}
+
+func TestMemHostMapRouteIPFilter(t *testing.T) {
+	m := mustNewMemHostMap(t, map[string]*HostMapEntry{
+		"example.com": {
+			To:     "front:8080",
+			IPDeny: []string{"192.168.9.0/24"},
+			Routes: []*HostRoute{{
+				Prefix:  "/admin/",
+				To:      "admin:80",
+				IPAllow: []string{IPPresetLAN},
+			}},
+		},
+	})
+
+	for _, test := range []struct {
+		path, ip string
+		want     bool
+	}{
+		{"/", "8.8.8.8", true},
+		{"/", "192.168.9.1", false},
+		{"/admin/x", "192.168.1.2", true},
+		{"/admin/x", "8.8.8.8", false},
+		{"/admin/x", "192.168.9.1", false}, // Denied by the host.
+	} {
+		entry := m.mapHost("example.com", test.path)
+		if entry == nil {
+			t.Fatalf("mapHost(%q) got nil", test.path)
+		}
+		got := entry.ipFilter.check(net.ParseIP(test.ip))
+		if got != test.want {
+			t.Errorf(
+				"check(%q) on %q got %t, want %t",
+				test.ip, test.path, got, test.want,
+			)
+		}
+	}
+}
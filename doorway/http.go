@@ -17,10 +17,8 @@ package doorway
 
 import (
 	"log"
-	"net"
 	"net/http"
 	"net/http/httputil"
-	"strings"
 	"time"
 
 	"shanhu.io/g/aries"
@@ -63,8 +61,7 @@ func (s *httpServer) localDirector(req *http.Request) {
 }
 
 func (s *httpServer) Serve(c *aries.C) error {
-	host := strings.TrimSuffix(c.Req.Host, ".")
-	if net.ParseIP(host) != nil || strings.HasSuffix(host, ".local") {
+	if isLocalHost(hostName(c.Req.Host)) {
 		s.proxy.ServeHTTP(c.Resp, c.Req)
 		return nil
 	}
@@ -0,0 +1,134 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"net"
+	"strings"
+
+	"shanhu.io/g/errcode"
+)
+
+// Named IP range presets that can be used in IP allow and deny lists.
+const (
+	IPPresetLAN       = "lan"
+	IPPresetTailscale = "tailscale"
+	IPPresetLoopback  = "loopback"
+)
+
+var ipPresets = map[string][]string{
+	IPPresetLAN: {
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"169.254.0.0/16",
+		"fc00::/7",
+		"fe80::/10",
+	},
+	IPPresetTailscale: {
+		"100.64.0.0/10",
+		"fd7a:115c:a1e0::/48",
+	},
+	IPPresetLoopback: {
+		"127.0.0.0/8",
+		"::1/128",
+	},
+}
+
+// ParseIPList parses a list of CIDRs, IP addresses and named presets.
+func ParseIPList(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if preset, ok := ipPresets[strings.ToLower(entry)]; ok {
+			for _, cidr := range preset {
+				_, n, err := net.ParseCIDR(cidr)
+				if err != nil {
+					panic(err)
+				}
+				nets = append(nets, n)
+			}
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errcode.InvalidArgf("invalid ip range %q", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilter is the IP allow and deny lists of a host or a route.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+
+	// outer is the filter of the host when this is the filter of a
+	// route. It is checked too when not nil.
+	outer *ipFilter
+}
+
+func newIPFilter(allow, deny []string) (*ipFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	allowNets, err := ParseIPList(allow)
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse allow list")
+	}
+	denyNets, err := ParseIPList(deny)
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse deny list")
+	}
+	return &ipFilter{allow: allowNets, deny: denyNets}, nil
+}
+
+// check checks if the IP is allowed. Deny list goes first. When the allow
+// list is not empty, the IP must be in it.
+func (f *ipFilter) check(ip net.IP) bool {
+	if f.outer != nil && !f.outer.check(ip) {
+		return false
+	}
+	if ipInNets(ip, f.deny) {
+		return false
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	return ipInNets(ip, f.allow)
+}
@@ -27,6 +27,10 @@ type localListenConfig struct {
 
 	// addr is the TCP address to listen on. This is used when listener is nil.
 	addr string
+
+	// trustedProxies are the peers that are allowed to send PROXY protocol
+	// headers.
+	trustedProxies []string
 }
 
 type listenConfig struct {
@@ -38,17 +42,26 @@ type listenConfig struct {
 }
 
 func listenLocal(c *localListenConfig) (*tagListener, error) {
-	if c.listener != nil {
-		return newTagListener(c.listener, tagTCP), nil
+	trusted, err := ParseIPList(c.trustedProxies)
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse trusted proxies")
 	}
-	if c.addr == "" {
-		return nil, errcode.InvalidArgf("listen address missing")
+
+	lis := c.listener
+	if lis == nil {
+		if c.addr == "" {
+			return nil, errcode.InvalidArgf("listen address missing")
+		}
+		tcp, err := net.Listen("tcp", c.addr)
+		if err != nil {
+			return nil, errcode.Annotate(err, "listen local")
+		}
+		lis = tcp
 	}
-	tcp, err := net.Listen("tcp", c.addr)
-	if err != nil {
-		return nil, errcode.Annotate(err, "listen local")
+	if len(trusted) > 0 {
+		lis = newProxyListener(lis, trusted)
 	}
-	return newTagListener(tcp, tagTCP), nil
+	return newTagListener(lis, tagTCP), nil
 }
 
 func listen(ctx C, c *listenConfig) (tagConnListener, error) {
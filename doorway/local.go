@@ -0,0 +1,86 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+
+	"shanhu.io/g/errcode"
+)
+
+// LocalConfig is the config for serving HTTPS for hosts on the local
+// network, which are IP addresses and ".local" names. Autocert cannot
+// issue certificates for these hosts, so the certificate is usually
+// issued by a local CA.
+type LocalConfig struct {
+	// Cert is the certificate for the local hosts. It is also served when
+	// the client does not send a server name.
+	Cert *tls.Certificate
+
+	// To is the destination of the local hosts, like HostMapEntry.To.
+	To string
+
+	// IPAllow are the CIDRs, IPs or IP presets that are allowed to visit
+	// the local hosts. Empty for the LAN and loopback presets.
+	IPAllow []string
+}
+
+// LocalFileConfig is the local HTTPS config saved in local.jsonx.
+type LocalFileConfig struct {
+	Key     string   // PEM encoded private key.
+	Certs   string   // PEM encoded certificate bundle.
+	To      string   // Same as LocalConfig.To.
+	IPAllow []string `json:",omitempty"`
+}
+
+// isLocalHost checks if a host is an IP address or a ".local" name.
+func isLocalHost(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	return net.ParseIP(host) != nil || strings.HasSuffix(host, ".local")
+}
+
+// LocalRoute is the route name of requests to local names and IP
+// addresses in access logs and metrics.
+const LocalRoute = "local"
+
+func newLocalEntry(config *LocalConfig) (*hostEntry, error) {
+	allow := config.IPAllow
+	if len(allow) == 0 {
+		allow = []string{IPPresetLAN, IPPresetLoopback}
+	}
+	filter, err := newIPFilter(allow, nil)
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse ip allow list")
+	}
+	entry := parseHostDest(config.To)
+	entry.ipFilter = filter
+	entry.key = LocalRoute
+	return entry, nil
+}
+
+// wrapLocalCert serves the local certificate for local hosts, and for
+// clients that do not send a server name, which are usually visiting with
+// an IP address.
+func wrapLocalCert(f getCertFunc, cert *tls.Certificate) getCertFunc {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == "" || isLocalHost(hello.ServerName) {
+			return cert, nil
+		}
+		return f(hello)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shanhu.io/g/aries"
+)
+
+func TestIsLocalHost(t *testing.T) {
+	for _, test := range []struct {
+		host string
+		want bool
+	}{
+		{"192.168.1.10", true},
+		{"2001:db8::10", true},
+		{"mybox.local", true},
+		{"mybox.local.", true},
+		{"example.com", false},
+		{"local.example.com", false},
+	} {
+		if got := isLocalHost(test.host); got != test.want {
+			t.Errorf("isLocalHost(%q) = %t, want %t", test.host, got, test.want)
+		}
+	}
+}
+
+func TestWrapLocalCert(t *testing.T) {
+	local := new(tls.Certificate)
+	other := new(tls.Certificate)
+	f := wrapLocalCert(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return other, nil
+	}, local)
+
+	for _, test := range []struct {
+		name string
+		want *tls.Certificate
+	}{
+		{"", local},
+		{"mybox.local", local},
+		{"example.com", other},
+	} {
+		got, err := f(&tls.ClientHelloInfo{ServerName: test.name})
+		if err != nil {
+			t.Fatalf("get cert for %q: %s", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("get cert for %q: got the wrong cert", test.name)
+		}
+	}
+}
+
+func TestLocalEntry(t *testing.T) {
+	entry, err := newLocalEntry(&LocalConfig{To: HomeHost})
+	if err != nil {
+		t.Fatal("new local entry: ", err)
+	}
+	if entry.typ != hostHome {
+		t.Errorf("got entry type %d, want home", entry.typ)
+	}
+	if entry.ipFilter == nil {
+		t.Fatal("want default ip filter")
+	}
+	for _, test := range []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.20", true},
+		{"127.0.0.1", true},
+		{"8.8.8.8", false},
+	} {
+		got := entry.ipFilter.check(net.ParseIP(test.ip))
+		if got != test.want {
+			t.Errorf("check %q: got %t, want %t", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestServeLocal(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, "local")
+		},
+	))
+	defer up.Close()
+
+	s, err := newServer(&ServerConfig{
+		HostMap: map[string]*HostMapEntry{
+			"example.com": {To: HomeHost},
+		},
+		Local: &LocalConfig{To: lisAddr(up.Listener)},
+	})
+	if err != nil {
+		t.Fatal("new server: ", err)
+	}
+	h := aries.Serve(s)
+
+	for _, host := range []string{"foo.local", "192.168.1.2"} {
+		req := httptest.NewRequest("GET", "https://"+host+"/x", nil)
+		req.RemoteAddr = "192.168.1.5:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if got := w.Body.String(); got != "local" {
+			t.Errorf("get %q: got %q, want %q", host, got, "local")
+		}
+	}
+}
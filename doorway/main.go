@@ -28,6 +28,9 @@ func Main() {
 		httpsAddr = flag.String("https", ":8443", "HTTPS address to listen on.")
 		httpAddr  = flag.String("http", ":8080", "HTTP address to listen on.")
 		home      = flag.String("home", ".", "home directory")
+		adminAddr = flag.String(
+			"admin", DefaultAdminAddr, "Admin address to listen on.",
+		)
 	)
 	flag.Parse()
 
@@ -39,6 +42,7 @@ func Main() {
 	}
 
 	config.LocalAddr = *httpsAddr
+	config.AdminAddr = *adminAddr
 	if *httpAddr != "" {
 		config.HTTPServer.Addr = *httpAddr
 	}
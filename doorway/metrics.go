@@ -0,0 +1,208 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"shanhu.io/g/aries"
+	"shanhu.io/homedrv/drv/metrics"
+)
+
+// doorwayMetrics are the metrics of doorway, served on the admin server.
+type doorwayMetrics struct {
+	reg *metrics.Registry
+
+	requests          *metrics.Counter
+	requestDuration   *metrics.Histogram
+	activeConns       *metrics.Gauge
+	fabricsReconnects *metrics.Counter
+	fabricsErrors     *metrics.Counter
+	tlsHandshakeFails *metrics.Counter
+	tlsProxyForwards  *metrics.Counter
+	tlsProxyDrops     *metrics.Counter
+	certExpiry        *metrics.Gauge
+	upstreamUp        *metrics.Gauge
+}
+
+func newDoorwayMetrics() *doorwayMetrics {
+	r := metrics.NewRegistry()
+	return &doorwayMetrics{
+		reg: r,
+		requests: r.NewCounter(
+			"doorway_http_requests_total",
+			"Number of HTTPS requests served.",
+			"host", "code",
+		),
+		requestDuration: r.NewHistogram(
+			"doorway_http_request_duration_seconds",
+			"Latency of HTTPS requests.",
+			nil, "host", "code",
+		),
+		activeConns: r.NewGauge(
+			"doorway_active_connections",
+			"Number of open connections by listener.",
+			"listener",
+		),
+		fabricsReconnects: r.NewCounter(
+			"doorway_fabrics_reconnects_total",
+			"Number of times the fabrics listener reconnected.",
+		),
+		fabricsErrors: r.NewCounter(
+			"doorway_fabrics_errors_total",
+			"Number of errors on listening or accepting on fabrics.",
+		),
+		tlsHandshakeFails: r.NewCounter(
+			"doorway_tls_handshake_failures_total",
+			"Number of failed TLS handshakes.",
+		),
+		tlsProxyForwards: r.NewCounter(
+			"doorway_tls_proxy_forwards_total",
+			"Number of TLS connections forwarded by the TLS proxy.",
+			"domain",
+		),
+		tlsProxyDrops: r.NewCounter(
+			"doorway_tls_proxy_drops_total",
+			"Number of connections dropped by the TLS proxy.",
+			"reason",
+		),
+		certExpiry: r.NewGauge(
+			"doorway_cert_expiry_timestamp_seconds",
+			"Expiry time of the last served certificate of a domain.",
+			"domain",
+		),
+		upstreamUp: r.NewGauge(
+			"doorway_upstream_up",
+			"1 if the upstream passed its last health check.",
+			"upstream",
+		),
+	}
+}
+
+// stats is the global metrics of doorway.
+var stats = newDoorwayMetrics()
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (m *doorwayMetrics) observeRequest(entry *AccessLogEntry) {
+	// Labels with the matched host map key rather than the Host header,
+	// so that clients cannot inflate the label set with random hosts
+	// under wildcard entries.
+	host := entry.Route
+	if host == "" || entry.Upstream == "" {
+		host = "unknown"
+	}
+	code := strconv.Itoa(entry.Status)
+	m.requests.Inc(host, code)
+	m.requestDuration.Observe(entry.Duration.Seconds(), host, code)
+}
+
+func (m *doorwayMetrics) serve(c *aries.C) error {
+	m.reg.ServeHTTP(c.Resp, c.Req)
+	return nil
+}
+
+// certLeaf returns the parsed leaf certificate of cert.
+func certLeaf(cert *tls.Certificate) *x509.Certificate {
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+	if len(cert.Certificate) == 0 {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+func certDomain(leaf *x509.Certificate) string {
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	return leaf.Subject.CommonName
+}
+
+func (m *doorwayMetrics) observeCert(cert *tls.Certificate) {
+	leaf := certLeaf(cert)
+	if leaf == nil {
+		return
+	}
+	if domain := certDomain(leaf); domain != "" {
+		m.certExpiry.Set(float64(leaf.NotAfter.Unix()), domain)
+	}
+}
+
+// wrapCertMetrics records the expiry time of the certificates that are
+// served.
+func (m *doorwayMetrics) wrapCertMetrics(f getCertFunc) getCertFunc {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := f(hello)
+		if err == nil && cert != nil {
+			m.observeCert(cert)
+		}
+		return cert, err
+	}
+}
+
+// handshakeErrorLog counts TLS handshake errors from the error log of the
+// http server, and passes the log lines through.
+type handshakeErrorLog struct {
+	m *doorwayMetrics
+}
+
+func (l *handshakeErrorLog) Write(bs []byte) (int, error) {
+	if bytes.Contains(bs, []byte("TLS handshake error")) {
+		l.m.tlsHandshakeFails.Inc()
+	}
+	log.Print(strings.TrimSuffix(string(bs), "\n"))
+	return len(bs), nil
+}
+
+func (m *doorwayMetrics) errorLog() *log.Logger {
+	return log.New(&handshakeErrorLog{m: m}, "", 0)
+}
+
+// countConn decreases the active connection gauge when closed.
+type countConn struct {
+	net.Conn
+	m         *doorwayMetrics
+	tag       string
+	closeOnce sync.Once
+}
+
+func (m *doorwayMetrics) trackConn(conn net.Conn, tag string) net.Conn {
+	m.activeConns.Inc(tag)
+	return &countConn{Conn: conn, m: m, tag: tag}
+}
+
+func (c *countConn) Close() error {
+	c.closeOnce.Do(func() { c.m.activeConns.Dec(c.tag) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,254 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/netutil"
+)
+
+// Protocols for port forwarding.
+const (
+	ProtoTCP = "tcp"
+	ProtoUDP = "udp"
+)
+
+// PortForward is a rule to forward a TCP or UDP port to an upstream,
+// for services that are not HTTP.
+type PortForward struct {
+	Protocol string // ProtoTCP or ProtoUDP.
+	Port     int    // Port to listen on.
+	To       string // Upstream address.
+}
+
+func (f *PortForward) addr() string {
+	return net.JoinHostPort("", strconv.Itoa(f.Port))
+}
+
+// udpIdleTimeout is how long a UDP session is kept without any packet from
+// the upstream.
+const udpIdleTimeout = 2 * time.Minute
+
+// maxUDPSessions is the maximum number of concurrent UDP sessions of a
+// port forward. Client addresses of UDP packets can be spoofed, so the
+// number of upstream sockets must be bounded.
+const maxUDPSessions = 1024
+
+var errTooManyUDPSessions = errors.New("too many udp sessions")
+
+func forwardTCPConn(ctx context.Context, conn net.Conn, to string) {
+	defer conn.Close()
+
+	var d net.Dialer
+	forward, err := d.DialContext(ctx, "tcp", to)
+	if err != nil {
+		log.Printf("dial %q for port forwarding: %s", to, err)
+		return
+	}
+	_ = netutil.JoinConn(ctx, conn, forward) // do not care about the error.
+}
+
+func forwardTCP(ctx context.Context, lis net.Listener, to string) {
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("port forward to %q: %s", to, err)
+			}
+			return
+		}
+		go forwardTCPConn(ctx, conn, to)
+	}
+}
+
+// udpForwarder forwards UDP packets from clients to an upstream. Each
+// client address gets its own upstream socket, so that replies can be sent
+// back to the right client.
+type udpForwarder struct {
+	conn net.PacketConn
+	to   string
+
+	maxSessions int
+
+	mu       sync.Mutex
+	sessions map[string]net.Conn
+}
+
+func (f *udpForwarder) session(client net.Addr) (net.Conn, error) {
+	k := client.String()
+
+	// lookup returns the existing session, or checks if a new one can be
+	// added.
+	lookup := func() (net.Conn, error) {
+		if up, ok := f.sessions[k]; ok {
+			return up, nil
+		}
+		if len(f.sessions) >= f.maxSessions {
+			return nil, errTooManyUDPSessions
+		}
+		return nil, nil
+	}
+
+	f.mu.Lock()
+	up, err := lookup()
+	f.mu.Unlock()
+	if up != nil || err != nil {
+		return up, err
+	}
+
+	// Dials without holding the lock, as resolving the address can block.
+	newUp, err := net.Dial("udp", f.to)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Checks again, as the lock was released while dialing.
+	if up, err := lookup(); up != nil || err != nil {
+		newUp.Close()
+		return up, err
+	}
+	f.sessions[k] = newUp
+	go f.reply(k, client, newUp)
+	return newUp, nil
+}
+
+func (f *udpForwarder) reply(k string, client net.Addr, up net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.sessions, k)
+		f.mu.Unlock()
+		up.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		up.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		n, err := up.Read(buf)
+		if err != nil {
+			return // Timeout or closed.
+		}
+		if _, err := f.conn.WriteTo(buf[:n], client); err != nil {
+			return
+		}
+	}
+}
+
+func (f *udpForwarder) closeSessions() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, up := range f.sessions {
+		up.Close()
+	}
+}
+
+func (f *udpForwarder) serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		f.conn.Close()
+	}()
+	defer f.closeSessions()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, client, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("port forward to %q: %s", f.to, err)
+			}
+			return
+		}
+		up, err := f.session(client)
+		if err == errTooManyUDPSessions {
+			continue // Drop the packet; no log to avoid flooding.
+		} else if err != nil {
+			log.Printf("dial %q for port forwarding: %s", f.to, err)
+			continue
+		}
+		if _, err := up.Write(buf[:n]); err != nil {
+			log.Printf("forward udp to %q: %s", f.to, err)
+		}
+	}
+}
+
+// listenPortForwards listens on all the ports, and starts forwarding in
+// the background until ctx is done.
+func listenPortForwards(ctx context.Context, rules []*PortForward) error {
+	var starts []func()
+	var closers []func() error
+
+	fail := func(err error) error {
+		for _, c := range closers {
+			c()
+		}
+		return err
+	}
+
+	for _, r := range rules {
+		switch r.Protocol {
+		case ProtoTCP, "":
+			lis, err := net.Listen("tcp", r.addr())
+			if err != nil {
+				return fail(errcode.Annotatef(
+					err, "listen tcp port %d", r.Port,
+				))
+			}
+			closers = append(closers, lis.Close)
+			starts = append(starts, func() { forwardTCP(ctx, lis, r.To) })
+		case ProtoUDP:
+			conn, err := net.ListenPacket("udp", r.addr())
+			if err != nil {
+				return fail(errcode.Annotatef(
+					err, "listen udp port %d", r.Port,
+				))
+			}
+			closers = append(closers, conn.Close)
+			f := &udpForwarder{
+				conn:        conn,
+				to:          r.To,
+				maxSessions: maxUDPSessions,
+				sessions:    make(map[string]net.Conn),
+			}
+			starts = append(starts, func() { f.serve(ctx) })
+		default:
+			return fail(errcode.InvalidArgf(
+				"unknown protocol %q for port %d", r.Protocol, r.Port,
+			))
+		}
+	}
+
+	for _, r := range rules {
+		log.Printf("forward %s port %d to %q", r.Protocol, r.Port, r.To)
+	}
+	for _, start := range starts {
+		go start()
+	}
+	return nil
+}
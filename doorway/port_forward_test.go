@@ -0,0 +1,150 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T, network string) int {
+	t.Helper()
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestPortForwards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// TCP echo upstream.
+	tcpUp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpUp.Close()
+	go func() {
+		for {
+			conn, err := tcpUp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	// UDP echo upstream.
+	udpUp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpUp.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := udpUp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udpUp.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	tcpPort := freePort(t, "tcp")
+	udpPort := freePort(t, "udp")
+	rules := []*PortForward{{
+		Protocol: ProtoTCP,
+		Port:     tcpPort,
+		To:       tcpUp.Addr().String(),
+	}, {
+		Protocol: ProtoUDP,
+		Port:     udpPort,
+		To:       udpUp.LocalAddr().String(),
+	}}
+	if err := listenPortForwards(ctx, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, network := range []string{"tcp", "udp"} {
+		port := tcpPort
+		if network == "udp" {
+			port = udpPort
+		}
+		addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("%s: read echo: %s", network, err)
+		}
+		if got := string(buf); got != "hello" {
+			t.Errorf("%s: got %q, want hello", network, got)
+		}
+		conn.Close()
+	}
+}
+
+func TestUDPSessionLimit(t *testing.T) {
+	to := net.JoinHostPort("127.0.0.1", strconv.Itoa(freePort(t, "udp")))
+	f := &udpForwarder{
+		to:          to,
+		maxSessions: 1,
+		sessions:    make(map[string]net.Conn),
+	}
+	defer f.closeSessions()
+
+	client1 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10001}
+	client2 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10002}
+	up, err := f.session(client1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := f.session(client1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != up {
+		t.Error("session of the same client not reused")
+	}
+	if _, err := f.session(client2); err != errTooManyUDPSessions {
+		t.Errorf("got error %v, want errTooManyUDPSessions", err)
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shanhu.io/g/errcode"
+)
+
+// PROXY protocol versions.
+const (
+	ProxyProtocolNone = 0
+	ProxyProtocolV1   = 1
+	ProxyProtocolV2   = 2
+)
+
+// proxyHeaderTimeout is the time limit for a trusted peer to send the
+// PROXY protocol header after the connection is accepted.
+const proxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const proxyV1Prefix = "PROXY "
+
+// proxyV1MaxLen is the maximum length of a v1 header, including the CRLF.
+const proxyV1MaxLen = 107
+
+// readProxyHeader reads the PROXY protocol header from r. It returns a nil
+// address when there is no header, or when the header does not carry an
+// address, like a v1 UNKNOWN or a v2 LOCAL header.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	bs, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if string(bs) == proxyV1Prefix {
+		return readProxyV1(r)
+	}
+	if !bytes.HasPrefix(proxyV2Sig, bs) {
+		return nil, nil
+	}
+
+	bs, err = r.Peek(len(proxyV2Sig))
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !bytes.Equal(bs, proxyV2Sig) {
+		return nil, nil
+	}
+	return readProxyV2(r)
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, errcode.Annotate(err, "read v1 header")
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLen {
+			return nil, errcode.InvalidArgf("v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errcode.InvalidArgf("v1 header not ending with CRLF")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, errcode.InvalidArgf("invalid v1 header")
+	}
+	switch fields[1] {
+	case "TCP4", "TCP6":
+	default:
+		return nil, errcode.InvalidArgf("unknown protocol %q", fields[1])
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, errcode.InvalidArgf("invalid source ip %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errcode.InvalidArgf("invalid source port %q", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errcode.Annotate(err, "read v2 header")
+	}
+	verCmd := header[12]
+	if verCmd>>4 != 2 {
+		return nil, errcode.InvalidArgf("invalid v2 version %d", verCmd>>4)
+	}
+	fam := header[13]
+	n := binary.BigEndian.Uint16(header[14:16])
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, errcode.Annotate(err, "read v2 addresses")
+	}
+
+	switch verCmd & 0xf {
+	case 0: // LOCAL, health checks from the proxy itself.
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errcode.InvalidArgf("invalid v2 command %d", verCmd&0xf)
+	}
+
+	var ipLen int
+	switch fam {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		return nil, nil // Unspecified or unsupported; keep the peer address.
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, errcode.InvalidArgf("v2 addresses too short")
+	}
+	ip := make(net.IP, ipLen)
+	copy(ip, body[:ipLen])
+	port := binary.BigEndian.Uint16(body[2*ipLen:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// tcpAddrOf converts a net.Addr to a TCP address. Fabrics addresses are
+// prefixed with "|", which is trimmed. Returns nil if the address is not
+// an IP address.
+func tcpAddrOf(a net.Addr) *net.TCPAddr {
+	if a == nil {
+		return nil
+	}
+	if tcp, ok := a.(*net.TCPAddr); ok {
+		return tcp
+	}
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(a.String(), "|"))
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}
+}
+
+// writeProxyHeader writes a PROXY protocol header of the given version,
+// with src being the client address and dst being the address the client
+// connected to.
+func writeProxyHeader(w io.Writer, version int, src, dst net.Addr) error {
+	srcTCP := tcpAddrOf(src)
+	dstTCP := tcpAddrOf(dst)
+
+	var srcIP, dstIP net.IP
+	v4 := false
+	if srcTCP != nil && dstTCP != nil {
+		srcIP, dstIP = srcTCP.IP.To4(), dstTCP.IP.To4()
+		if srcIP != nil && dstIP != nil {
+			v4 = true
+		} else {
+			srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+		}
+	}
+	known := srcIP != nil && dstIP != nil
+
+	switch version {
+	case ProxyProtocolV1:
+		if !known {
+			_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+			return err
+		}
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		}
+		_, err := fmt.Fprintf(
+			w, "PROXY %s %s %s %d %d\r\n",
+			proto, srcIP, dstIP, srcTCP.Port, dstTCP.Port,
+		)
+		return err
+	case ProxyProtocolV2:
+		buf := new(bytes.Buffer)
+		buf.Write(proxyV2Sig)
+		if !known {
+			buf.Write([]byte{0x20, 0x00, 0, 0}) // LOCAL
+			_, err := w.Write(buf.Bytes())
+			return err
+		}
+		fam := byte(0x21)
+		if v4 {
+			fam = 0x11
+		}
+		buf.Write([]byte{0x21, fam})
+		var ports [4]byte
+		binary.BigEndian.PutUint16(ports[:2], uint16(srcTCP.Port))
+		binary.BigEndian.PutUint16(ports[2:], uint16(dstTCP.Port))
+		n := 2*len(srcIP) + len(ports)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+		buf.Write(srcIP)
+		buf.Write(dstIP)
+		buf.Write(ports[:])
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return errcode.InvalidArgf("unknown proxy protocol version %d", version)
+}
+
+// proxyConn is a connection with the remote address taken from the PROXY
+// protocol header.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(buf []byte) (int, error) { return c.r.Read(buf) }
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// proxyListener accepts PROXY protocol headers from trusted peers.
+// Connections from other peers are passed through as is. Headers are
+// read in separate goroutines, so that a slow peer does not block
+// accepting others.
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newProxyListener(
+	lis net.Listener, trusted []*net.IPNet,
+) *proxyListener {
+	l := &proxyListener{
+		Listener: lis,
+		trusted:  trusted,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		closed:   make(chan struct{}),
+	}
+	go l.bg()
+	return l
+}
+
+func (l *proxyListener) isTrusted(conn net.Conn) bool {
+	addr := tcpAddrOf(conn.RemoteAddr())
+	if addr == nil {
+		return false
+	}
+	return ipInNets(addr.IP, l.trusted)
+}
+
+func (l *proxyListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *proxyListener) handshake(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	remote, err := readProxyHeader(r)
+	if err != nil {
+		log.Printf(
+			"read proxy header from %s: %s", conn.RemoteAddr(), err,
+		)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	l.deliver(&proxyConn{Conn: conn, r: r, remote: remote})
+}
+
+// Delays of retrying after failing to accept, like http.Server.
+const (
+	minAcceptRetryDelay = 5 * time.Millisecond
+	maxAcceptRetryDelay = time.Second
+)
+
+func (l *proxyListener) bg() {
+	var delay time.Duration
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				l.errs <- err
+				return
+			}
+
+			// Errors like running out of file descriptors are temporary.
+			if delay == 0 {
+				delay = minAcceptRetryDelay
+			} else if delay *= 2; delay > maxAcceptRetryDelay {
+				delay = maxAcceptRetryDelay
+			}
+			log.Printf("accept error: %s; retrying in %s", err, delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-l.closed:
+				l.errs <- net.ErrClosed
+				return
+			}
+		}
+		delay = 0
+		if !l.isTrusted(conn) {
+			l.deliver(conn)
+			continue
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		l.errs <- err // Keep returning the error.
+		return nil, err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
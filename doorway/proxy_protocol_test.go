@@ -0,0 +1,199 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestProxyHeader(t *testing.T) {
+	for _, test := range []struct {
+		version  int
+		src, dst string
+	}{
+		{ProxyProtocolV1, "203.0.113.7:51234", "192.168.1.2:443"},
+		{ProxyProtocolV2, "203.0.113.7:51234", "192.168.1.2:443"},
+		{ProxyProtocolV1, "[2001:db8::7]:51234", "[2001:db8::1]:443"},
+		{ProxyProtocolV2, "[2001:db8::7]:51234", "[2001:db8::1]:443"},
+	} {
+		src, err := net.ResolveTCPAddr("tcp", test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := net.ResolveTCPAddr("tcp", test.dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := writeProxyHeader(buf, test.version, src, dst); err != nil {
+			t.Fatalf("write v%d header: %s", test.version, err)
+		}
+		buf.WriteString("payload")
+
+		r := bufio.NewReader(buf)
+		got, err := readProxyHeader(r)
+		if err != nil {
+			t.Fatalf("read v%d header: %s", test.version, err)
+		}
+		if got == nil || got.String() != test.src {
+			t.Errorf("v%d header got %v, want %s", test.version, got, test.src)
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rest) != "payload" {
+			t.Errorf("v%d got payload %q", test.version, rest)
+		}
+	}
+}
+
+func TestProxyHeaderAbsent(t *testing.T) {
+	for _, input := range []string{
+		"\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03",
+		"GET / HTTP/1.1\r\n",
+		"PRO",
+	} {
+		r := bufio.NewReader(strings.NewReader(input))
+		got, err := readProxyHeader(r)
+		if err != nil {
+			t.Errorf("read %q: %s", input, err)
+			continue
+		}
+		if got != nil {
+			t.Errorf("read %q, got address %s", input, got)
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rest) != input {
+			t.Errorf("input consumed, got %q, want %q", rest, input)
+		}
+	}
+}
+
+func TestProxyHeaderUnknown(t *testing.T) {
+	for _, version := range []int{ProxyProtocolV1, ProxyProtocolV2} {
+		buf := new(bytes.Buffer)
+		src := &net.UnixAddr{Name: "/run/doorway.sock", Net: "unix"}
+		if err := writeProxyHeader(buf, version, src, nil); err != nil {
+			t.Fatalf("write v%d header: %s", version, err)
+		}
+		got, err := readProxyHeader(bufio.NewReader(buf))
+		if err != nil {
+			t.Fatalf("read v%d header: %s", version, err)
+		}
+		if got != nil {
+			t.Errorf("v%d header got address %s, want nil", version, got)
+		}
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := ParseIPList([]string{IPPresetLoopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := newProxyListener(tcp, trusted)
+	defer lis.Close()
+
+	// A trusted peer that never sends anything must not block others.
+	idle, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	client, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	const header = "PROXY TCP4 198.51.100.3 127.0.0.1 40000 443\r\n"
+	if _, err := io.WriteString(client, header+"hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := lis.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const want = "198.51.100.3:40000"
+	if got := conn.RemoteAddr().String(); got != want {
+		t.Errorf("got remote addr %q, want %q", got, want)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("got %q, want hello", buf)
+	}
+}
+
+// flakyListener fails the first accept with a temporary error.
+type flakyListener struct {
+	net.Listener
+	failed bool
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if !l.failed {
+		l.failed = true
+		return nil, errors.New("too many open files")
+	}
+	return l.Listener.Accept()
+}
+
+func TestProxyListenerRetry(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := newProxyListener(&flakyListener{Listener: tcp}, nil)
+
+	client, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := lis.Accept()
+	if err != nil {
+		t.Fatal("accept after a temporary error: ", err)
+	}
+	conn.Close()
+
+	lis.Close()
+	if _, err := lis.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("accept after close: got %v, want net.ErrClosed", err)
+	}
+}
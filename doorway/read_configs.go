@@ -28,10 +28,11 @@ import (
 	"shanhu.io/g/errcode"
 	"shanhu.io/g/jsonx"
 	"shanhu.io/g/osutil"
+	"shanhu.io/homedrv/drv/homedial"
 )
 
-func readHostMap(p string) (map[string]string, error) {
-	m := make(map[string]string)
+func readHostMap(p string) (map[string]*HostMapEntry, error) {
+	m := make(map[string]*HostMapEntry)
 	if err := jsonx.ReadFile(p, &m); err != nil {
 		return nil, err
 	}
@@ -70,6 +71,120 @@ func readManualCerts(h *osutil.Home) (map[string]*tls.Certificate, error) {
 	return certs, nil
 }
 
+func readLocalConfig(h *osutil.Home) (*LocalConfig, error) {
+	entry := new(LocalFileConfig)
+	if err := jsonx.ReadFile(h.Etc("local.jsonx"), entry); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errcode.Annotate(err, "read local.jsonx")
+	}
+	cert, err := tls.X509KeyPair([]byte(entry.Certs), []byte(entry.Key))
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse local cert")
+	}
+	return &LocalConfig{
+		Cert:    &cert,
+		To:      entry.To,
+		IPAllow: entry.IPAllow,
+	}, nil
+}
+
+func readAdminToken(h *osutil.Home) (string, error) {
+	bs, err := os.ReadFile(h.Etc("admin-token"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+func readDNS01Config(h *osutil.Home) (*DNS01Config, error) {
+	c := new(DNS01Config)
+	if err := jsonx.ReadFile(h.Etc("dns01.jsonx"), c); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func readClientAuth(h *osutil.Home) (*ClientAuthConfig, error) {
+	c := new(ClientAuthConfig)
+	if err := jsonx.ReadFile(h.Etc("client-auth.jsonx"), c); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func readErrorPages(h *osutil.Home) (map[string]string, error) {
+	pages := make(map[string]string)
+	for _, name := range []string{
+		ErrorPageUnavailable, ErrorPageMaintenance,
+	} {
+		bs, err := os.ReadFile(h.Etc(name + ".html"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errcode.Annotatef(err, "read %q page", name)
+		}
+		pages[name] = string(bs)
+	}
+	return pages, nil
+}
+
+func readMaintenance(h *osutil.Home) (map[string]*HostMaintenance, error) {
+	m := make(map[string]*HostMaintenance)
+	if err := jsonx.ReadFile(h.Etc("maintenance.jsonx"), &m); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+func readPortForwards(h *osutil.Home) ([]*PortForward, error) {
+	var rules []*PortForward
+	if err := jsonx.ReadFile(h.Etc("ports.jsonx"), &rules); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return rules, nil
+}
+
+func readTrustedProxies(h *osutil.Home) ([]string, error) {
+	var list []string
+	p := h.Etc("trusted-proxies.jsonx")
+	if err := jsonx.ReadFile(p, &list); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return list, nil
+}
+
+func readDialConfig(h *osutil.Home) (*homedial.Config, error) {
+	c := new(homedial.Config)
+	if err := jsonx.ReadFile(h.Etc("dial.jsonx"), c); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
 func removeCertsBefore(dir string, t time.Time) error {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -133,10 +248,40 @@ func serverConfigFromHome(h *osutil.Home) (*ServerConfig, error) {
 		return nil, errcode.Annotate(err, "load manual certs")
 	}
 
+	dns01, err := readDNS01Config(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read dns01 config")
+	}
+
+	errorPages, err := readErrorPages(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read error pages")
+	}
+
+	maintenance, err := readMaintenance(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read maintenance hosts")
+	}
+
+	clientAuth, err := readClientAuth(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read client auth config")
+	}
+
+	local, err := readLocalConfig(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read local config")
+	}
+
 	return &ServerConfig{
 		HostMap:       hostMap,
 		AutoCertCache: autocert.DirCache(certCacheDir),
 		ManualCerts:   manualCerts,
+		DNS01:         dns01,
+		ErrorPages:    errorPages,
+		Maintenance:   maintenance,
+		ClientAuth:    clientAuth,
+		Local:         local,
 	}, nil
 }
 
@@ -171,7 +316,13 @@ func ConfigFromHome(homeDir string) (*Config, error) {
 		return nil, errcode.Annotate(err, "make home")
 	}
 
-	c := new(Config)
+	c := &Config{AccessLog: h.Var("access.log")}
+
+	adminToken, err := readAdminToken(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read admin token")
+	}
+	c.AdminToken = adminToken
 
 	serverConfig, err := serverConfigFromHome(h)
 	if err != nil {
@@ -185,6 +336,24 @@ func ConfigFromHome(homeDir string) (*Config, error) {
 	}
 	c.HTTPServer = httpConfig
 
+	ports, err := readPortForwards(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read port forwards")
+	}
+	c.PortForwards = ports
+
+	trusted, err := readTrustedProxies(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read trusted proxies")
+	}
+	c.TrustedProxies = trusted
+
+	dial, err := readDialConfig(h)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read dial config")
+	}
+	c.Dial = dial
+
 	fabConfig, err := fabricsConfigFromHome(h)
 	if err != nil {
 		return nil, errcode.Annotate(err, "read fabrics config")
@@ -19,18 +19,125 @@ import (
 	"errors"
 	"io"
 	"log"
+	"math/rand"
 	"net"
 	"sync"
 	"time"
+
+	"shanhu.io/g/errcode"
 )
 
+// ReconnectBackoff is the backoff config for reconnecting a listener.
+// The n-th retry waits InitialSeconds * Multiplier^n, capped at
+// MaxSeconds, and then randomized by Jitter. Fields that are zero use the
+// defaults.
+type ReconnectBackoff struct {
+	InitialSeconds float64 `json:",omitempty"`
+	MaxSeconds     float64 `json:",omitempty"`
+
+	// Multiplier must be at least 1.
+	Multiplier float64 `json:",omitempty"`
+
+	// Jitter is the fraction to randomize the delay with, from 0 to 1. With
+	// a jitter of 0.2, the delay is randomized within +/- 20%.
+	Jitter float64 `json:",omitempty"`
+}
+
+func secondsDuration(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
+
+// backoff checks the config, and fills zero fields with the defaults.
+func (b *ReconnectBackoff) backoff() (*reconnectBackoff, error) {
+	ret := defaultReconnectBackoff()
+	if b == nil {
+		return ret, nil
+	}
+	if b.InitialSeconds < 0 || b.MaxSeconds < 0 {
+		return nil, errcode.InvalidArgf("negative backoff delay")
+	}
+	if b.Multiplier != 0 && b.Multiplier < 1 {
+		return nil, errcode.InvalidArgf(
+			"backoff multiplier %g is less than 1", b.Multiplier,
+		)
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return nil, errcode.InvalidArgf(
+			"backoff jitter %g is not within 0 and 1", b.Jitter,
+		)
+	}
+
+	if b.InitialSeconds > 0 {
+		ret.initial = secondsDuration(b.InitialSeconds)
+	}
+	if b.MaxSeconds > 0 {
+		ret.max = secondsDuration(b.MaxSeconds)
+	}
+	if ret.max < ret.initial {
+		return nil, errcode.InvalidArgf("max backoff less than initial")
+	}
+	if b.Multiplier != 0 {
+		ret.multiplier = b.Multiplier
+	}
+	if b.Jitter != 0 {
+		ret.jitter = b.Jitter
+	}
+	return ret, nil
+}
+
+type reconnectBackoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+}
+
+func defaultReconnectBackoff() *reconnectBackoff {
+	return &reconnectBackoff{
+		initial:    time.Second,
+		max:        time.Minute,
+		multiplier: 2,
+		jitter:     0.2,
+	}
+}
+
+func (b *reconnectBackoff) delay(attempt int, r float64) time.Duration {
+	d := float64(b.initial)
+	for i := 0; i < attempt && d < float64(b.max); i++ {
+		d *= b.multiplier
+	}
+	if b.max > 0 && d > float64(b.max) {
+		d = float64(b.max)
+	}
+	if b.jitter > 0 {
+		d *= 1 + b.jitter*(2*r-1)
+	}
+	return time.Duration(d)
+}
+
+// ReconnectStatus is the connection status of a reconnecting listener.
+type ReconnectStatus struct {
+	Connected bool
+	Addr      string    `json:",omitempty"` // Address when connected.
+	Since     time.Time // Time of getting connected or disconnected.
+
+	// Attempts is the number of failed attempts since disconnected.
+	Attempts int
+
+	LastError     string    `json:",omitempty"`
+	LastErrorTime time.Time `json:",omitempty"`
+	NextRetry     time.Time `json:",omitempty"`
+}
+
 type reconnectListener struct {
 	listen        func() (net.Listener, error)
 	errorCallback func(err error)
+	backoff       *reconnectBackoff
 
-	mu   sync.Mutex
-	addr net.Addr
-	conn chan net.Conn
+	mu     sync.Mutex
+	addr   net.Addr
+	status ReconnectStatus
+	conn   chan net.Conn
 
 	closeOnce sync.Once
 	closed    chan struct{}
@@ -39,27 +146,76 @@ type reconnectListener struct {
 func newReconnectListener(
 	listen func() (net.Listener, error),
 	onError func(err error),
+	backoff *reconnectBackoff,
 ) (*reconnectListener, error) {
 	first, err := listen()
 	if err != nil {
 		return nil, err
 	}
+	if backoff == nil {
+		backoff = defaultReconnectBackoff()
+	}
 
 	lis := &reconnectListener{
 		listen:        listen,
 		errorCallback: onError,
-		addr:          first.Addr(),
+		backoff:       backoff,
 		conn:          make(chan net.Conn),
 		closed:        make(chan struct{}),
 	}
+	lis.setConnected(first.Addr())
 	go lis.bg(first)
 	return lis, nil
 }
 
-func (l *reconnectListener) setAddr(addr net.Addr) {
+func (l *reconnectListener) setConnected(addr net.Addr) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.addr = addr
+	l.status = ReconnectStatus{
+		Connected: true,
+		Addr:      addr.String(),
+		Since:     time.Now(),
+		LastError: l.status.LastError,
+
+		LastErrorTime: l.status.LastErrorTime,
+	}
+}
+
+func (l *reconnectListener) setDisconnected(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.status.Connected = false
+	l.status.Addr = ""
+	l.status.Since = now
+	l.status.Attempts = 0
+	if err != nil {
+		l.status.LastError = err.Error()
+		l.status.LastErrorTime = now
+	}
+}
+
+func (l *reconnectListener) setFailed(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status.Attempts++
+	l.status.LastError = err.Error()
+	l.status.LastErrorTime = time.Now()
+}
+
+func (l *reconnectListener) setNextRetry(next time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status.NextRetry = next
+}
+
+// Status returns the connection status.
+func (l *reconnectListener) Status() *ReconnectStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := l.status
+	return &status
 }
 
 func (l *reconnectListener) isClosed() bool {
@@ -81,6 +237,7 @@ func (l *reconnectListener) Close() error {
 }
 
 func (l *reconnectListener) callback(err error) {
+	stats.fabricsErrors.Inc()
 	if l.errorCallback == nil {
 		return
 	}
@@ -112,7 +269,9 @@ func (l *reconnectListener) sleep(dur time.Duration) {
 	}
 }
 
-func (l *reconnectListener) bgAccept(lis net.Listener) {
+// bgAccept accepts connections until the listener is lost or closed. It
+// returns the error that the listener is lost with.
+func (l *reconnectListener) bgAccept(lis net.Listener) error {
 	defer log.Println("listener lost")
 	defer lis.Close()
 
@@ -129,41 +288,55 @@ func (l *reconnectListener) bgAccept(lis net.Listener) {
 
 	for {
 		if l.isClosed() {
-			return
+			return nil
 		}
 		conn, err := lis.Accept()
 		if err != nil {
 			l.callback(err)
-			return
+			return err
 		}
 
 		select {
 		case l.conn <- conn:
 		case <-l.closed:
-			return
+			return nil
 		}
 	}
 }
 
-func (l *reconnectListener) bg(lis net.Listener) {
-	if lis != nil {
-		l.bgAccept(lis)
-		l.sleep(time.Second)
-	}
+// reconnect retries listening with backoff until it succeeds. It returns
+// nil when the listener is closed.
+func (l *reconnectListener) reconnect() net.Listener {
+	for attempt := 0; ; attempt++ {
+		d := l.backoff.delay(attempt, rand.Float64())
+		l.setNextRetry(time.Now().Add(d))
+		l.sleep(d)
+		if l.isClosed() {
+			return nil
+		}
 
-	for !l.isClosed() {
 		lis, err := l.listen()
 		if err != nil {
 			l.callback(err)
-			l.sleep(time.Second)
+			l.setFailed(err)
 			continue
 		}
 		addr := lis.Addr()
 		log.Printf("reconnected: %s", addr)
-		l.setAddr(addr)
+		stats.fabricsReconnects.Inc()
+		l.setConnected(addr)
+		return lis
+	}
+}
 
-		l.bgAccept(lis)
-		l.sleep(time.Second)
+func (l *reconnectListener) bg(lis net.Listener) {
+	for lis != nil {
+		err := l.bgAccept(lis)
+		if l.isClosed() {
+			return
+		}
+		l.setDisconnected(err)
+		lis = l.reconnect()
 	}
 }
 
@@ -0,0 +1,156 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	b := &reconnectBackoff{
+		initial:    time.Second,
+		max:        time.Minute,
+		multiplier: 2,
+		jitter:     0.2,
+	}
+	for _, test := range []struct {
+		attempt int
+		r       float64
+		want    time.Duration
+	}{
+		{0, 0.5, time.Second},
+		{1, 0.5, 2 * time.Second},
+		{3, 0.5, 8 * time.Second},
+		{10, 0.5, time.Minute},
+		{0, 0, 800 * time.Millisecond},
+		{0, 1, 1200 * time.Millisecond},
+		{10, 1, 72 * time.Second},
+	} {
+		got := b.delay(test.attempt, test.r)
+		if got != test.want {
+			t.Errorf(
+				"delay(%d, %g) = %s, want %s",
+				test.attempt, test.r, got, test.want,
+			)
+		}
+	}
+}
+
+func TestReconnectBackoffConfig(t *testing.T) {
+	b, err := (&ReconnectBackoff{MaxSeconds: 10}).backoff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &reconnectBackoff{
+		initial:    time.Second,
+		max:        10 * time.Second,
+		multiplier: 2,
+		jitter:     0.2,
+	}
+	if *b != *want {
+		t.Errorf("got backoff %+v, want %+v", b, want)
+	}
+
+	for _, bad := range []*ReconnectBackoff{
+		{Multiplier: 0.5},
+		{InitialSeconds: -1},
+		{Jitter: 2},
+		{InitialSeconds: 10, MaxSeconds: 1},
+	} {
+		if _, err := bad.backoff(); err == nil {
+			t.Errorf("backoff %+v: got nil error", bad)
+		}
+	}
+}
+
+func TestReconnectListener(t *testing.T) {
+	var mu sync.Mutex
+	var listeners []net.Listener
+	fails := 0
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, lis := range listeners {
+			lis.Close()
+		}
+	}()
+
+	listen := func() (net.Listener, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fails > 0 {
+			fails--
+			return nil, errors.New("dial failed")
+		}
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, lis)
+		return lis, nil
+	}
+
+	backoff := &reconnectBackoff{
+		initial:    time.Millisecond,
+		max:        5 * time.Millisecond,
+		multiplier: 2,
+	}
+	l, err := newReconnectListener(listen, nil, backoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	mu.Lock()
+	first := listeners[0]
+	fails = 3
+	mu.Unlock()
+
+	status := l.Status()
+	if !status.Connected || status.Addr != first.Addr().String() {
+		t.Fatalf("got status %+v, want connected", status)
+	}
+
+	first.Close() // Lose the connection.
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status = l.Status()
+		if status.Connected && status.Addr != first.Addr().String() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("not reconnected, status %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fails != 0 {
+		t.Errorf("reconnected with %d failures left", fails)
+	}
+	if len(listeners) != 2 {
+		t.Errorf("got %d listeners, want 2", len(listeners))
+	}
+	if status.LastError != "dial failed" {
+		t.Errorf("got last error %q, want dial failed", status.LastError)
+	}
+}
@@ -33,12 +33,35 @@ import (
 // ServerConfig is the config for serving the reverse proxy
 // server.
 type ServerConfig struct {
-	HostMap       map[string]string
+	HostMap       map[string]*HostMapEntry
 	AutoCertCache autocert.Cache
 	Home          aries.Service
 	ManualCerts   map[string]*tls.Certificate
 
+	// DNS01 enables getting certificates with ACME DNS-01 challenges.
+	// Certificates are saved in AutoCertCache.
+	DNS01 *DNS01Config
+
+	// DNSSolver overrides the DNS solver specified in DNS01.
+	DNSSolver DNSSolver
+
+	// ErrorPages are custom HTML templates of error pages, keyed by page
+	// names like ErrorPageUnavailable.
+	ErrorPages map[string]string
+
+	// Maintenance are the hosts that are under maintenance on start.
+	Maintenance map[string]*HostMaintenance
+
+	// ClientAuth is the config for verifying client certificates.
+	ClientAuth *ClientAuthConfig
+
+	// IPWhitelist are the IPv4 or IPv6 CIDRs, IPs or IP presets that are
+	// allowed to visit. Empty for allowing all.
 	IPWhitelist []string
+
+	// Local is the config for serving HTTPS for local network hosts.
+	// Nil for not serving them.
+	Local *LocalConfig
 }
 
 type server struct {
@@ -47,8 +70,20 @@ type server struct {
 	proxy         *httputil.ReverseProxy
 	autoCertCache autocert.Cache
 	manualCerts   map[string]*tls.Certificate
+	dns01         *dns01Manager
+
+	health      *healthChecker
+	maintenance *maintenanceSet
+	pages       *errorPages
+
+	authCache  *authCache
+	authClient *http.Client
+	clientAuth *clientAuth
 
 	ipWhitelist []*net.IPNet
+
+	localCert  *tls.Certificate
+	localEntry *hostEntry
 }
 
 func makeDefaultHome() aries.Service {
@@ -59,22 +94,62 @@ func makeDefaultHome() aries.Service {
 }
 
 func newServer(config *ServerConfig) (*server, error) {
-	var ipWhitelist []*net.IPNet
-	for _, w := range config.IPWhitelist {
-		_, n, err := net.ParseCIDR(w)
-		if err != nil {
-			return nil, errcode.Annotatef(
-				err, "invalid whitelist entry: %q", w,
-			)
-		}
-		ipWhitelist = append(ipWhitelist, n)
+	ipWhitelist, err := ParseIPList(config.IPWhitelist)
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse ip whitelist")
+	}
+
+	hostMap, err := newMemHostMap(config.HostMap)
+	if err != nil {
+		return nil, errcode.Annotate(err, "load host map")
+	}
+
+	pages, err := newErrorPages(config.ErrorPages)
+	if err != nil {
+		return nil, errcode.Annotate(err, "load error pages")
 	}
 
 	s := &server{
-		hostMap:       newMemHostMap(config.HostMap),
+		hostMap:       hostMap,
 		autoCertCache: config.AutoCertCache,
 		ipWhitelist:   ipWhitelist,
 		manualCerts:   config.ManualCerts,
+		authCache:     newAuthCache(),
+		authClient:    newAuthClient(),
+		health:        newHealthChecker(hostMap.upstreams()),
+		maintenance:   newMaintenanceSet(config.Maintenance),
+		pages:         pages,
+	}
+
+	if config.Local != nil {
+		s.localCert = config.Local.Cert
+		s.localEntry, err = newLocalEntry(config.Local)
+		if err != nil {
+			return nil, errcode.Annotate(err, "load local config")
+		}
+	}
+
+	if config.ClientAuth != nil {
+		s.clientAuth, err = newClientAuth(config.ClientAuth)
+		if err != nil {
+			return nil, errcode.Annotate(err, "load client auth")
+		}
+	}
+
+	if config.DNS01 != nil {
+		if config.AutoCertCache == nil {
+			return nil, errcode.InvalidArgf("dns01 needs a cert cache")
+		}
+		solver := config.DNSSolver
+		if solver == nil {
+			solver, err = newDNSSolver(config.DNS01)
+			if err != nil {
+				return nil, errcode.Annotate(err, "make dns01 solver")
+			}
+		}
+		s.dns01 = newDNS01Manager(
+			config.DNS01, solver, config.AutoCertCache, hostMap.hosts,
+		)
 	}
 
 	if config.Home == nil {
@@ -86,66 +161,129 @@ func newServer(config *ServerConfig) (*server, error) {
 	s.proxy = &httputil.ReverseProxy{
 		Director:       s.director,
 		ModifyResponse: setStrictTransportSecurity,
+		ErrorHandler:   s.proxyError,
 	}
 	return s, nil
 }
 
-func (s *server) checkIP(c *aries.C) error {
-	if len(s.ipWhitelist) == 0 {
+func (s *server) checkIP(c *aries.C, entry *hostEntry) error {
+	if len(s.ipWhitelist) == 0 && entry.ipFilter == nil {
 		return nil
 	}
 	ip := aries.RemoteIP(c)
 	if ip == nil {
 		return errcode.InvalidArgf("cannot determine IP address")
 	}
-	for _, n := range s.ipWhitelist {
-		if n.Contains(ip) {
-			return nil
-		}
+	if len(s.ipWhitelist) > 0 && !ipInNets(ip, s.ipWhitelist) {
+		return errcode.Unauthorizedf("not authorized")
 	}
-	return errcode.Unauthorizedf("not authorized")
+	if entry.ipFilter != nil && !entry.ipFilter.check(ip) {
+		return errcode.Unauthorizedf("not authorized")
+	}
+	return nil
 }
 
 func (s *server) Serve(c *aries.C) error {
 	host := strings.TrimSuffix(c.Req.Host, ".")
 
-	entry := s.hostMap.mapHost(host)
+	var entry *hostEntry
+	if s.localEntry != nil && isLocalHost(hostName(host)) {
+		entry = s.localEntry
+	} else {
+		entry = s.hostMap.mapHost(host, c.Req.URL.Path)
+	}
 	if entry == nil {
 		return aries.NotFound
 	}
+	ctx := c.Req.Context()
+	setAccessRoute(ctx, entry.key)
 
-	if err := s.checkIP(c); err != nil {
+	if err := s.checkIP(c, entry); err != nil {
+		return err
+	}
+	if ok, err := s.checkClientCert(c, host); err != nil {
 		return err
+	} else if !ok {
+		return nil
+	}
+	if ok, err := s.checkAuth(c, entry.auth); err != nil {
+		return err
+	} else if !ok {
+		return nil
+	}
+
+	if m := s.maintenance.get(host); m != nil {
+		s.pages.serveMaintenance(c.Resp, host, m)
+		return nil
 	}
 
 	switch entry.typ {
 	default:
 		return aries.NotFound
 	case hostHome:
+		setAccessUpstream(ctx, HomeHost)
 		return s.serveHome(c)
 	case hostRedirect:
+		setAccessUpstream(ctx, "!"+entry.host)
 		u := *c.Req.URL
 		u.Host = entry.host
+		if entry.stripPrefix {
+			stripURLPrefix(&u, entry.prefix)
+		}
 		c.Redirect(u.String())
 		return nil
 	case hostProxy:
-		s.proxy.ServeHTTP(c.Resp, c.Req)
+		setAccessUpstream(ctx, entry.host)
+		if s.health.isDown(entry.host) {
+			s.pages.serveUnavailable(
+				c.Resp, http.StatusServiceUnavailable, host,
+			)
+			return nil
+		}
+		// Passes the matched entry to the director, as local hosts are
+		// not in the host map.
+		req := c.Req.WithContext(withProxyEntry(ctx, entry))
+		s.proxy.ServeHTTP(c.Resp, req)
 		return nil
 	}
 }
 
+func (s *server) proxyError(
+	w http.ResponseWriter, req *http.Request, err error,
+) {
+	host := strings.TrimSuffix(req.Host, ".")
+	log.Printf("proxy for %q: %s", host, err)
+	s.pages.serveUnavailable(w, http.StatusBadGateway, host)
+}
+
 func (s *server) serveHome(c *aries.C) error {
 	return s.home.Serve(c)
 }
 
+type proxyEntryKey struct{}
+
+// withProxyEntry attaches the host entry that a request is proxied to.
+func withProxyEntry(ctx context.Context, entry *hostEntry) context.Context {
+	return context.WithValue(ctx, proxyEntryKey{}, entry)
+}
+
+// proxyEntry returns the host entry that the request is proxied to. It
+// returns nil if the entry is not a proxy entry.
+func proxyEntry(ctx context.Context) *hostEntry {
+	entry, ok := ctx.Value(proxyEntryKey{}).(*hostEntry)
+	if !ok || entry == nil || entry.typ != hostProxy {
+		return nil
+	}
+	return entry
+}
+
 func (s *server) director(req *http.Request) {
 	// swap the scheme to http
 	req.Header.Set("X-Forwarded-Proto", "https")
 
-	host := strings.TrimSuffix(req.Host, ".")
-
-	mapped := hostMapToProxy(s.hostMap, host)
-	if mapped == "" {
+	mapped := proxyEntry(req.Context())
+	if mapped == nil {
+		host := strings.TrimSuffix(req.Host, ".")
 		if host == "" {
 			log.Println("empty host")
 		} else {
@@ -156,7 +294,13 @@ func (s *server) director(req *http.Request) {
 		return
 	}
 
-	forwardToHTTP(req, mapped)
+	if mapped.stripPrefix {
+		stripURLPrefix(req.URL, mapped.prefix)
+		req.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(
+			mapped.prefix, "/",
+		))
+	}
+	forwardToHTTP(req, mapped.host)
 }
 
 func setStrictTransportSecurity(resp *http.Response) error {
@@ -167,7 +311,9 @@ func setStrictTransportSecurity(resp *http.Response) error {
 	return nil
 }
 
-// hostPolicy determines which hosts are whitelisted for autocert.
+// hostPolicy determines which hosts are whitelisted for autocert. Hosts
+// that are only matched by wildcard entries are not whitelisted, their
+// certificates need to come from manual certs.
 func (s *server) hostPolicy(_ context.Context, host string) error {
 	if !hostMapHas(s.hostMap, host) {
 		return errcode.NotFoundf("%q not in whitelist", host)
@@ -182,9 +328,22 @@ func (s *server) autoTLSConfig() *tls.Config {
 		Cache:      s.autoCertCache,
 	}
 	tlsConfig := autoCert.TLSConfig()
-	tlsConfig.GetCertificate = certutil.WrapAutoCert(
-		tlsConfig.GetCertificate, s.manualCerts,
+	getCert := getCertFunc(tlsConfig.GetCertificate)
+	if s.dns01 != nil {
+		getCert = s.dns01.wrap(getCert)
+	}
+	getCert = wrapWildcardCerts(
+		certutil.WrapAutoCert(getCert, s.manualCerts),
+		s.manualCerts,
 	)
+	if s.localCert != nil {
+		getCert = wrapLocalCert(getCert, s.localCert)
+		stats.observeCert(s.localCert)
+	}
+	tlsConfig.GetCertificate = stats.wrapCertMetrics(getCert)
+	for _, cert := range s.manualCerts {
+		stats.observeCert(cert)
+	}
 
 	return tlsConfig
 }
@@ -54,6 +54,7 @@ func (l *tagListener) acceptTag() (*tagConn, error) {
 	if err != nil {
 		return nil, err
 	}
+	conn = stats.trackConn(conn, l.tag)
 	return &tagConn{Conn: conn, tag: l.tag}, nil
 }
 
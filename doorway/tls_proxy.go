@@ -40,6 +40,11 @@ type TLSProxyConfig struct {
 
 	// Make these sites publicly accessible via fabrics.
 	Public []string
+
+	// ProxyProtocol is the PROXY protocol version to send to the
+	// forwarded upstreams, so that they can see the real client address.
+	// ProxyProtocolNone for not sending.
+	ProxyProtocol int
 }
 
 type tlsProxy struct {
@@ -50,6 +55,8 @@ type tlsProxy struct {
 	privateMode bool
 	public      map[string]bool
 
+	proxyProtocol int
+
 	closing chan struct{}
 }
 
@@ -60,6 +67,8 @@ func newTLSProxy(lis tagConnListener, config *TLSProxyConfig) *tlsProxy {
 		public:      strutil.MakeSet(config.Public),
 		privateMode: config.PrivateMode,
 		closing:     make(chan struct{}),
+
+		proxyProtocol: config.ProxyProtocol,
 	}
 	return p
 }
@@ -80,6 +89,17 @@ func (p *tlsProxy) forwardTCPConn(conn *sniproxy.TLSHelloConn, addr string) {
 		return
 	}
 
+	if p.proxyProtocol != ProxyProtocolNone {
+		if err := writeProxyHeader(
+			forward, p.proxyProtocol, conn.RemoteAddr(), conn.LocalAddr(),
+		); err != nil {
+			log.Printf("write proxy header to %q: %s", addr, err)
+			conn.Close()
+			forward.Close()
+			return
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -111,6 +131,7 @@ func (p *tlsProxy) Accept() (net.Conn, error) {
 		if err != nil {
 			// Drop connections that are not TLS.
 			log.Println(errcode.Annotate(err, "init TLS connection"))
+			stats.tlsProxyDrops.Inc("not-tls")
 			h.Close()
 			continue
 		}
@@ -125,6 +146,7 @@ func (p *tlsProxy) Accept() (net.Conn, error) {
 					"only alpn allowed from fabrics, got %q for %q",
 					hello.FirstProto, hello.ServerName,
 				)
+				stats.tlsProxyDrops.Inc("private")
 				h.Close()
 				continue
 			}
@@ -133,6 +155,7 @@ func (p *tlsProxy) Accept() (net.Conn, error) {
 		if p.forward != nil {
 			forward, ok := p.forward[name]
 			if ok {
+				stats.tlsProxyForwards.Inc(name)
 				go p.forwardTCPConn(h, forward)
 				continue // ownership transferred
 			}
@@ -0,0 +1,165 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UpstreamStatus is the health status of an upstream destination.
+type UpstreamStatus struct {
+	Addr  string
+	Hosts []string // Hosts that are proxied to this upstream.
+
+	Up      bool
+	Checked time.Time // Last time checked.
+	Since   time.Time // Since when the status has been the same.
+	Error   string    `json:",omitempty"`
+}
+
+const (
+	healthCheckInterval = 10 * time.Second
+	healthCheckTimeout  = 3 * time.Second
+)
+
+// healthChecker checks if upstreams accept TCP connections periodically.
+type healthChecker struct {
+	dial func(ctx context.Context, addr string) error
+
+	mu     sync.Mutex
+	status map[string]*UpstreamStatus
+}
+
+func dialUpstream(ctx context.Context, addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "80")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func newHealthChecker(upstreams map[string][]string) *healthChecker {
+	status := make(map[string]*UpstreamStatus)
+	for addr, hosts := range upstreams {
+		status[addr] = &UpstreamStatus{
+			Addr:  addr,
+			Hosts: hosts,
+			Up:    true, // Assume up before the first check.
+		}
+	}
+	return &healthChecker{
+		dial:   dialUpstream,
+		status: status,
+	}
+}
+
+func (h *healthChecker) addrs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var addrs []string
+	for addr := range h.status {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (h *healthChecker) update(addr string, err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.status[addr]
+	up := err == nil
+	if up != s.Up || s.Since.IsZero() {
+		if !s.Since.IsZero() {
+			if up {
+				log.Printf("upstream %q is up", addr)
+			} else {
+				log.Printf("upstream %q is down: %s", addr, err)
+			}
+		}
+		s.Since = now
+	}
+	s.Up = up
+	s.Checked = now
+	s.Error = ""
+	if err != nil {
+		s.Error = err.Error()
+	}
+	stats.upstreamUp.Set(boolValue(up), addr)
+}
+
+func (h *healthChecker) check(ctx context.Context, addr string) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	h.update(addr, h.dial(ctx, addr), time.Now())
+}
+
+func (h *healthChecker) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, addr := range h.addrs() {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			h.check(ctx, addr)
+		}(addr)
+	}
+	wg.Wait()
+}
+
+func (h *healthChecker) bg(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		h.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isDown returns true when the upstream failed its last health check.
+func (h *healthChecker) isDown(addr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.status[addr]
+	return ok && !s.Up
+}
+
+func (h *healthChecker) list() []*UpstreamStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var list []*UpstreamStatus
+	for _, s := range h.status {
+		cp := *s
+		list = append(list, &cp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Addr < list[j].Addr
+	})
+	return list
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker(t *testing.T) {
+	m := mustNewMemHostMap(t, map[string]*HostMapEntry{
+		"example.com": {
+			To: "front:8080",
+			Routes: []*HostRoute{
+				{Prefix: "/git/", To: "gitea:3000"},
+				{Prefix: "/admin/", To: HomeHost},
+			},
+		},
+		"www.example.com": {To: "front:8080"},
+		"old.example.com": {To: "!example.com"},
+	})
+
+	ups := m.upstreams()
+	want := map[string][]string{
+		"front:8080": {"example.com", "www.example.com"},
+		"gitea:3000": {"example.com"},
+	}
+	if !reflect.DeepEqual(ups, want) {
+		t.Fatalf("got upstreams %v, want %v", ups, want)
+	}
+
+	h := newHealthChecker(ups)
+	h.dial = func(_ context.Context, addr string) error {
+		if addr == "gitea:3000" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if h.isDown("gitea:3000") {
+		t.Error("upstream is down before checking")
+	}
+
+	h.checkAll(context.Background())
+	if h.isDown("front:8080") {
+		t.Error("front:8080 should be up")
+	}
+	if !h.isDown("gitea:3000") {
+		t.Error("gitea:3000 should be down")
+	}
+
+	list := h.list()
+	if len(list) != 2 || list[1].Addr != "gitea:3000" ||
+		list[1].Up || list[1].Error == "" {
+		t.Errorf("unexpected status list: %+v", list)
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	pages, err := newErrorPages(map[string]string{
+		ErrorPageMaintenance: "<p>{{.Host}}: {{.Message}}</p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	pages.serveUnavailable(w, http.StatusBadGateway, "example.com")
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("got code %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "example.com") {
+		t.Errorf("host not in page: %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	m := &HostMaintenance{Message: "<upgrading>"}
+	pages.serveMaintenance(w, "example.com", m)
+	const want = "<p>example.com: &lt;upgrading&gt;</p>"
+	if got := w.Body.String(); got != want {
+		t.Errorf("got page %q, want %q", got, want)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After")
+	}
+}
+
+func TestMaintenanceSet(t *testing.T) {
+	now := time.Now()
+	s := newMaintenanceSet(map[string]*HostMaintenance{
+		"a.com": {ETA: now.Add(time.Hour)},
+		"b.com": {Expires: now.Add(-time.Minute)},
+		"c.com": nil,
+	})
+	if s.get("a.com") == nil {
+		t.Error("a.com should be under maintenance")
+	}
+	if s.get("b.com") != nil {
+		t.Error("b.com maintenance should have expired")
+	}
+	if s.get("c.com") == nil {
+		t.Error("c.com should be under maintenance")
+	}
+
+	s.set(nil)
+	if s.get("a.com") != nil {
+		t.Error("a.com maintenance should be cleared")
+	}
+
+	pages, err := newErrorPages(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	eta := now.Add(10 * time.Minute)
+	pages.serveMaintenance(w, "a.com", &HostMaintenance{ETA: eta})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got code %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), eta.Format("Jan 2, 15:04 MST")) {
+		t.Errorf("ETA not in page: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// IsWildcardDomain checks if a domain is a wildcard domain pattern like
+// "*.example.com".
+func IsWildcardDomain(domain string) bool {
+	return strings.HasPrefix(domain, "*.")
+}
+
+// wildcardPatterns returns the wildcard patterns that match host, with the
+// most specific pattern first. For "a.b.example.com", it returns
+// "*.b.example.com", "*.example.com" and "*.com".
+func wildcardPatterns(host string) []string {
+	var patterns []string
+	for {
+		idx := strings.Index(host, ".")
+		if idx < 0 {
+			return patterns
+		}
+		host = host[idx+1:]
+		if host == "" {
+			return patterns
+		}
+		patterns = append(patterns, "*."+host)
+	}
+}
+
+// matchWildcard returns the key to use for looking up host. When host
+// does not exist, it returns the most specific matching wildcard pattern
+// that exists. It returns empty string when nothing matches.
+func matchWildcard(host string, has func(k string) bool) string {
+	if has(host) {
+		return host
+	}
+	for _, p := range wildcardPatterns(host) {
+		if has(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// matchCertName returns the name of the certificate that covers host,
+// which is either host itself or a wildcard pattern that replaces only
+// the left-most label, as RFC 6125 requires. Unlike matchWildcard,
+// "*.example.com" does not match "a.b.example.com". It returns empty
+// string when nothing matches.
+func matchCertName(host string, has func(k string) bool) string {
+	if has(host) {
+		return host
+	}
+	idx := strings.Index(host, ".")
+	if idx < 0 || idx == len(host)-1 {
+		return ""
+	}
+	if p := "*." + host[idx+1:]; has(p) {
+		return p
+	}
+	return ""
+}
+
+type getCertFunc func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// wrapWildcardCerts serves manual certificates of wildcard domains for
+// hosts that do not have a certificate of their own, and are covered by
+// the wildcard certificate.
+func wrapWildcardCerts(
+	f getCertFunc, certs map[string]*tls.Certificate,
+) getCertFunc {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.TrimSuffix(hello.ServerName, ".")
+		if _, ok := certs[name]; !ok {
+			k := matchCertName(name, func(k string) bool {
+				_, ok := certs[k]
+				return ok
+			})
+			if k != "" {
+				return certs[k], nil
+			}
+		}
+		return f(hello)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package doorway
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestWrapWildcardCerts(t *testing.T) {
+	wildcard := new(tls.Certificate)
+	exact := new(tls.Certificate)
+	fallback := new(tls.Certificate)
+	certs := map[string]*tls.Certificate{
+		"*.example.com":   wildcard,
+		"a.b.example.com": exact,
+	}
+	f := wrapWildcardCerts(
+		func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return fallback, nil
+		},
+		certs,
+	)
+
+	for _, test := range []struct {
+		name string
+		want *tls.Certificate
+	}{
+		{"x.example.com", wildcard},
+		{"x.example.com.", wildcard},
+		{"a.b.example.com", fallback}, // Has its own cert.
+		{"c.b.example.com", fallback}, // Not covered by the wildcard.
+		{"example.com", fallback},
+		{"x.other.com", fallback},
+	} {
+		hello := &tls.ClientHelloInfo{ServerName: test.name}
+		got, err := f(hello)
+		if err != nil {
+			t.Errorf("get cert for %q: %s", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("got wrong cert for %q", test.name)
+		}
+	}
+}
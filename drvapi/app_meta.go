@@ -52,4 +52,15 @@ type AppMeta struct {
 
 	// Steps is for apps that needs an upgrade ladder.
 	Steps []*StepVersion `json:",omitempty"`
+
+	// Ports are the non-HTTP ports that the app exposes through doorway.
+	Ports []*AppPort `json:",omitempty"`
+}
+
+// AppPort is a TCP or UDP port that an app exposes to the outside, like
+// SSH for git or a game server.
+type AppPort struct {
+	Protocol string // "tcp" or "udp"
+	Port     int    // Public port on the host.
+	ContPort int    // Port on the app's container.
 }
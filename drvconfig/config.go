@@ -15,6 +15,10 @@
 
 package drvconfig
 
+import (
+	"shanhu.io/homedrv/drv/homedial"
+)
+
 // NoServer to indicate there is no remote server.
 const NoServer = "-"
 
@@ -47,6 +51,15 @@ type Config struct {
 	// Not running inside a docker.
 	External bool `json:",omitempty"`
 
+	// HostOS is the type of the host OS when it is not BurmillaOS. Only
+	// "systemd" is supported, for generic Linux hosts like Debian. The
+	// host is then managed through a privileged helper container.
+	HostOS string `json:",omitempty"`
+
+	// HostSSHUser is the user whose SSH keys are managed on a "systemd"
+	// host. Default is "root".
+	HostSSHUser string `json:",omitempty"`
+
 	// HTTPPort provides alternative http port for doorway container to
 	// listen on. If it is negative, then doorway will not listen on
 	// HTTP.
@@ -64,4 +77,14 @@ type Config struct {
 	// Instead of reading the endpoint init config from the server,
 	// read from this file.
 	EndpointInitConfigFile string `json:",omitempty"`
+
+	// Dial is the config for dialing HomeDrive servers, like pinned
+	// addresses of self-hosted fabrics servers. It is merged on top of the
+	// config refreshed from DialConfigURL.
+	Dial *homedial.Config `json:",omitempty"`
+
+	// DialConfigURL is the URL to refresh the dial config from every
+	// hour. The URL responds a homedial.Config in JSON. Empty for not
+	// refreshing.
+	DialConfigURL string `json:",omitempty"`
 }
@@ -0,0 +1,92 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fabrics
+
+import (
+	"crypto/rsa"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/jsonx"
+)
+
+// EndpointConfig is the config of an endpoint that can dial in.
+type EndpointConfig struct {
+	// PublicKeys are the RSA public keys of the endpoint, in SSH
+	// authorized keys format, one key per line. The endpoint signs in with
+	// one of the keys.
+	PublicKeys string
+
+	// Domains are the domains routed to this endpoint. Wildcard domains
+	// like "*.example.com" are supported.
+	Domains []string
+}
+
+// Config is the config of a fabrics server.
+type Config struct {
+	// Host is the domain name of the fabrics server. Endpoints sign in and
+	// dial in tunnels on this domain.
+	Host string
+
+	// Addr is the address to listen on for TLS connections. Default is
+	// ":443".
+	Addr string `json:",omitempty"`
+
+	// CertCache is the directory to cache the certificates from
+	// Letsencrypt for Host. Default is "certs".
+	CertCache string `json:",omitempty"`
+
+	// Endpoints are the endpoints that can dial in, keyed by the user
+	// name that they sign in with.
+	Endpoints map[string]*EndpointConfig
+}
+
+// ReadConfig reads the config from a JSONX file.
+func ReadConfig(f string) (*Config, error) {
+	c := new(Config)
+	if err := jsonx.ReadFile(f, c); err != nil {
+		return nil, err
+	}
+	if c.Host == "" {
+		return nil, errcode.InvalidArgf("fabrics host is empty")
+	}
+	return c, nil
+}
+
+func parsePublicKeys(s string) ([]*rsa.PublicKey, error) {
+	var keys []*rsa.PublicKey
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, errcode.InvalidArgf("parse public key: %s", err)
+		}
+		ck, ok := k.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, errcode.InvalidArgf("unsupported key type %q", k.Type())
+		}
+		rk, ok := ck.CryptoPublicKey().(*rsa.PublicKey)
+		if !ok {
+			return nil, errcode.InvalidArgf("not an rsa key: %q", k.Type())
+		}
+		keys = append(keys, rk)
+	}
+	return keys, nil
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fabrics
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// connListener is a listener that accepts connections that are pushed
+// into it. It feeds the connections for Host into the HTTPS server.
+type connListener struct {
+	addr      net.Addr
+	ch        chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:   addr,
+		ch:     make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+var errListenerClosed = errors.New("listener closed")
+
+func (lis *connListener) push(conn net.Conn) error {
+	select {
+	case lis.ch <- conn:
+		return nil
+	case <-lis.closed:
+		return errListenerClosed
+	}
+}
+
+func (lis *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-lis.ch:
+		return conn, nil
+	case <-lis.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (lis *connListener) Close() error {
+	lis.closeOnce.Do(func() { close(lis.closed) })
+	return nil
+}
+
+func (lis *connListener) Addr() net.Addr { return lis.addr }
@@ -0,0 +1,39 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package fabrics is a self-hosted fabrics server. Endpoints sign in with
+// their keys and dial in websocket tunnels, and incoming TLS connections
+// are routed to the endpoints by SNI.
+package fabrics
+
+import (
+	"context"
+	"flag"
+	"log"
+)
+
+// Main is the main entrance for fabricsd binary.
+func Main() {
+	configFile := flag.String("config", "fabrics.jsonx", "config file")
+	flag.Parse()
+
+	config, err := ReadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := Serve(context.Background(), config); err != nil {
+		log.Fatal(err)
+	}
+}
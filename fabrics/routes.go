@@ -0,0 +1,80 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fabrics
+
+import (
+	"strings"
+
+	"shanhu.io/g/errcode"
+)
+
+func isWildcardDomain(domain string) bool {
+	return strings.HasPrefix(domain, "*.")
+}
+
+// routes maps domains to the users of the endpoints.
+type routes struct {
+	exact    map[string]string
+	wildcard map[string]string // Keyed by the domain without "*".
+}
+
+func normDomain(d string) string {
+	return strings.ToLower(strings.TrimSuffix(d, "."))
+}
+
+func newRoutes(endpoints map[string]*EndpointConfig) (*routes, error) {
+	r := &routes{
+		exact:    make(map[string]string),
+		wildcard: make(map[string]string),
+	}
+	for user, ep := range endpoints {
+		for _, d := range ep.Domains {
+			d = normDomain(d)
+			m := r.exact
+			if isWildcardDomain(d) {
+				m = r.wildcard
+				d = strings.TrimPrefix(d, "*")
+			}
+			if other, ok := m[d]; ok && other != user {
+				return nil, errcode.InvalidArgf(
+					"domain %q routed to both %q and %q", d, other, user,
+				)
+			}
+			m[d] = user
+		}
+	}
+	return r, nil
+}
+
+// lookup returns the user that the domain routes to. Exact domains take
+// precedence, and then the most specific wildcard domain.
+func (r *routes) lookup(domain string) (string, bool) {
+	domain = normDomain(domain)
+	if user, ok := r.exact[domain]; ok {
+		return user, true
+	}
+	for {
+		idx := strings.Index(domain, ".")
+		if idx < 0 {
+			return "", false
+		}
+		domain = domain[idx:]
+		if user, ok := r.wildcard[domain]; ok {
+			return user, true
+		}
+		domain = domain[1:]
+	}
+}
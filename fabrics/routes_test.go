@@ -0,0 +1,61 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fabrics
+
+import (
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	r, err := newRoutes(map[string]*EndpointConfig{
+		"alice": {Domains: []string{"alice.example.com", "*.alice.net"}},
+		"bob": {
+			Domains: []string{"*.example.com", "x.y.alice.net"},
+		},
+	})
+	if err != nil {
+		t.Fatal("new routes: ", err)
+	}
+
+	for _, test := range []struct {
+		domain, user string
+	}{
+		{"alice.example.com", "alice"},
+		{"Alice.Example.com.", "alice"},
+		{"www.example.com", "bob"},
+		{"a.b.example.com", "bob"},
+		{"www.alice.net", "alice"},
+		{"x.y.alice.net", "bob"},
+		{"z.y.alice.net", "alice"},
+		{"alice.net", ""},
+		{"example.com", ""},
+		{"other.org", ""},
+	} {
+		user, ok := r.lookup(test.domain)
+		if ok != (test.user != "") || user != test.user {
+			t.Errorf(
+				"lookup %q, got %q, want %q", test.domain, user, test.user,
+			)
+		}
+	}
+
+	if _, err := newRoutes(map[string]*EndpointConfig{
+		"alice": {Domains: []string{"a.example.com"}},
+		"bob":   {Domains: []string{"a.example.com"}},
+	}); err == nil {
+		t.Error("conflicting domains should fail")
+	}
+}
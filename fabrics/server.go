@@ -0,0 +1,159 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fabrics
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/netutil"
+	"shanhu.io/g/sniproxy"
+)
+
+type server struct {
+	host    string
+	routes  *routes
+	tunnels *tunnels
+	control *connListener
+}
+
+// helloTimeout is the time limit for reading the TLS client hello.
+const helloTimeout = 10 * time.Second
+
+func (s *server) forward(ctx context.Context, conn net.Conn) {
+	h := sniproxy.NewTLSHelloConn(conn)
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	hello, err := h.HelloInfo()
+	if err != nil {
+		log.Println(errcode.Annotate(err, "read tls hello"))
+		h.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	name := normDomain(hello.ServerName)
+	if name == s.host {
+		if err := s.control.push(h); err != nil {
+			h.Close()
+		}
+		return // ownership transferred
+	}
+	defer h.Close()
+
+	user, ok := s.routes.lookup(name)
+	if !ok {
+		log.Printf("no route for %q from %s", name, conn.RemoteAddr())
+		return
+	}
+	up, err := s.tunnels.dial(ctx, user, conn.RemoteAddr())
+	if err != nil {
+		log.Printf("dial %q for %q: %s", user, name, err)
+		return
+	}
+	defer up.Close()
+
+	_ = netutil.JoinConn(ctx, h, up) // do not care about the error.
+}
+
+func (s *server) serve(ctx context.Context, lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.forward(ctx, conn)
+	}
+}
+
+func (s *server) router(signIn *signIn) *aries.Router {
+	r := aries.NewRouter()
+	r.Call("pubapi/signin", signIn.apiSignIn)
+	r.Get("endpoint", s.tunnels.serveEndpoint)
+	r.File("health", aries.StringFunc("ok"))
+	return r
+}
+
+// Serve runs a fabrics server with the given config.
+func Serve(ctx context.Context, config *Config) error {
+	rs, err := newRoutes(config.Endpoints)
+	if err != nil {
+		return errcode.Annotate(err, "make routes")
+	}
+	signIn, err := newSignIn(config.Endpoints)
+	if err != nil {
+		return errcode.Annotate(err, "make sign in")
+	}
+
+	addr := config.Addr
+	if addr == "" {
+		addr = ":443"
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errcode.Annotate(err, "listen")
+	}
+	defer lis.Close()
+
+	host := normDomain(config.Host)
+	s := &server{
+		host:    host,
+		routes:  rs,
+		tunnels: newTunnels(signIn),
+		control: newConnListener(lis.Addr()),
+	}
+
+	certCache := config.CertCache
+	if certCache == "" {
+		certCache = "certs"
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(certCache),
+		HostPolicy: autocert.HostWhitelist(host),
+	}
+	https := &http.Server{
+		TLSConfig: m.TLSConfig(),
+		Handler:   aries.Serve(s.router(signIn)),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+		https.Close()
+	}()
+	go func() {
+		if err := https.ServeTLS(s.control, "", ""); err != nil {
+			if err != http.ErrServerClosed {
+				log.Println(errcode.Annotate(err, "serve https"))
+			}
+		}
+		cancel()
+	}()
+
+	log.Printf("fabrics %q serving on %s", host, lis.Addr())
+	return s.serve(ctx, lis)
+}
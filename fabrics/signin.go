@@ -0,0 +1,107 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fabrics
+
+import (
+	"crypto/rsa"
+	"strings"
+	"time"
+
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/rand"
+	"shanhu.io/g/signer"
+	"shanhu.io/g/signin/signinapi"
+)
+
+// signIn signs in endpoints with their keys, in the same way as the
+// homedrive.io server, so that creds.Endpoint logins work unchanged.
+type signIn struct {
+	keys     map[string][]*rsa.PublicKey
+	sessions *signer.Sessions
+}
+
+const (
+	// Endpoints dial the tunnel right after signing in, and sign in again
+	// when reconnecting, so tokens can be short lived.
+	tokenTTL = 10 * time.Minute
+
+	// signInWindow is the max clock skew accepted for signed times.
+	signInWindow = 5 * time.Minute
+)
+
+func newSignIn(endpoints map[string]*EndpointConfig) (*signIn, error) {
+	keys := make(map[string][]*rsa.PublicKey)
+	for user, ep := range endpoints {
+		ks, err := parsePublicKeys(ep.PublicKeys)
+		if err != nil {
+			return nil, errcode.Annotatef(err, "keys of %q", user)
+		}
+		if len(ks) == 0 {
+			return nil, errcode.InvalidArgf("no key for %q", user)
+		}
+		keys[user] = ks
+	}
+
+	// Tokens do not need to survive restarts, as tunnels do not either.
+	key := rand.Letters(32)
+	return &signIn{
+		keys:     keys,
+		sessions: signer.NewSessions([]byte(key), tokenTTL),
+	}, nil
+}
+
+func (s *signIn) apiSignIn(c *aries.C, req *signinapi.Request) (
+	*signinapi.Creds, error,
+) {
+	keys, ok := s.keys[req.User]
+	if !ok {
+		return nil, errcode.Unauthorizedf("unknown user %q", req.User)
+	}
+	if req.SignedTime == nil {
+		return nil, errcode.InvalidArgf("signed time missing")
+	}
+
+	verified := false
+	for _, k := range keys {
+		if err := signer.CheckRSATimeSignature(
+			req.SignedTime, k, signInWindow,
+		); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errcode.Unauthorizedf("sign in failed for %q", req.User)
+	}
+
+	token, _ := s.sessions.New([]byte(req.User), 0 /* default ttl */)
+	return &signinapi.Creds{User: req.User, Token: token}, nil
+}
+
+// check checks the bearer token of the request, and returns the user.
+func (s *signIn) check(c *aries.C) (string, error) {
+	const prefix = "Bearer "
+	auth := c.Req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return "", errcode.Unauthorizedf("token missing")
+	}
+	b, _, ok := s.sessions.Check(strings.TrimPrefix(auth, prefix))
+	if !ok {
+		return "", errcode.Unauthorizedf("invalid token")
+	}
+	return string(b), nil
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fabrics
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/sniproxy"
+)
+
+// tunnelOptions must match the options that fabricsdial uses.
+var tunnelOptions = &sniproxy.Options{
+	Siding:       true,
+	DialWithAddr: true,
+}
+
+// tunnels keeps the tunnels of the endpoints that are currently dialed
+// in. Each user has at most one tunnel; a new tunnel replaces the old one.
+type tunnels struct {
+	signIn   *signIn
+	upgrader *websocket.Upgrader
+
+	mu sync.Mutex
+	m  map[string]*sniproxy.Tunnel
+}
+
+func newTunnels(signIn *signIn) *tunnels {
+	return &tunnels{
+		signIn: signIn,
+		upgrader: &websocket.Upgrader{
+			ReadBufferSize:  sniproxy.DefaultReadBufferSize,
+			WriteBufferSize: sniproxy.DefaultWriteBufferSize,
+		},
+		m: make(map[string]*sniproxy.Tunnel),
+	}
+}
+
+func (t *tunnels) get(user string) *sniproxy.Tunnel {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.m[user]
+}
+
+func (t *tunnels) set(user string, tunn *sniproxy.Tunnel) {
+	t.mu.Lock()
+	old := t.m[user]
+	t.m[user] = tunn
+	t.mu.Unlock()
+
+	if old != nil {
+		log.Printf("endpoint %q reconnected, closing the old tunnel", user)
+		old.Close()
+	}
+}
+
+func (t *tunnels) remove(user string, tunn *sniproxy.Tunnel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.m[user] == tunn {
+		delete(t.m, user)
+	}
+}
+
+// serveEndpoint terminates the websocket tunnel from an endpoint.
+func (t *tunnels) serveEndpoint(c *aries.C) error {
+	user, err := t.signIn.check(c)
+	if err != nil {
+		return err
+	}
+
+	ws, err := t.upgrader.Upgrade(c.Resp, c.Req, nil)
+	if err != nil {
+		return errcode.Annotate(err, "upgrade websocket")
+	}
+
+	tunn := sniproxy.NewTunnel(ws, tunnelOptions)
+	t.set(user, tunn)
+	log.Printf("endpoint %q connected from %s", user, c.Req.RemoteAddr)
+
+	<-tunn.Done()
+	t.remove(user, tunn)
+	log.Printf("endpoint %q disconnected", user)
+	return nil
+}
+
+// dial dials a connection to the endpoint of the user, which carries the
+// address of the remote client.
+func (t *tunnels) dial(ctx context.Context, user string, raddr net.Addr) (
+	net.Conn, error,
+) {
+	tunn := t.get(user)
+	if tunn == nil {
+		return nil, errcode.NotFoundf("endpoint %q not connected", user)
+	}
+	return tunn.Dial(ctx, raddr)
+}
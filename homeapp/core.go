@@ -38,6 +38,11 @@ type Core interface {
 
 	// Domains gets the stub that manages application domain routings.
 	Domains() Domains
+
+	// CheckHostPath checks if a host path is ready to be bind mounted into
+	// a container. It returns an error if the path is on a data disk that
+	// is not mounted.
+	CheckHostPath(p string) error
 }
 
 // Cont returns the container name of an app.
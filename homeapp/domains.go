@@ -15,6 +15,10 @@
 
 package homeapp
 
+import (
+	"time"
+)
+
 // DomainMap is the domain mapping for one app.
 type DomainMap struct {
 	App string                  `json:",omitempty"`
@@ -33,4 +37,20 @@ type Domains interface {
 
 	// Clear clears the domain mapping of an application.
 	Clear(app string) error
+
+	// SetMaintenance marks the domains of an application as under
+	// maintenance. Requests to those domains are answered with a
+	// maintenance page until the mark is cleared.
+	SetMaintenance(app string, m *Maintenance) error
+
+	// ClearMaintenance clears the maintenance mark of an application.
+	ClearMaintenance(app string) error
+}
+
+// Maintenance describes an application under maintenance.
+type Maintenance struct {
+	Message string `json:",omitempty"`
+
+	// ETA is the estimated time when the maintenance finishes.
+	ETA time.Time
 }
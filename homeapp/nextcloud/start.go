@@ -128,9 +128,28 @@ func createCont(
 	return dock.CreateCont(d, image, contConfig)
 }
 
+// checkMounts makes sure that the bind mounted host paths are ready, so
+// that nextcloud does not start on an empty mountpoint.
+func checkMounts(c homeapp.Core, config *config) error {
+	if config.dataMount != "" {
+		if err := c.CheckHostPath(config.dataMount); err != nil {
+			return errcode.Annotate(err, "check data mount")
+		}
+	}
+	for _, extra := range config.extraMounts {
+		if err := c.CheckHostPath(extra.host); err != nil {
+			return errcode.Annotatef(err, "check mount %q", extra.host)
+		}
+	}
+	return nil
+}
+
 func start(
 	c homeapp.Core, image string, config *config,
 ) error {
+	if err := checkMounts(c, config); err != nil {
+		return err
+	}
 	cont, err := createCont(c, image, config)
 	if err != nil {
 		return errcode.Annotate(err, "create nextcloud")
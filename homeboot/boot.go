@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -35,6 +36,7 @@ import (
 	"shanhu.io/g/tarutil"
 	"shanhu.io/homedrv/drv/drvapi"
 	drvcfg "shanhu.io/homedrv/drv/drvconfig"
+	"shanhu.io/homedrv/drv/homedial"
 )
 
 // BootConfig is a JSON marshallable file that is saved on
@@ -45,6 +47,12 @@ type BootConfig struct {
 	Code         string
 	Download     bool `json:",omitempty"`
 	LegacyNaming bool
+
+	// Proxy is the URL of the outbound proxy, and NoProxy is a comma
+	// separated list of destinations that bypass the proxy. These are
+	// saved into Drive.Dial.
+	Proxy   string `json:",omitempty"`
+	NoProxy string `json:",omitempty"`
 }
 
 func stableChannel() string {
@@ -91,6 +99,14 @@ func (c *BootConfig) declareFlags(flags *flagutil.FlagSet) {
 		&drv.AutoAvoidPortBinding, "auto_avoid_port_binding", true,
 		"avoid binding ports when the port is 0 and not managing the OS",
 	)
+	flags.StringVar(
+		&c.Proxy, "proxy", "",
+		"outbound proxy URL, http://, https:// or socks5://",
+	)
+	flags.StringVar(
+		&c.NoProxy, "no_proxy", "",
+		"comma separated destinations that bypass the outbound proxy",
+	)
 }
 
 func (c *BootConfig) fixLegacyNaming() {
@@ -99,6 +115,35 @@ func (c *BootConfig) fixLegacyNaming() {
 	}
 }
 
+func splitList(s string) []string {
+	var list []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func (c *BootConfig) fixProxy() {
+	if c.Proxy == "" {
+		return
+	}
+	if c.Drive.Dial == nil {
+		c.Drive.Dial = new(homedial.Config)
+	}
+	c.Drive.Dial.Proxy = &homedial.ProxyConfig{
+		URL:     c.Proxy,
+		NoProxy: splitList(c.NoProxy),
+	}
+}
+
+// dialTransport returns the transport for talking to HomeDrive servers.
+// It honors the dial config, including the outbound proxy.
+func dialTransport() *http.Transport {
+	return &http.Transport{DialContext: homedial.Dial}
+}
+
 func newBootConfig() *BootConfig {
 	return &BootConfig{
 		Drive: &drvcfg.Config{Naming: &drvcfg.Naming{}},
@@ -133,7 +178,12 @@ func (b *boot) downloadCore(
 	if err != nil {
 		return "", errcode.Annotate(err, "parse server URL")
 	}
-	ep := &creds.RobotEndpoint{Server: serverURL, User: user, Key: pem}
+	ep := &creds.RobotEndpoint{
+		Server:    serverURL,
+		User:      user,
+		Key:       pem,
+		Transport: dialTransport(),
+	}
 	c, err := ep.Dial()
 	if err != nil {
 		return "", err
@@ -167,7 +217,10 @@ func (b *boot) saveDriveConfig(
 }
 
 func registerEndpoint(server *url.URL, name, code string, pub []byte) error {
-	client := &httputil.Client{Server: server}
+	client := &httputil.Client{
+		Server:    server,
+		Transport: dialTransport(),
+	}
 	const p = "/pubapi/endpoint/register"
 	req := &drvapi.RegisterRequest{
 		Name:       name,
@@ -185,6 +238,9 @@ func (b *boot) run() error {
 	if err != nil {
 		return errcode.Annotate(err, "invalid server url")
 	}
+	if err := homedial.SetConfig(drv.Dial); err != nil {
+		return errcode.Annotate(err, "set dial config")
+	}
 
 	pri, pub, err := rsautil.GenerateKey(nil, 0)
 	if err != nil {
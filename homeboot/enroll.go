@@ -20,6 +20,7 @@ import (
 	"net/url"
 
 	"shanhu.io/g/errcode"
+	"shanhu.io/homedrv/drv/homedial"
 )
 
 func cmdEnroll(args []string) error {
@@ -28,6 +29,7 @@ func cmdEnroll(args []string) error {
 	name := flags.String("name", "", "endpoint name")
 	code := flags.String("code", "", "passcode")
 	pubKey := flags.String("pubkey", "", "public key file")
+	proxy := flags.String("proxy", "", "outbound proxy URL")
 	flags.ParseArgs(args)
 
 	if *name == "" {
@@ -49,5 +51,13 @@ func cmdEnroll(args []string) error {
 		return errcode.Annotate(err, "read public key")
 	}
 
+	if *proxy != "" {
+		if err := homedial.SetConfig(&homedial.Config{
+			Proxy: &homedial.ProxyConfig{URL: *proxy},
+		}); err != nil {
+			return errcode.Annotate(err, "set outbound proxy")
+		}
+	}
+
 	return registerEndpoint(serverURL, *name, *code, pubKeyBytes)
 }
@@ -36,6 +36,7 @@ func cmdInstall(args []string) error {
 	}
 
 	config.fixLegacyNaming()
+	config.fixProxy()
 	b := newBoot(config)
 	return b.run()
 }
@@ -30,6 +30,7 @@ func fetchUserKeys(user string) ([]string, error) {
 			Scheme: "https",
 			Host:   "www.homedrive.io",
 		},
+		Transport: dialTransport(),
 	}
 	resp := new(drvapi.UserSSHKeyLines)
 	if err := c.Call("/pubapi/user/sshkeys", user, resp); err != nil {
@@ -0,0 +1,136 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package homedial
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Config is the config of dialing HomeDrive servers. This config is JSON
+// marshallable.
+type Config struct {
+	// Hosts pins host names to IPv4 addresses. These are merged on top of
+	// the built-in table, and can pin self-hosted fabrics servers.
+	Hosts map[string]string `json:",omitempty"`
+
+	// Hosts6 pins host names to IPv6 addresses. These are used when
+	// dialing over IPv6, or when there is no IPv4 pin, or when PreferIPv6
+	// is set.
+	Hosts6 map[string]string `json:",omitempty"`
+
+	// PreferIPv6 uses the IPv6 pins over the IPv4 ones for dual-stack
+	// dialing, which is for networks that only have IPv6 connectivity.
+	PreferIPv6 bool `json:",omitempty"`
+
+	// DoH is the URL of a DNS-over-HTTPS server, like
+	// "https://1.1.1.1/dns-query", for resolving hosts that are not
+	// pinned. The host of the URL should be an IP address or a pinned
+	// host. It takes precedence over DNSServer.
+	DoH string `json:",omitempty"`
+
+	// DNSServer is the address of a DNS server, like "1.1.1.1:53", for
+	// resolving hosts that are not pinned.
+	DNSServer string `json:",omitempty"`
+
+	// Proxy is the outbound proxy for dialing. When set, destinations
+	// that are not bypassed are dialed via the proxy, and the proxy
+	// resolves the host names that are not pinned.
+	Proxy *ProxyConfig `json:",omitempty"`
+}
+
+type dialState struct {
+	hosts    map[string]string
+	hosts6   map[string]string
+	prefer6  bool
+	resolver resolver     // Nil for using the system resolver.
+	proxy    *proxyDialer // Nil for dialing directly.
+}
+
+var (
+	stateMu sync.RWMutex
+	state   = &dialState{hosts: homedrvIPv4}
+)
+
+func currentState() *dialState {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return state
+}
+
+func normHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// SetConfig sets the config for dialing. A nil config restores the
+// default, which only uses the built-in table.
+func SetConfig(c *Config) error {
+	s := &dialState{hosts: homedrvIPv4}
+	if c != nil {
+		if len(c.Hosts) > 0 {
+			hosts := make(map[string]string)
+			for host, ip := range homedrvIPv4 {
+				hosts[host] = ip
+			}
+			for host, ip := range c.Hosts {
+				parsed := net.ParseIP(ip)
+				if parsed == nil || parsed.To4() == nil {
+					return fmt.Errorf(
+						"invalid ipv4 address %q for %q", ip, host,
+					)
+				}
+				hosts[normHost(host)] = parsed.To4().String()
+			}
+			s.hosts = hosts
+		}
+		if len(c.Hosts6) > 0 {
+			s.hosts6 = make(map[string]string)
+			for host, ip := range c.Hosts6 {
+				parsed := net.ParseIP(ip)
+				if parsed == nil || parsed.To4() != nil {
+					return fmt.Errorf(
+						"invalid ipv6 address %q for %q", ip, host,
+					)
+				}
+				s.hosts6[normHost(host)] = parsed.String()
+			}
+		}
+		s.prefer6 = c.PreferIPv6
+		if c.DoH != "" {
+			r, err := newDoHResolver(c.DoH)
+			if err != nil {
+				return fmt.Errorf("make doh resolver: %w", err)
+			}
+			s.resolver = newCachedResolver(r)
+		} else if c.DNSServer != "" {
+			s.resolver = newCachedResolver(newDNSResolver(c.DNSServer))
+		}
+		if c.Proxy != nil {
+			p, err := newProxyDialer(c.Proxy)
+			if err != nil {
+				return fmt.Errorf("make proxy dialer: %w", err)
+			}
+			s.proxy = p
+		}
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	state = s
+	return nil
+}
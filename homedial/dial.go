@@ -17,8 +17,8 @@ package homedial
 
 import (
 	"context"
+	"log"
 	"net"
-	"strings"
 	"time"
 )
 
@@ -36,28 +36,110 @@ var homedrvIPv4 = map[string]string{
 	"fabrics-sgp.homedrive.io": "149.28.152.149",
 }
 
-func mapAddress(network, addr string) string {
-	if !(network == "tcp" || network == "tcp4") {
-		return addr
+func (s *dialState) pin(network, host string) (string, bool) {
+	host = normHost(host)
+	ip4, ok4 := s.hosts[host]
+	ip6, ok6 := s.hosts6[host]
+	switch network {
+	case "tcp4":
+		return ip4, ok4
+	case "tcp6":
+		return ip6, ok6
+	case "tcp":
+		if ok6 && (s.prefer6 || !ok4) {
+			return ip6, true
+		}
+		return ip4, ok4
 	}
+	return "", false
+}
+
+func mapAddress(network, addr string) string {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return addr
 	}
-	// Manually resolve IPv4 addresses for fabrics. This by passes DNS
+	// Manually resolve addresses for fabrics. This by passes DNS
 	// resolvers in user's home networks, which might be faulty.
-	trimmed := strings.TrimSuffix(host, ".")
-	if ip, ok := homedrvIPv4[trimmed]; ok {
+	if ip, ok := currentState().pin(network, host); ok {
 		// Directly resolve to IP address.
 		return net.JoinHostPort(ip, port)
 	}
 	return addr
 }
 
-// Dial dials HomeDrive servers.
+func lookupNetwork(network string) string {
+	switch network {
+	case "tcp4":
+		return "ip4"
+	case "tcp6":
+		return "ip6"
+	}
+	return "ip"
+}
+
+// dialResolved dials the address by resolving the host with the
+// configured resolver. It returns nil when there is no configured
+// resolver, or when resolving fails, so that the caller can fall back to
+// the system resolver.
+func dialResolved(ctx context.Context, r resolver, network, addr string) (
+	net.Conn, error,
+) {
+	if !(network == "tcp" || network == "tcp4" || network == "tcp6") {
+		return nil, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return nil, nil
+	}
+	ips, _, err := r.lookup(ctx, lookupNetwork(network), normHost(host))
+	if err != nil {
+		log.Printf("resolve %q: %s", host, err)
+		return nil, nil
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), port)
+		conn, err := fallbackNetDialer.DialContext(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, nil
+}
+
+// Dial dials HomeDrive servers. When an outbound proxy is configured,
+// destinations that are not bypassed are dialed via the proxy. Otherwise,
+// pinned hosts are dialed directly with the pinned addresses. Other hosts
+// are resolved with the configured resolver, and fall back to the system
+// resolver.
 func Dial(ctx context.Context, network, addr string) (
 	net.Conn, error,
 ) {
-	addr = mapAddress(network, addr)
-	return fallbackNetDialer.DialContext(ctx, network, addr)
+	s := currentState()
+	mapped := mapAddress(network, addr)
+	if s.proxy != nil {
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil {
+			conn, ok, err := s.proxy.dial(ctx, network, host, mapped)
+			if ok {
+				return conn, err
+			}
+		}
+	}
+	if r := s.resolver; r != nil && mapped == addr {
+		conn, err := dialResolved(ctx, r, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if conn != nil {
+			return conn, nil
+		}
+	}
+	return fallbackNetDialer.DialContext(ctx, network, mapped)
 }
@@ -41,7 +41,7 @@ func TestMapAddress(t *testing.T) {
 	}, {
 		net:      "tcp6",
 		addr:     "fabrics.homedrive.io:443",
-		addrWant: "fabrics.homedrive.io:443",
+		addrWant: "fabrics.homedrive.io:443", // No built-in IPv6 pins.
 	}, {
 		net:      "udp",
 		addr:     "fabrics.homedrive.io:443",
@@ -0,0 +1,254 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package homedial
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyConfig is the config of an outbound proxy.
+type ProxyConfig struct {
+	// URL is the URL of the proxy server. Supported schemes are "http" and
+	// "https" for HTTP CONNECT proxies, and "socks5" for SOCKS5 proxies.
+	// User name and password in the URL are used for authentication.
+	URL string
+
+	// NoProxy lists the destinations that are dialed directly. An entry
+	// can be "*" for all destinations, an IP address, a CIDR, or a domain
+	// name, which also matches all of its sub domains.
+	NoProxy []string `json:",omitempty"`
+}
+
+type bypassRules struct {
+	all     bool
+	ips     []net.IP
+	nets    []*net.IPNet
+	domains []string
+}
+
+func newBypassRules(list []string) (*bypassRules, error) {
+	r := new(bypassRules)
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			r.all = true
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			_, n, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %q: %w", entry, err)
+			}
+			r.nets = append(r.nets, n)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			r.ips = append(r.ips, ip)
+			continue
+		}
+		r.domains = append(r.domains, normHost(strings.TrimPrefix(entry, ".")))
+	}
+	return r, nil
+}
+
+func (r *bypassRules) match(host string) bool {
+	if r.all {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, want := range r.ips {
+			if want.Equal(ip) {
+				return true
+			}
+		}
+		for _, n := range r.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	host = normHost(host)
+	for _, d := range r.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyDialer dials TCP connections via an outbound proxy.
+type proxyDialer struct {
+	url    *url.URL
+	addr   string // Host and port of the proxy server.
+	bypass *bypassRules
+	socks  proxy.ContextDialer // Nil for HTTP CONNECT proxies.
+}
+
+func defaultProxyPort(scheme string) string {
+	switch scheme {
+	case "https":
+		return "443"
+	case "socks5", "socks5h":
+		return "1080"
+	}
+	return "80"
+}
+
+func newProxyDialer(c *ProxyConfig) (*proxyDialer, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy url: %w", err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy host missing in %q", c.URL)
+	}
+	bypass, err := newBypassRules(c.NoProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = defaultProxyPort(u.Scheme)
+	}
+	d := &proxyDialer{
+		url:    u,
+		addr:   net.JoinHostPort(u.Hostname(), port),
+		bypass: bypass,
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: pass}
+		}
+		socks, err := proxy.SOCKS5("tcp", d.addr, auth, fallbackNetDialer)
+		if err != nil {
+			return nil, fmt.Errorf("make socks5 dialer: %w", err)
+		}
+		d.socks = socks.(proxy.ContextDialer)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	return d, nil
+}
+
+// bufferedConn is a connection with some of its read bytes buffered.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (d *proxyDialer) dialConnect(ctx context.Context, addr string) (
+	net.Conn, error,
+) {
+	conn, err := fallbackNetDialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial proxy: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+
+	if d.url.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: d.url.Hostname(),
+		})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := d.url.User; u != nil {
+		pass, _ := u.Password()
+		cred := base64.StdEncoding.EncodeToString(
+			[]byte(u.Username() + ":" + pass),
+		)
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write connect request: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read connect response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy connect to %q: %s", addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// dial dials the address via the proxy. The host is the original host
+// name for matching bypass rules, and addr might have a pinned address.
+func (d *proxyDialer) dial(
+	ctx context.Context, network, host, addr string,
+) (net.Conn, bool, error) {
+	if !(network == "tcp" || network == "tcp4" || network == "tcp6") {
+		return nil, false, nil
+	}
+	if d.bypass.match(host) {
+		return nil, false, nil
+	}
+	if d.socks != nil {
+		conn, err := d.socks.DialContext(ctx, network, addr)
+		return conn, true, err
+	}
+	conn, err := d.dialConnect(ctx, addr)
+	return conn, true, err
+}
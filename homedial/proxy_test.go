@@ -0,0 +1,147 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package homedial
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestBypassRules(t *testing.T) {
+	rules, err := newBypassRules([]string{
+		"example.com", ".internal.net", "10.0.0.0/8", "192.168.1.1",
+	})
+	if err != nil {
+		t.Fatal("new bypass rules: ", err)
+	}
+
+	for _, test := range []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"www.Example.com.", true},
+		{"notexample.com", false},
+		{"a.internal.net", true},
+		{"internal.net", true},
+		{"10.1.2.3", true},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"fabrics.homedrive.io", false},
+	} {
+		if got := rules.match(test.host); got != test.want {
+			t.Errorf("match %q, got %t, want %t", test.host, got, test.want)
+		}
+	}
+
+	all, err := newBypassRules([]string{"*"})
+	if err != nil {
+		t.Fatal("new bypass rules: ", err)
+	}
+	if !all.match("fabrics.homedrive.io") {
+		t.Error("* should match everything")
+	}
+
+	if _, err := newBypassRules([]string{"10.0.0.0/99"}); err == nil {
+		t.Error("invalid cidr should fail")
+	}
+}
+
+// serveConnectProxy serves one HTTP CONNECT request on lis, and records
+// the requested address and auth header.
+func serveConnectProxy(lis net.Listener, got chan<- *http.Request) {
+	conn, err := lis.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	got <- req
+
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	io.WriteString(conn, "hello")
+}
+
+func TestDialViaConnectProxy(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen: ", err)
+	}
+	defer lis.Close()
+
+	got := make(chan *http.Request, 1)
+	go serveConnectProxy(lis, got)
+
+	defer SetConfig(nil)
+	if err := SetConfig(&Config{
+		Proxy: &ProxyConfig{
+			URL:     "http://user:pass@" + lis.Addr().String(),
+			NoProxy: []string{"localhost"},
+		},
+	}); err != nil {
+		t.Fatal("set config: ", err)
+	}
+
+	conn, err := Dial(context.Background(), "tcp", "fabrics.homedrive.io:443")
+	if err != nil {
+		t.Fatal("dial: ", err)
+	}
+	defer conn.Close()
+
+	req := <-got
+	if want := "178.128.130.77:443"; req.Host != want {
+		t.Errorf("connect to %q, want %q", req.Host, want)
+	}
+	user, pass, ok := (&http.Request{
+		Header: http.Header{
+			"Authorization": req.Header["Proxy-Authorization"],
+		},
+	}).BasicAuth()
+	if !ok || user != "user" || pass != "pass" {
+		t.Errorf("got proxy auth %q:%q, want user:pass", user, pass)
+	}
+
+	bs, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal("read: ", err)
+	}
+	if string(bs) != "hello" {
+		t.Errorf("got %q, want hello", bs)
+	}
+}
+
+func TestProxyConfigInvalid(t *testing.T) {
+	defer SetConfig(nil)
+	for _, u := range []string{
+		"ftp://proxy.example.com",
+		"http://",
+	} {
+		if err := SetConfig(&Config{
+			Proxy: &ProxyConfig{URL: u},
+		}); err == nil {
+			t.Errorf("proxy url %q should fail", u)
+		}
+	}
+}
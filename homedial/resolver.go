@@ -0,0 +1,249 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package homedial
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolver resolves host names into IP addresses. The network is "ip4",
+// "ip6" or "ip".
+type resolver interface {
+	lookup(ctx context.Context, network, host string) (
+		[]net.IP, time.Duration, error,
+	)
+}
+
+// dnsResolver resolves with a DNS server.
+type dnsResolver struct {
+	r *net.Resolver
+}
+
+func newDNSResolver(server string) *dnsResolver {
+	return &dnsResolver{
+		r: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (
+				net.Conn, error,
+			) {
+				return fallbackNetDialer.DialContext(ctx, network, server)
+			},
+		},
+	}
+}
+
+// dnsCacheTTL is how long results are cached when the TTL is unknown, and
+// also the maximum time to cache results.
+const dnsCacheTTL = 5 * time.Minute
+
+func (r *dnsResolver) lookup(ctx context.Context, network, host string) (
+	[]net.IP, time.Duration, error,
+) {
+	ips, err := r.r.LookupIP(ctx, network, host)
+	return ips, dnsCacheTTL, err
+}
+
+// dohResolver resolves with a DNS-over-HTTPS server, as in RFC 8484.
+type dohResolver struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHResolver(u string) (*dohResolver, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	// The DoH server can only be reached with pinned addresses, so that
+	// resolving does not go in loops.
+	dial := func(ctx context.Context, network, addr string) (
+		net.Conn, error,
+	) {
+		addr = mapAddress(network, addr)
+		return fallbackNetDialer.DialContext(ctx, network, addr)
+	}
+	return &dohResolver{
+		url: u,
+		client: &http.Client{
+			Transport: &http.Transport{DialContext: dial},
+			Timeout:   10 * time.Second,
+		},
+	}, nil
+}
+
+func (r *dohResolver) query(
+	ctx context.Context, host string, typ dnsmessage.Type,
+) ([]net.IP, time.Duration, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid host %q: %w", host, err)
+	}
+	msg := &dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  typ,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	q, err := msg.Pack()
+	if err != nil {
+		return nil, 0, fmt.Errorf("pack query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, r.url, bytes.NewReader(q),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	const contentType = "application/dns-message"
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("doh query got %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, 0, fmt.Errorf("read doh response: %w", err)
+	}
+
+	var ans dnsmessage.Message
+	if err := ans.Unpack(body); err != nil {
+		return nil, 0, fmt.Errorf("unpack doh response: %w", err)
+	}
+	if ans.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("doh query for %q: %s", host, ans.RCode)
+	}
+
+	ttl := dnsCacheTTL
+	var ips []net.IP
+	for _, a := range ans.Answers {
+		var ip net.IP
+		switch body := a.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			continue // CNAMEs and others.
+		}
+		ips = append(ips, ip)
+		if t := time.Duration(a.Header.TTL) * time.Second; t < ttl {
+			ttl = t
+		}
+	}
+	return ips, ttl, nil
+}
+
+func (r *dohResolver) lookup(ctx context.Context, network, host string) (
+	[]net.IP, time.Duration, error,
+) {
+	var types []dnsmessage.Type
+	switch network {
+	case "ip4":
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+
+	var ips []net.IP
+	ttl := dnsCacheTTL
+	var lastErr error
+	for _, typ := range types {
+		got, t, err := r.query(ctx, host, typ)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips = append(ips, got...)
+		if t < ttl {
+			ttl = t
+		}
+	}
+	if len(ips) == 0 {
+		if lastErr != nil {
+			return nil, 0, lastErr
+		}
+		return nil, 0, fmt.Errorf("no address found for %q", host)
+	}
+	return ips, ttl, nil
+}
+
+type cacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// cachedResolver caches the results of another resolver.
+type cachedResolver struct {
+	r   resolver
+	now func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+}
+
+func newCachedResolver(r resolver) *cachedResolver {
+	return &cachedResolver{
+		r:     r,
+		now:   time.Now,
+		cache: make(map[string]*cacheEntry),
+	}
+}
+
+func (r *cachedResolver) lookup(
+	ctx context.Context, network, host string,
+) ([]net.IP, time.Duration, error) {
+	k := network + " " + host
+	now := r.now()
+
+	r.mu.Lock()
+	entry, ok := r.cache[k]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.ips, entry.expires.Sub(now), nil
+	}
+
+	ips, ttl, err := r.r.lookup(ctx, network, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.mu.Lock()
+	r.cache[k] = &cacheEntry{ips: ips, expires: now.Add(ttl)}
+	r.mu.Unlock()
+	return ips, ttl, nil
+}
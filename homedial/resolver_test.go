@@ -0,0 +1,171 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package homedial
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestSetConfigHosts(t *testing.T) {
+	defer SetConfig(nil)
+
+	if err := SetConfig(&Config{
+		Hosts: map[string]string{"Fabrics.Example.com": "10.0.0.8"},
+	}); err != nil {
+		t.Fatal("set config: ", err)
+	}
+
+	for _, test := range []struct {
+		addr, want string
+	}{
+		{"fabrics.example.com:443", "10.0.0.8:443"},
+		{"fabrics.homedrive.io:443", "178.128.130.77:443"},
+		{"other.example.com:443", "other.example.com:443"},
+	} {
+		if got := mapAddress("tcp", test.addr); got != test.want {
+			t.Errorf("map %q, got %q, want %q", test.addr, got, test.want)
+		}
+	}
+
+	if err := SetConfig(&Config{
+		Hosts: map[string]string{"bad.example.com": "::1"},
+	}); err == nil {
+		t.Error("ipv6 pinned address should fail")
+	}
+
+	SetConfig(nil)
+	if got := mapAddress("tcp", "fabrics.example.com:443"); got !=
+		"fabrics.example.com:443" {
+		t.Errorf("override not cleared, got %q", got)
+	}
+}
+
+func TestSetConfigHosts6(t *testing.T) {
+	defer SetConfig(nil)
+
+	if err := SetConfig(&Config{
+		Hosts6: map[string]string{
+			"fabrics.homedrive.io": "2001:db8::77",
+			"v6only.example.com":   "2001:db8::8",
+		},
+	}); err != nil {
+		t.Fatal("set config: ", err)
+	}
+
+	for _, test := range []struct {
+		net, addr, want string
+	}{
+		{"tcp", "fabrics.homedrive.io:443", "178.128.130.77:443"},
+		{"tcp4", "fabrics.homedrive.io:443", "178.128.130.77:443"},
+		{"tcp6", "fabrics.homedrive.io:443", "[2001:db8::77]:443"},
+		{"tcp", "v6only.example.com:443", "[2001:db8::8]:443"},
+		{"tcp4", "v6only.example.com:443", "v6only.example.com:443"},
+	} {
+		if got := mapAddress(test.net, test.addr); got != test.want {
+			t.Errorf(
+				"map %s %q, got %q, want %q",
+				test.net, test.addr, got, test.want,
+			)
+		}
+	}
+
+	if err := SetConfig(&Config{
+		Hosts6:     map[string]string{"fabrics.homedrive.io": "2001:db8::77"},
+		PreferIPv6: true,
+	}); err != nil {
+		t.Fatal("set config: ", err)
+	}
+	const want = "[2001:db8::77]:443"
+	if got := mapAddress("tcp", "fabrics.homedrive.io:443"); got != want {
+		t.Errorf("prefer ipv6, got %q, want %q", got, want)
+	}
+
+	if err := SetConfig(&Config{
+		Hosts6: map[string]string{"bad.example.com": "10.0.0.1"},
+	}); err == nil {
+		t.Error("ipv4 address in Hosts6 should fail")
+	}
+}
+
+func TestDoHResolver(t *testing.T) {
+	queries := 0
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		queries++
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var q dnsmessage.Message
+		if err := q.Unpack(body); err != nil {
+			t.Fatal("unpack query: ", err)
+		}
+		resp := dnsmessage.Message{
+			Header: dnsmessage.Header{
+				ID:       q.ID,
+				Response: true,
+			},
+			Questions: q.Questions,
+		}
+		question := q.Questions[0]
+		if question.Type == dnsmessage.TypeA {
+			resp.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{
+					Name:  question.Name,
+					Type:  dnsmessage.TypeA,
+					Class: dnsmessage.ClassINET,
+					TTL:   60,
+				},
+				Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 9}},
+			}}
+		}
+		bs, err := resp.Pack()
+		if err != nil {
+			t.Fatal("pack response: ", err)
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(bs)
+	}
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	doh, err := newDoHResolver(s.URL)
+	if err != nil {
+		t.Fatal("make resolver: ", err)
+	}
+	r := newCachedResolver(doh)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		ips, _, err := r.lookup(ctx, "ip4", "host.example.com")
+		if err != nil {
+			t.Fatal("lookup: ", err)
+		}
+		want := net.IPv4(10, 0, 0, 9)
+		if len(ips) != 1 || !ips[0].Equal(want) {
+			t.Errorf("got %v, want [%s]", ips, want)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("got %d queries, want 1 for the cached result", queries)
+	}
+}
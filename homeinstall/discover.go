@@ -0,0 +1,59 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package homeinstall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/homedrv/drv/mdns"
+)
+
+func cmdDiscover(args []string) error {
+	flags := cmdFlags.New()
+	timeout := flags.Duration(
+		"timeout", 3*time.Second, "time to wait for responses",
+	)
+	args = flags.ParseArgs(args)
+	if len(args) != 0 {
+		return errcode.InvalidArgf("expects no args")
+	}
+
+	entries, err := mdns.Browse(
+		context.Background(), "_homedrive._tcp", *timeout,
+	)
+	if err != nil {
+		return errcode.Annotate(err, "browse local network")
+	}
+	if len(entries) == 0 {
+		fmt.Println("no drive found on the local network")
+		return nil
+	}
+	for _, e := range entries {
+		var ips []string
+		for _, ip := range e.IPs {
+			ips = append(ips, ip.String())
+		}
+		fmt.Printf(
+			"%s\thttps://%s:%d\t%s\n",
+			e.Instance, e.Host, e.Port, strings.Join(ips, " "),
+		)
+	}
+	return nil
+}
@@ -26,5 +26,6 @@ func Main() {
 		"config", "prompts for config and generates install script",
 		cmdConfig,
 	)
+	c.Add("discover", "finds drives on the local network", cmdDiscover)
 	c.Main()
 }
@@ -0,0 +1,155 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"strings"
+	"time"
+
+	"shanhu.io/g/bosinit"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/tarutil"
+	"shanhu.io/homedrv/drv/burmilla"
+)
+
+type burmillaOS struct {
+	b *burmilla.Burmilla
+}
+
+// NewBurmilla creates the host OS of BurmillaOS.
+func NewBurmilla(b *burmilla.Burmilla) HostOS {
+	return &burmillaOS{b: b}
+}
+
+func (h *burmillaOS) Name() string { return "burmilla" }
+
+func (h *burmillaOS) SSHKeys() ([]string, error) {
+	config, err := burmilla.ConfigExport(h.b)
+	if err != nil {
+		return nil, errcode.Annotate(err, "export os config")
+	}
+	return config.SSHAuthorizedKeys, nil
+}
+
+func (h *burmillaOS) SetSSHKeys(keys []string) error {
+	config := &bosinit.Config{SSHAuthorizedKeys: keys}
+	if err := burmilla.ConfigMerge(h.b, config); err != nil {
+		return errcode.Annotate(err, "merge config")
+	}
+
+	// Update authorized_keys file.
+	const (
+		uname   = "rancher"
+		dir     = "/home/rancher/.ssh"
+		keyFile = "authorized_keys"
+	)
+
+	uid, err := burmilla.UserID(h.b, uname)
+	if err != nil {
+		return errcode.Annotate(err, "get uid")
+	}
+	gid, err := burmilla.GroupID(h.b, uname)
+	if err != nil {
+		return errcode.Annotate(err, "get gid")
+	}
+	if err := burmilla.Mkdir(h.b, dir, uname); err != nil {
+		return errcode.Annotate(err, "make .ssh directory")
+	}
+
+	stream := tarutil.NewStream()
+	meta := &tarutil.Meta{
+		Mode:    0600,
+		UserID:  uid,
+		GroupID: gid,
+	}
+	stream.AddString(keyFile, meta, strings.Join(keys, "\n")+"\n")
+	if err := h.b.CopyInTarStream(stream, dir); err != nil {
+		return errcode.Annotate(err, "write authorized_keys")
+	}
+	return nil
+}
+
+func (h *burmillaOS) Addrs() ([]*Addr, error) { return execAddrs(h.b) }
+
+func (h *burmillaOS) DiskUsage() (*DiskUsage, error) {
+	return execPathUsage(h.b, DockerRoot)
+}
+
+func (h *burmillaOS) PathUsage(p string) (*DiskUsage, error) {
+	return execPathUsage(h.b, p)
+}
+
+func (h *burmillaOS) DiskInventory() (*DiskInventory, error) {
+	return execDiskInventory(h.b)
+}
+
+func (h *burmillaOS) FormatDisk(dev, label string) error {
+	return execFormatDisk(h.b, dev, label)
+}
+
+func (h *burmillaOS) AddMount(m *Mount) error {
+	if err := checkMount(m); err != nil {
+		return err
+	}
+
+	// The console shares /mnt and /media with the host, so a mount made
+	// here is visible to the user docker.
+	mounted, err := h.Mounted(m.Mountpoint)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		if err := execError(h.b.ExecRet([]string{
+			"mkdir", "-p", m.Mountpoint,
+		})); err != nil {
+			return errcode.Annotate(err, "make mountpoint")
+		}
+		if _, err := h.b.ExecOutput([]string{
+			"mount", "-t", m.FSType, m.Device, m.Mountpoint,
+		}); err != nil {
+			return errcode.Annotate(err, "mount")
+		}
+	}
+
+	mount := []string{m.Device, m.Mountpoint, m.FSType, "defaults"}
+	if err := burmilla.AddMount(h.b, mount); err != nil {
+		return errcode.Annotate(err, "add mount to os config")
+	}
+	return nil
+}
+
+func (h *burmillaOS) Mounts() ([]*Mount, error) {
+	mounts, err := burmilla.ConfigMounts(h.b)
+	if err != nil {
+		return nil, errcode.Annotate(err, "get os config mounts")
+	}
+	return cloudConfigMounts(mounts), nil
+}
+
+func (h *burmillaOS) Mounted(mountpoint string) (bool, error) {
+	return execMounted(h.b, mountpoint)
+}
+
+func (h *burmillaOS) Uptime() (time.Duration, error) {
+	return execUptime(h.b)
+}
+
+func (h *burmillaOS) Reboot() error {
+	if _, err := h.b.ExecOutput([]string{"reboot", "now"}); err != nil {
+		return errcode.Annotate(err, "reboot")
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+
+	"shanhu.io/g/errcode"
+)
+
+// DockerRoot is the default data root directory of the user docker.
+const DockerRoot = "/var/lib/docker"
+
+// BlockDevice is a block device on the host, like a disk or a partition.
+type BlockDevice struct {
+	Name       string // Like "nvme0n1p2".
+	Path       string // Like "/dev/nvme0n1p2".
+	Type       string // Like "disk", "part", "loop" or "rom".
+	Size       uint64 // In bytes.
+	FSType     string `json:",omitempty"`
+	Label      string `json:",omitempty"`
+	UUID       string `json:",omitempty"`
+	Model      string `json:",omitempty"`
+	Removable  bool   `json:",omitempty"`
+	Mountpoint string `json:",omitempty"`
+
+	// Children are the partitions of a disk, or the devices that are
+	// built on top of this device.
+	Children []*BlockDevice `json:",omitempty"`
+}
+
+// Filesystem is a mounted filesystem and its usage.
+type Filesystem struct {
+	Device     string
+	Mountpoint string
+	FSType     string `json:",omitempty"`
+	Total      uint64 // In bytes.
+	Used       uint64
+	Free       uint64 // Available to normal users.
+}
+
+// DiskInventory has the block devices and the mounted filesystems of the
+// host.
+type DiskInventory struct {
+	Devices     []*BlockDevice
+	Filesystems []*Filesystem
+}
+
+// PathFilesystem returns the filesystem that contains the given path,
+// which is the one with the longest mountpoint that is a prefix of the
+// path. It returns nil if not found.
+func (inv *DiskInventory) PathFilesystem(p string) *Filesystem {
+	return pathFilesystem(inv.Filesystems, p)
+}
+
+func pathFilesystem(filesystems []*Filesystem, p string) *Filesystem {
+	p = path.Clean(p)
+	var found *Filesystem
+	for _, fs := range filesystems {
+		if !pathUnder(p, fs.Mountpoint) {
+			continue
+		}
+		if found == nil || len(fs.Mountpoint) > len(found.Mountpoint) {
+			found = fs
+		}
+	}
+	return found
+}
+
+func pathUnder(p, dir string) bool {
+	if dir == "/" || p == dir {
+		return true
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// lsblkString is a string field in the lsblk JSON output, which is null
+// when the field is empty.
+type lsblkString string
+
+func (s *lsblkString) UnmarshalJSON(bs []byte) error {
+	var str *string
+	if err := json.Unmarshal(bs, &str); err != nil {
+		return err
+	}
+	if str != nil {
+		*s = lsblkString(*str)
+	}
+	return nil
+}
+
+// lsblkNumber is a number field in the lsblk JSON output. Older versions
+// of lsblk print numbers as strings.
+type lsblkNumber uint64
+
+func (n *lsblkNumber) UnmarshalJSON(bs []byte) error {
+	s := strings.Trim(string(bs), `"`)
+	if s == "null" || s == "" {
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = lsblkNumber(v)
+	return nil
+}
+
+// lsblkBool is a boolean field in the lsblk JSON output. Older versions
+// of lsblk print booleans as "0" or "1".
+type lsblkBool bool
+
+func (b *lsblkBool) UnmarshalJSON(bs []byte) error {
+	switch strings.Trim(string(bs), `"`) {
+	case "true", "1":
+		*b = true
+	case "false", "0", "null", "":
+		*b = false
+	default:
+		return errcode.InvalidArgf("invalid boolean %s", bs)
+	}
+	return nil
+}
+
+type lsblkDevice struct {
+	Name       lsblkString    `json:"name"`
+	Type       lsblkString    `json:"type"`
+	Size       lsblkNumber    `json:"size"`
+	FSType     lsblkString    `json:"fstype"`
+	Label      lsblkString    `json:"label"`
+	UUID       lsblkString    `json:"uuid"`
+	Model      lsblkString    `json:"model"`
+	RM         lsblkBool      `json:"rm"`
+	Mountpoint lsblkString    `json:"mountpoint"`
+	Children   []*lsblkDevice `json:"children"`
+}
+
+func (d *lsblkDevice) blockDevice() *BlockDevice {
+	dev := &BlockDevice{
+		Name:       string(d.Name),
+		Path:       "/dev/" + string(d.Name),
+		Type:       string(d.Type),
+		Size:       uint64(d.Size),
+		FSType:     string(d.FSType),
+		Label:      string(d.Label),
+		UUID:       string(d.UUID),
+		Model:      strings.TrimSpace(string(d.Model)),
+		Removable:  bool(d.RM),
+		Mountpoint: string(d.Mountpoint),
+	}
+	for _, child := range d.Children {
+		dev.Children = append(dev.Children, child.blockDevice())
+	}
+	return dev
+}
+
+// lsblkColumns are the columns to list. PATH is not listed, as lsblk of
+// util-linux before 2.33 does not support it.
+var lsblkColumns = strings.Join([]string{
+	"NAME", "TYPE", "SIZE", "FSTYPE", "LABEL", "UUID", "MODEL",
+	"RM", "MOUNTPOINT",
+}, ",")
+
+// parseLsblk parses the output of "lsblk -J -b -o <lsblkColumns>".
+func parseLsblk(bs []byte) ([]*BlockDevice, error) {
+	var out struct {
+		BlockDevices []*lsblkDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(bs, &out); err != nil {
+		return nil, errcode.Annotate(err, "parse lsblk output")
+	}
+	var devs []*BlockDevice
+	for _, d := range out.BlockDevices {
+		devs = append(devs, d.blockDevice())
+	}
+	return devs, nil
+}
+
+// unescapeMount unescapes the octal escapes, like "\040" for space, in
+// the fields of /proc/mounts.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// parseMounts parses /proc/mounts, and returns the filesystem type of
+// each mountpoint.
+func parseMounts(out string) map[string]string {
+	m := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		// Each line is in form of:
+		//   <device> <mountpoint> <fstype> <options> <dump> <pass>
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		m[unescapeMount(fields[1])] = fields[2]
+	}
+	return m
+}
+
+// parseDf parses the output of "df -P -k".
+func parseDf(out string) ([]*Filesystem, error) {
+	lines := strings.Split(out, "\n")
+	if len(lines) < 1 {
+		return nil, errcode.Internalf("unexpected df: %q", out)
+	}
+
+	var filesystems []*Filesystem
+	for _, line := range lines[1:] { // Skips the header.
+		// Each line is in form of:
+		//   <device> <total> <used> <available> <capacity> <mountpoint>
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 6 {
+			return nil, errcode.Internalf("unexpected df line: %q", line)
+		}
+		var nums []uint64
+		for _, f := range fields[1:4] {
+			n, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return nil, errcode.Internalf(
+					"unexpected df line: %q", line,
+				)
+			}
+			nums = append(nums, 1024*n) // df -k reports in KiB.
+		}
+		filesystems = append(filesystems, &Filesystem{
+			Device:     fields[0],
+			Mountpoint: strings.Join(fields[5:], " "),
+			Total:      nums[0],
+			Used:       nums[1],
+			Free:       nums[2],
+		})
+	}
+	return filesystems, nil
+}
+
+func execDf(e Exec, args ...string) ([]*Filesystem, error) {
+	out, err := e.ExecOutput(append([]string{"df", "-P", "-k"}, args...))
+	if err != nil {
+		return nil, errcode.Annotate(err, "df")
+	}
+	return parseDf(string(out))
+}
+
+// execPathUsage returns the usage of the filesystem that contains the
+// given path.
+func execPathUsage(e Exec, p string) (*DiskUsage, error) {
+	filesystems, err := execDf(e, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(filesystems) != 1 {
+		return nil, errcode.Internalf(
+			"df %q returned %d filesystems", p, len(filesystems),
+		)
+	}
+	fs := filesystems[0]
+	return &DiskUsage{Total: fs.Total, Free: fs.Free}, nil
+}
+
+// isVirtualFS checks if a filesystem is not backed by a block device,
+// like proc or tmpfs.
+func isVirtualFS(fs *Filesystem) bool {
+	return !strings.HasPrefix(fs.Device, "/dev/")
+}
+
+func execDiskInventory(e Exec) (*DiskInventory, error) {
+	out, err := e.ExecOutput([]string{
+		"lsblk", "-J", "-b", "-o", lsblkColumns,
+	})
+	if err != nil {
+		return nil, errcode.Annotate(err, "lsblk")
+	}
+	devs, err := parseLsblk(out)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := e.ExecOutput([]string{"cat", "/proc/mounts"})
+	if err != nil {
+		return nil, errcode.Annotate(err, "read mounts")
+	}
+	fsTypes := parseMounts(string(mounts))
+
+	all, err := execDf(e)
+	if err != nil {
+		return nil, err
+	}
+	var filesystems []*Filesystem
+	seen := make(map[string]bool)
+	for _, fs := range all {
+		if isVirtualFS(fs) || seen[fs.Mountpoint] {
+			continue
+		}
+		seen[fs.Mountpoint] = true
+		fs.FSType = fsTypes[fs.Mountpoint]
+		filesystems = append(filesystems, fs)
+	}
+
+	return &DiskInventory{
+		Devices:     devs,
+		Filesystems: filesystems,
+	}, nil
+}
@@ -0,0 +1,165 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDfDocker = `Filesystem 1024-blocks Used Available Capacity Mounted on
+/dev/sda2 1000 700 250 74% /var/lib/docker
+`
+
+const testLsblk = `{
+   "blockdevices": [
+      {"name":"sda", "type":"disk", "size":500107862016,
+       "fstype":null, "label":null, "uuid":null, "model":"Samsung SSD ",
+       "rm":false, "mountpoint":null,
+       "children": [
+          {"name":"sda1", "type":"part",
+           "size":536870912, "fstype":"vfat", "label":null,
+           "uuid":"A1B2-C3D4", "model":null, "rm":false,
+           "mountpoint":"/boot"},
+          {"name":"sda2", "type":"part",
+           "size":499570991104, "fstype":"ext4", "label":"RANCHER_STATE",
+           "uuid":"1234", "model":null, "rm":false, "mountpoint":"/"}
+       ]
+      },
+      {"name":"sdb", "type":"disk", "size":"2000398934016",
+       "fstype":"ext4", "label":"data", "model":"USB Disk", "rm":"1",
+       "mountpoint":"/mnt/my data"}
+   ]
+}`
+
+const testMounts = `proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sda2 / ext4 rw,relatime 0 0
+/dev/sda1 /boot vfat rw,relatime 0 0
+/dev/sdb /mnt/my\040data ext4 rw,relatime 0 0
+`
+
+const testDf = `Filesystem 1024-blocks Used Available Capacity Mounted on
+overlay 487652 100 487552 1% /
+tmpfs 65536 0 65536 0% /dev
+/dev/sda2 487652 200000 262000 44% /
+/dev/sda1 524288 1024 523264 1% /boot
+/dev/sdb 1953514 1000 1952514 1% /mnt/my data
+/dev/sda2 487652 200000 262000 44% /var/lib/docker
+`
+
+func TestDiskInventory(t *testing.T) {
+	e := &fakeExec{
+		outputs: map[string]string{
+			"lsblk -J -b -o " + lsblkColumns: testLsblk,
+			"cat /proc/mounts":               testMounts,
+			"df -P -k":                       testDf,
+		},
+	}
+	inv, err := execDiskInventory(e)
+	if err != nil {
+		t.Fatal("get disk inventory: ", err)
+	}
+
+	wantDevs := []*BlockDevice{{
+		Name:  "sda",
+		Path:  "/dev/sda",
+		Type:  "disk",
+		Size:  500107862016,
+		Model: "Samsung SSD",
+		Children: []*BlockDevice{{
+			Name:       "sda1",
+			Path:       "/dev/sda1",
+			Type:       "part",
+			Size:       536870912,
+			FSType:     "vfat",
+			UUID:       "A1B2-C3D4",
+			Mountpoint: "/boot",
+		}, {
+			Name:       "sda2",
+			Path:       "/dev/sda2",
+			Type:       "part",
+			Size:       499570991104,
+			FSType:     "ext4",
+			Label:      "RANCHER_STATE",
+			UUID:       "1234",
+			Mountpoint: "/",
+		}},
+	}, {
+		Name:       "sdb",
+		Path:       "/dev/sdb",
+		Type:       "disk",
+		Size:       2000398934016,
+		FSType:     "ext4",
+		Label:      "data",
+		Model:      "USB Disk",
+		Removable:  true,
+		Mountpoint: "/mnt/my data",
+	}}
+	if !reflect.DeepEqual(inv.Devices, wantDevs) {
+		t.Errorf("got devices %+v, want %+v", inv.Devices, wantDevs)
+	}
+
+	var mountpoints []string
+	for _, fs := range inv.Filesystems {
+		mountpoints = append(mountpoints, fs.Mountpoint+":"+fs.FSType)
+	}
+	wantMountpoints := []string{
+		"/:ext4", "/boot:vfat", "/mnt/my data:ext4", "/var/lib/docker:",
+	}
+	if !reflect.DeepEqual(mountpoints, wantMountpoints) {
+		t.Errorf("got mountpoints %q, want %q", mountpoints, wantMountpoints)
+	}
+
+	for _, test := range []struct {
+		path, want string
+	}{
+		{"/var/lib/docker/volumes", "/var/lib/docker"},
+		{"/mnt/my data/nextcloud", "/mnt/my data"},
+		{"/mnt/other", "/"},
+		{"/bootstrap", "/"},
+	} {
+		fs := inv.PathFilesystem(test.path)
+		if fs == nil || fs.Mountpoint != test.want {
+			t.Errorf(
+				"filesystem of %q is %+v, want %q",
+				test.path, fs, test.want,
+			)
+		}
+	}
+}
+
+func TestParseDf(t *testing.T) {
+	filesystems, err := parseDf(testDfDocker)
+	if err != nil {
+		t.Fatal("parse df: ", err)
+	}
+	want := []*Filesystem{{
+		Device:     "/dev/sda2",
+		Mountpoint: "/var/lib/docker",
+		Total:      1024 * 1000,
+		Used:       1024 * 700,
+		Free:       1024 * 250,
+	}}
+	if !reflect.DeepEqual(filesystems, want) {
+		t.Errorf("got %+v, want %+v", filesystems, want)
+	}
+
+	bad := strings.Replace(testDfDocker, "700", "x", 1)
+	if _, err := parseDf(bad); err == nil {
+		t.Error("parse df with bad number, got nil error")
+	}
+}
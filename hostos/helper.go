@@ -0,0 +1,72 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"bytes"
+	"io"
+	"path"
+
+	"shanhu.io/g/dock"
+	"shanhu.io/g/tarutil"
+)
+
+// HelperRoot is where the helper container mounts the root directory of
+// the host.
+const HelperRoot = "/host"
+
+// Helper executes commands on the host through a privileged helper
+// container. The container mounts the root directory of the host at
+// HelperRoot and uses the network of the host, so the commands see the
+// host's filesystem and network devices after chroot.
+type Helper struct {
+	cont *dock.Cont
+}
+
+// NewHelper creates a helper that executes commands in the given
+// container.
+func NewHelper(cont *dock.Cont) *Helper {
+	return &Helper{cont: cont}
+}
+
+func chrootArgs(args []string) []string {
+	return append([]string{"chroot", HelperRoot}, args...)
+}
+
+// ExecOutput executes a command on the host and returns its output.
+func (h *Helper) ExecOutput(args []string) ([]byte, error) {
+	out := new(bytes.Buffer)
+	if err := execError(h.cont.ExecWithSetup(&dock.ExecSetup{
+		Cmd:    chrootArgs(args),
+		Stdout: out,
+	})); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// ExecRet executes a command on the host and returns its return value.
+func (h *Helper) ExecRet(args []string) (int, error) {
+	return h.cont.ExecWithSetup(&dock.ExecSetup{
+		Cmd:    chrootArgs(args),
+		Stdout: io.Discard,
+	})
+}
+
+// CopyInTarStream copies files into the host's filesystem.
+func (h *Helper) CopyInTarStream(s *tarutil.Stream, target string) error {
+	return dock.CopyInTarStream(h.cont, s, path.Join(HelperRoot, target))
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package hostos provides access to the operating system of the host that
+// the drive runs on. BurmillaOS is managed through its system docker, and
+// other Linux hosts with systemd are managed through a privileged helper
+// container.
+package hostos
+
+import (
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/tarutil"
+)
+
+// Exec runs commands on the host.
+type Exec interface {
+	// ExecOutput executes a command and returns its output.
+	ExecOutput(args []string) ([]byte, error)
+
+	// ExecRet executes a command and returns its return value.
+	ExecRet(args []string) (int, error)
+
+	// CopyInTarStream copies files into the host's filesystem.
+	CopyInTarStream(s *tarutil.Stream, target string) error
+}
+
+// Addr is an IP address of a network device on the host.
+type Addr struct {
+	Dev  string // Network device, like "eth0".
+	Addr string // Address with prefix length, like "192.168.1.2/24".
+	IPv6 bool
+}
+
+// DiskUsage contains disk usage data.
+type DiskUsage struct {
+	Total uint64
+	Free  uint64
+}
+
+// HostOS is the operating system of the host.
+type HostOS interface {
+	// Name returns the name of the OS type, like "burmilla".
+	Name() string
+
+	// SSHKeys returns the authorized SSH keys for logging into the host.
+	SSHKeys() ([]string, error)
+
+	// SetSSHKeys replaces the authorized SSH keys.
+	SetSSHKeys(keys []string) error
+
+	// Addrs returns the IPv4 and IPv6 addresses of all network devices.
+	// Loopback and link-local addresses are skipped.
+	Addrs() ([]*Addr, error)
+
+	// DiskUsage returns the usage of the disk that holds the docker data
+	// root.
+	DiskUsage() (*DiskUsage, error)
+
+	// PathUsage returns the usage of the disk that holds the given path.
+	PathUsage(p string) (*DiskUsage, error)
+
+	// DiskInventory returns the block devices and the mounted
+	// filesystems.
+	DiskInventory() (*DiskInventory, error)
+
+	// FormatDisk creates an ext4 filesystem on an unused disk or
+	// partition. All data on the device is lost.
+	FormatDisk(dev, label string) error
+
+	// AddMount mounts a filesystem, and keeps it mounted after reboots.
+	AddMount(m *Mount) error
+
+	// Mounts returns the mounts in the OS configuration, which are
+	// mounted on boot.
+	Mounts() ([]*Mount, error)
+
+	// Mounted checks if a filesystem is mounted at the mountpoint.
+	Mounted(mountpoint string) (bool, error)
+
+	// Uptime returns the time since the host booted.
+	Uptime() (time.Duration, error)
+
+	// Reboot reboots the host.
+	Reboot() error
+}
+
+func execError(ret int, err error) error {
+	if err != nil {
+		return err
+	}
+	if ret != 0 {
+		return errcode.Internalf("exit value: %d", ret)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"path"
+	"strings"
+	"unicode"
+
+	"shanhu.io/g/errcode"
+)
+
+// Mount is a filesystem to mount on the host.
+type Mount struct {
+	Device     string // Like "/dev/disk/by-uuid/<uuid>".
+	Mountpoint string
+	FSType     string // Like "ext4".
+}
+
+// FindDevice finds the block device of the given path, like "/dev/sdb1".
+// It returns nil if not found.
+func FindDevice(devs []*BlockDevice, p string) *BlockDevice {
+	for _, d := range devs {
+		if d.Path == p {
+			return d
+		}
+		if found := FindDevice(d.Children, p); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// inUse checks if the device or any of its children is mounted or used
+// as swap.
+func (d *BlockDevice) inUse(usedDevs map[string]bool) bool {
+	if d.Mountpoint != "" || d.FSType == "swap" || usedDevs[d.Path] {
+		return true
+	}
+	for _, child := range d.Children {
+		if child.inUse(usedDevs) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unused returns the disks and partitions that are not in use, which
+// can be formatted or mounted. A disk that has partitions is not
+// returned; its unused partitions are.
+func (inv *DiskInventory) Unused() []*BlockDevice {
+	// A device that is not visible as mounted in the namespace where
+	// lsblk runs might still have a mounted filesystem that df reports.
+	usedDevs := make(map[string]bool)
+	for _, fs := range inv.Filesystems {
+		usedDevs[fs.Device] = true
+	}
+
+	var unused []*BlockDevice
+	var walk func(devs []*BlockDevice)
+	walk = func(devs []*BlockDevice) {
+		for _, d := range devs {
+			if d.Type != "disk" && d.Type != "part" {
+				continue
+			}
+			if len(d.Children) > 0 {
+				walk(d.Children)
+			} else if !d.inUse(usedDevs) {
+				unused = append(unused, d)
+			}
+		}
+	}
+	walk(inv.Devices)
+	return unused
+}
+
+func execFormatDisk(e Exec, dev, label string) error {
+	inv, err := execDiskInventory(e)
+	if err != nil {
+		return errcode.Annotate(err, "get disk inventory")
+	}
+	found := false
+	for _, d := range inv.Unused() {
+		if d.Path == dev {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errcode.InvalidArgf("%q is not an unused disk", dev)
+	}
+
+	args := []string{"mkfs.ext4", "-F", "-q"}
+	if label != "" {
+		args = append(args, "-L", label)
+	}
+	args = append(args, dev)
+	if _, err := e.ExecOutput(args); err != nil {
+		return errcode.Annotatef(err, "format %q", dev)
+	}
+	return nil
+}
+
+// hasSpace checks if s has white spaces, which are field separators in
+// /etc/fstab.
+func hasSpace(s string) bool {
+	return strings.IndexFunc(s, unicode.IsSpace) >= 0
+}
+
+func checkMount(m *Mount) error {
+	if m.Device == "" {
+		return errcode.InvalidArgf("device is empty")
+	}
+	if hasSpace(m.Device) || hasSpace(m.Mountpoint) || hasSpace(m.FSType) {
+		return errcode.InvalidArgf("mount has white spaces")
+	}
+	if m.FSType == "" {
+		return errcode.InvalidArgf("filesystem type is empty")
+	}
+	if !path.IsAbs(m.Mountpoint) || path.Clean(m.Mountpoint) == "/" {
+		return errcode.InvalidArgf("invalid mountpoint %q", m.Mountpoint)
+	}
+	return nil
+}
+
+func execMounted(e Exec, mountpoint string) (bool, error) {
+	out, err := e.ExecOutput([]string{"cat", "/proc/mounts"})
+	if err != nil {
+		return false, errcode.Annotate(err, "read mounts")
+	}
+	_, ok := parseMounts(string(out))[path.Clean(mountpoint)]
+	return ok, nil
+}
+
+// parseFstab parses the mounts in the content of /etc/fstab. Entries
+// that are not mounted on a directory, like swap, are skipped.
+func parseFstab(fstab string) []*Mount {
+	var mounts []*Mount
+	for _, line := range strings.Split(fstab, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		mountpoint := unescapeMount(fields[1])
+		if !path.IsAbs(mountpoint) {
+			continue
+		}
+		mounts = append(mounts, &Mount{
+			Device:     fields[0],
+			Mountpoint: path.Clean(mountpoint),
+			FSType:     fields[2],
+		})
+	}
+	return mounts
+}
+
+// cloudConfigMounts converts the mounts in a cloud-config, like
+// ["/dev/sdb1", "/mnt/data", "ext4", "defaults"].
+func cloudConfigMounts(list [][]string) []*Mount {
+	var mounts []*Mount
+	for _, m := range list {
+		if len(m) < 2 || !path.IsAbs(m[1]) {
+			continue
+		}
+		mount := &Mount{Device: m[0], Mountpoint: path.Clean(m[1])}
+		if len(m) > 2 {
+			mount.FSType = m[2]
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts
+}
+
+// fstabLine returns the line in /etc/fstab for the mount. The nofail
+// option keeps the host booting when the disk is unplugged.
+func fstabLine(m *Mount) string {
+	return strings.Join([]string{
+		m.Device, m.Mountpoint, m.FSType, "defaults,nofail", "0", "2",
+	}, " ")
+}
+
+// addFstab adds the mount into the content of /etc/fstab. It returns
+// false if the mount is already there.
+func addFstab(fstab string, m *Mount) (string, bool, error) {
+	mountpoint := path.Clean(m.Mountpoint)
+	for _, line := range strings.Split(fstab, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if path.Clean(unescapeMount(fields[1])) != mountpoint {
+			continue
+		}
+		if fields[0] != m.Device {
+			return "", false, errcode.InvalidArgf(
+				"%q is already used by %q", mountpoint, fields[0],
+			)
+		}
+		return fstab, false, nil
+	}
+	if fstab != "" && !strings.HasSuffix(fstab, "\n") {
+		fstab += "\n"
+	}
+	return fstab + fstabLine(m) + "\n", true, nil
+}
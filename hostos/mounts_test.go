@@ -0,0 +1,146 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiskInventoryUnused(t *testing.T) {
+	inv := &DiskInventory{
+		Devices: []*BlockDevice{{
+			Path: "/dev/sda",
+			Type: "disk",
+			Children: []*BlockDevice{
+				{Path: "/dev/sda1", Type: "part", Mountpoint: "/boot"},
+				{Path: "/dev/sda2", Type: "part"}, // Reported by df.
+				{Path: "/dev/sda3", Type: "part", FSType: "swap"},
+				{Path: "/dev/sda4", Type: "part", FSType: "ext4"},
+			},
+		}, {
+			Path: "/dev/sdb",
+			Type: "disk",
+		}, {
+			Path: "/dev/sdc",
+			Type: "disk",
+			Children: []*BlockDevice{{
+				Path: "/dev/sdc1",
+				Type: "part",
+				Children: []*BlockDevice{{
+					Path:       "/dev/mapper/vg-root",
+					Type:       "lvm",
+					Mountpoint: "/",
+				}},
+			}},
+		}, {
+			Path: "/dev/loop0",
+			Type: "loop",
+		}},
+		Filesystems: []*Filesystem{{
+			Device:     "/dev/sda2",
+			Mountpoint: "/var/lib/docker",
+		}},
+	}
+
+	var got []string
+	for _, d := range inv.Unused() {
+		got = append(got, d.Path)
+	}
+	want := []string{"/dev/sda4", "/dev/sdb"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got unused %q, want %q", got, want)
+	}
+}
+
+func TestAddFstab(t *testing.T) {
+	m := &Mount{
+		Device:     "/dev/disk/by-uuid/1234",
+		Mountpoint: "/mnt/data",
+		FSType:     "ext4",
+	}
+	const fstab = "# comment\nUUID=abcd / ext4 defaults 0 1"
+	const line = "/dev/disk/by-uuid/1234 /mnt/data ext4 defaults,nofail 0 2"
+
+	got, changed, err := addFstab(fstab, m)
+	if err != nil {
+		t.Fatal("add fstab: ", err)
+	}
+	if want := fstab + "\n" + line + "\n"; !changed || got != want {
+		t.Errorf("got fstab %q, want %q", got, want)
+	}
+
+	if _, changed, err := addFstab(got, m); err != nil {
+		t.Error("add fstab again: ", err)
+	} else if changed {
+		t.Error("add fstab again, got changed")
+	}
+
+	other := &Mount{
+		Device:     "/dev/sdb1",
+		Mountpoint: "/mnt/data/",
+		FSType:     "ext4",
+	}
+	if _, _, err := addFstab(got, other); err == nil {
+		t.Error("add fstab with used mountpoint, got nil error")
+	}
+}
+
+func TestParseFstab(t *testing.T) {
+	const fstab = `# comment
+UUID=abcd / ext4 defaults 0 1
+/dev/sda3 none swap sw 0 0
+/dev/sdb1 /mnt/my\040data/ ext4 defaults,nofail 0 2
+`
+	got := parseFstab(fstab)
+	want := []*Mount{{
+		Device:     "UUID=abcd",
+		Mountpoint: "/",
+		FSType:     "ext4",
+	}, {
+		Device:     "/dev/sdb1",
+		Mountpoint: "/mnt/my data",
+		FSType:     "ext4",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckMount(t *testing.T) {
+	for _, test := range []struct {
+		dev, mountpoint string
+		ok              bool
+	}{
+		{"/dev/sdb1", "/mnt/data", true},
+		{"/dev/sdb1", "/mnt/my data", false},
+		{"/dev/sdb1", "/mnt/a\tb", false},
+		{"/dev/sdb1", "/", false},
+		{"", "/mnt/data", false},
+	} {
+		m := &Mount{
+			Device:     test.dev,
+			Mountpoint: test.mountpoint,
+			FSType:     "ext4",
+		}
+		err := checkMount(m)
+		if test.ok && err != nil {
+			t.Errorf("check %q: %s", test.mountpoint, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("check %q: got nil error", test.mountpoint)
+		}
+	}
+}
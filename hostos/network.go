@@ -0,0 +1,62 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"net"
+	"strings"
+)
+
+// parseBriefAddrs parses the output of "ip -br address show". Loopback
+// and link-local addresses are skipped.
+func parseBriefAddrs(out string) []*Addr {
+	var addrs []*Addr
+	for _, line := range strings.Split(out, "\n") {
+		// Each line is in form of:
+		//   eth0    UP    x.x.x.x/xx xxxx::x/xx ...
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		dev := fields[0]
+		if i := strings.Index(dev, "@"); i >= 0 {
+			dev = dev[:i] // Like "eth0@if12" in containers.
+		}
+		for _, f := range fields[2:] {
+			ip, _, err := net.ParseCIDR(f)
+			if err != nil {
+				continue
+			}
+			if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			addrs = append(addrs, &Addr{
+				Dev:  dev,
+				Addr: f,
+				IPv6: ip.To4() == nil,
+			})
+		}
+	}
+	return addrs
+}
+
+func execAddrs(e Exec) ([]*Addr, error) {
+	out, err := e.ExecOutput(strings.Fields("ip -br address show"))
+	if err != nil {
+		return nil, err
+	}
+	return parseBriefAddrs(string(out)), nil
+}
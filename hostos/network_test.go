@@ -0,0 +1,42 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBriefAddrs(t *testing.T) {
+	const out = `lo               UNKNOWN        127.0.0.1/8 ::1/128
+eth0             UP             192.168.1.2/24 2001:db8::2/64 fe80::1/64
+docker0          DOWN           172.17.0.1/16
+veth1@if12       UP             fe80::2/64
+wlan0            DOWN
+`
+	got := parseBriefAddrs(out)
+	want := []*Addr{
+		{Dev: "eth0", Addr: "192.168.1.2/24"},
+		{Dev: "eth0", Addr: "2001:db8::2/64", IPv6: true},
+		{Dev: "docker0", Addr: "172.17.0.1/16"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for _, a := range got {
+			t.Logf("got %+v", a)
+		}
+		t.Errorf("got %d addrs, want %d", len(got), len(want))
+	}
+}
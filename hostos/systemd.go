@@ -0,0 +1,265 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/tarutil"
+)
+
+// SystemdConfig is the config of a generic Linux host that runs systemd,
+// like Debian.
+type SystemdConfig struct {
+	// SSHUser is the user whose authorized SSH keys are managed. Default
+	// is "root".
+	SSHUser string `json:",omitempty"`
+
+	// DataDir is a directory on the data disk, for querying the disk
+	// usage. Default is "/var/lib/docker", where the volumes are.
+	DataDir string `json:",omitempty"`
+}
+
+type systemdOS struct {
+	exec    Exec
+	sshUser string
+	dataDir string
+}
+
+// NewSystemd creates the host OS of a generic Linux host that runs
+// systemd. Commands are executed with e.
+func NewSystemd(e Exec, config *SystemdConfig) HostOS {
+	h := &systemdOS{
+		exec:    e,
+		sshUser: "root",
+		dataDir: DockerRoot,
+	}
+	if config != nil {
+		if config.SSHUser != "" {
+			h.sshUser = config.SSHUser
+		}
+		if config.DataDir != "" {
+			h.dataDir = config.DataDir
+		}
+	}
+	return h
+}
+
+func (h *systemdOS) Name() string { return "systemd" }
+
+type passwdEntry struct {
+	uid  int
+	gid  int
+	home string
+}
+
+// parsePasswd parses a line of /etc/passwd, in form of
+// name:password:uid:gid:gecos:home:shell .
+func parsePasswd(line string) (*passwdEntry, error) {
+	fields := strings.Split(strings.TrimSpace(line), ":")
+	if len(fields) != 7 {
+		return nil, errcode.Internalf("unexpected passwd line: %q", line)
+	}
+	uid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse uid")
+	}
+	gid, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, errcode.Annotate(err, "parse gid")
+	}
+	return &passwdEntry{uid: uid, gid: gid, home: fields[5]}, nil
+}
+
+func (h *systemdOS) sshUserEntry() (*passwdEntry, error) {
+	out, err := h.exec.ExecOutput([]string{"getent", "passwd", h.sshUser})
+	if err != nil {
+		return nil, errcode.Annotatef(err, "look up user %q", h.sshUser)
+	}
+	return parsePasswd(string(out))
+}
+
+const authorizedKeys = "authorized_keys"
+
+func (h *systemdOS) SSHKeys() ([]string, error) {
+	user, err := h.sshUserEntry()
+	if err != nil {
+		return nil, err
+	}
+	f := path.Join(user.home, ".ssh", authorizedKeys)
+	ret, err := h.exec.ExecRet([]string{"test", "-e", f})
+	if err != nil {
+		return nil, errcode.Annotate(err, "check authorized_keys")
+	}
+	if ret != 0 {
+		return nil, nil
+	}
+	out, err := h.exec.ExecOutput([]string{"cat", f})
+	if err != nil {
+		return nil, errcode.Annotate(err, "read authorized_keys")
+	}
+	var keys []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, nil
+}
+
+func (h *systemdOS) SetSSHKeys(keys []string) error {
+	user, err := h.sshUserEntry()
+	if err != nil {
+		return err
+	}
+	uid := strconv.Itoa(user.uid)
+	gid := strconv.Itoa(user.gid)
+	dir := path.Join(user.home, ".ssh")
+	if err := execError(h.exec.ExecRet([]string{
+		"install", "-d", "-m", "0700", "-o", uid, "-g", gid, dir,
+	})); err != nil {
+		return errcode.Annotate(err, "make .ssh directory")
+	}
+
+	stream := tarutil.NewStream()
+	meta := &tarutil.Meta{
+		Mode:    0600,
+		UserID:  user.uid,
+		GroupID: user.gid,
+	}
+	stream.AddString(authorizedKeys, meta, strings.Join(keys, "\n")+"\n")
+	if err := h.exec.CopyInTarStream(stream, dir); err != nil {
+		return errcode.Annotate(err, "write authorized_keys")
+	}
+	return nil
+}
+
+func (h *systemdOS) Addrs() ([]*Addr, error) { return execAddrs(h.exec) }
+
+func (h *systemdOS) DiskUsage() (*DiskUsage, error) {
+	return execPathUsage(h.exec, h.dataDir)
+}
+
+func (h *systemdOS) PathUsage(p string) (*DiskUsage, error) {
+	return execPathUsage(h.exec, p)
+}
+
+func (h *systemdOS) DiskInventory() (*DiskInventory, error) {
+	return execDiskInventory(h.exec)
+}
+
+func (h *systemdOS) FormatDisk(dev, label string) error {
+	return execFormatDisk(h.exec, dev, label)
+}
+
+// mountUnit returns the name of the systemd mount unit of a mountpoint.
+func (h *systemdOS) mountUnit(mountpoint string) (string, error) {
+	out, err := h.exec.ExecOutput([]string{
+		"systemd-escape", "-p", "--suffix=mount", mountpoint,
+	})
+	if err != nil {
+		return "", errcode.Annotate(err, "escape mountpoint")
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (h *systemdOS) AddMount(m *Mount) error {
+	if err := checkMount(m); err != nil {
+		return err
+	}
+
+	out, err := h.exec.ExecOutput([]string{"cat", "/etc/fstab"})
+	if err != nil {
+		return errcode.Annotate(err, "read fstab")
+	}
+	fstab, changed, err := addFstab(string(out), m)
+	if err != nil {
+		return err
+	}
+	if changed {
+		stream := tarutil.NewStream()
+		stream.AddString("fstab", &tarutil.Meta{Mode: 0644}, fstab)
+		if err := h.exec.CopyInTarStream(stream, "/etc"); err != nil {
+			return errcode.Annotate(err, "write fstab")
+		}
+	}
+
+	// Let systemd mount it, so that the mount is made in the host's
+	// mount namespace rather than the helper's.
+	if err := execError(h.exec.ExecRet([]string{
+		"mkdir", "-p", m.Mountpoint,
+	})); err != nil {
+		return errcode.Annotate(err, "make mountpoint")
+	}
+	if _, err := h.exec.ExecOutput([]string{
+		"systemctl", "daemon-reload",
+	}); err != nil {
+		return errcode.Annotate(err, "reload systemd")
+	}
+	unit, err := h.mountUnit(m.Mountpoint)
+	if err != nil {
+		return err
+	}
+	if _, err := h.exec.ExecOutput([]string{
+		"systemctl", "start", unit,
+	}); err != nil {
+		return errcode.Annotatef(err, "start %q", unit)
+	}
+	return nil
+}
+
+func (h *systemdOS) Mounts() ([]*Mount, error) {
+	out, err := h.exec.ExecOutput([]string{"cat", "/etc/fstab"})
+	if err != nil {
+		return nil, errcode.Annotate(err, "read fstab")
+	}
+	return parseFstab(string(out)), nil
+}
+
+// Mounted asks systemd rather than reading /proc/mounts, as the helper
+// does not see mounts made after it started.
+func (h *systemdOS) Mounted(mountpoint string) (bool, error) {
+	unit, err := h.mountUnit(mountpoint)
+	if err != nil {
+		return false, err
+	}
+	ret, err := h.exec.ExecRet([]string{
+		"systemctl", "is-active", "--quiet", unit,
+	})
+	if err != nil {
+		return false, errcode.Annotatef(err, "check %q", unit)
+	}
+	return ret == 0, nil
+}
+
+func (h *systemdOS) Uptime() (time.Duration, error) {
+	return execUptime(h.exec)
+}
+
+func (h *systemdOS) Reboot() error {
+	if _, err := h.exec.ExecOutput([]string{
+		"systemctl", "reboot",
+	}); err != nil {
+		return errcode.Annotate(err, "reboot")
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostos
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/tarutil"
+)
+
+// fakeExec is an exec backend that returns canned outputs.
+type fakeExec struct {
+	outputs map[string]string // Keyed by space joined args.
+	rets    map[string]int
+
+	ran    []string
+	copied []string // Targets of CopyInTarStream.
+}
+
+func (e *fakeExec) ExecOutput(args []string) ([]byte, error) {
+	cmd := strings.Join(args, " ")
+	e.ran = append(e.ran, cmd)
+	out, ok := e.outputs[cmd]
+	if !ok {
+		return nil, errcode.Internalf("unexpected command %q", cmd)
+	}
+	return []byte(out), nil
+}
+
+func (e *fakeExec) ExecRet(args []string) (int, error) {
+	cmd := strings.Join(args, " ")
+	e.ran = append(e.ran, cmd)
+	return e.rets[cmd], nil
+}
+
+func (e *fakeExec) CopyInTarStream(s *tarutil.Stream, target string) error {
+	e.copied = append(e.copied, target)
+	return nil
+}
+
+const testPasswd = "alice:x:1000:1001:Alice,,,:/home/alice:/bin/bash\n"
+
+func TestSystemdSSHKeys(t *testing.T) {
+	e := &fakeExec{
+		outputs: map[string]string{
+			"getent passwd alice": testPasswd,
+			"cat /home/alice/.ssh/authorized_keys": strings.Join([]string{
+				"# comment",
+				"ssh-ed25519 AAAA1 alice@laptop",
+				"",
+				"ssh-rsa AAAA2 alice@phone",
+			}, "\n"),
+		},
+	}
+	h := NewSystemd(e, &SystemdConfig{SSHUser: "alice"})
+	keys, err := h.SSHKeys()
+	if err != nil {
+		t.Fatal("get ssh keys: ", err)
+	}
+	want := []string{
+		"ssh-ed25519 AAAA1 alice@laptop",
+		"ssh-rsa AAAA2 alice@phone",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %q, want %q", keys, want)
+	}
+
+	// No authorized_keys file.
+	e.rets = map[string]int{
+		"test -e /home/alice/.ssh/authorized_keys": 1,
+	}
+	keys, err = h.SSHKeys()
+	if err != nil {
+		t.Fatal("get ssh keys without file: ", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("got keys %q, want none", keys)
+	}
+}
+
+func TestSystemdSetSSHKeys(t *testing.T) {
+	e := &fakeExec{
+		outputs: map[string]string{"getent passwd root": testPasswd},
+	}
+	h := NewSystemd(e, nil)
+	if err := h.SetSSHKeys([]string{"ssh-ed25519 AAAA1"}); err != nil {
+		t.Fatal("set ssh keys: ", err)
+	}
+	const mkdir = "install -d -m 0700 -o 1000 -g 1001 /home/alice/.ssh"
+	if got := e.ran[len(e.ran)-1]; got != mkdir {
+		t.Errorf("got command %q, want %q", got, mkdir)
+	}
+	want := []string{"/home/alice/.ssh"}
+	if !reflect.DeepEqual(e.copied, want) {
+		t.Errorf("copied to %q, want %q", e.copied, want)
+	}
+}
+
+func TestSystemdDiskUsageAndUptime(t *testing.T) {
+	e := &fakeExec{
+		outputs: map[string]string{
+			"df -P -k /var/lib/docker": testDfDocker,
+			"cat /proc/uptime":         "3600.50 7000.00\n",
+		},
+	}
+	h := NewSystemd(e, nil)
+
+	du, err := h.DiskUsage()
+	if err != nil {
+		t.Fatal("get disk usage: ", err)
+	}
+	want := &DiskUsage{Total: 1024 * 1000, Free: 1024 * 250}
+	if !reflect.DeepEqual(du, want) {
+		t.Errorf("got disk usage %+v, want %+v", du, want)
+	}
+
+	uptime, err := h.Uptime()
+	if err != nil {
+		t.Fatal("get uptime: ", err)
+	}
+	if want := 3600500 * time.Millisecond; uptime != want {
+		t.Errorf("got uptime %s, want %s", uptime, want)
+	}
+}
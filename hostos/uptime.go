@@ -13,7 +13,7 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
-package burmilla
+package hostos
 
 import (
 	"strconv"
@@ -23,9 +23,9 @@ import (
 	"shanhu.io/g/errcode"
 )
 
-// Uptime returns the uptime of the system.
-func Uptime(b *Burmilla) (time.Duration, error) {
-	line, err := b.ExecOutput([]string{"cat", "/proc/uptime"})
+// execUptime returns the uptime of the system.
+func execUptime(e Exec) (time.Duration, error) {
+	line, err := e.ExecOutput([]string{"cat", "/proc/uptime"})
 	if err != nil {
 		return 0, errcode.Annotate(err, "query system uptime")
 	}
@@ -17,9 +17,12 @@ package jarvis
 
 import (
 	"fmt"
+	"html/template"
+	"strings"
 
 	"shanhu.io/g/aries"
 	"shanhu.io/g/errcode"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
 	"shanhu.io/homedrv/drv/homeapp/nextcloud"
 )
 
@@ -48,6 +51,231 @@ func (s *adminTasks) apiFixDoorway(c *aries.C) error {
 	return d.tasks.run("fix doorway", t)
 }
 
+func (s *adminTasks) apiSetDoorwayDNS01(
+	c *aries.C, config *doorwaypkg.DNS01Config,
+) error {
+	d := s.server.drive
+	if config == nil {
+		// Saves an empty config, which disables DNS-01.
+		config = new(doorwaypkg.DNS01Config)
+	}
+	if err := d.settings.Set(keyDoorwayDNS01, config); err != nil {
+		return errcode.Annotate(err, "set dns01 config")
+	}
+	t := &taskRecreateDoorway{drive: d}
+	return d.tasks.run("recreate doorway", t)
+}
+
+func (s *adminTasks) apiReboot(c *aries.C) error {
+	host, err := s.server.drive.hostOS()
+	if err != nil {
+		return errcode.Annotate(err, "init host OS stub")
+	}
+	return host.Reboot()
+}
+
+func (s *adminTasks) apiSetDDNSConfig(c *aries.C, config *DDNSConfig) error {
+	if config == nil {
+		// Saves an empty config, which disables the updater.
+		config = new(DDNSConfig)
+	}
+	if config.enabled() {
+		if _, err := config.provider(); err != nil {
+			return err
+		}
+	}
+	d := s.server.drive
+	if err := d.settings.Set(keyDDNSConfig, config); err != nil {
+		return errcode.Annotate(err, "set ddns config")
+	}
+	// Forget the last status, so that the records are updated right away
+	// with the new config.
+	if err := d.settings.Set(keyDDNSStatus, new(DDNSStatus)); err != nil {
+		return errcode.Annotate(err, "reset ddns status")
+	}
+	if !config.enabled() {
+		return nil
+	}
+	return updateDDNS(c.Req.Context(), d, config)
+}
+
+func (s *adminTasks) apiSetMDNSConfig(c *aries.C, config *MDNSConfig) error {
+	if config == nil {
+		config = new(MDNSConfig)
+	}
+	d := s.server.drive
+	if err := d.settings.Set(keyMDNSConfig, config); err != nil {
+		return errcode.Annotate(err, "set mdns config")
+	}
+	t := &taskRestartMDNS{drive: d}
+	return d.tasks.run("restart mdns responder", t)
+}
+
+func (s *adminTasks) apiDDNS(c *aries.C) (*DDNSInfo, error) {
+	d := s.server.drive
+	status, err := loadDDNSStatus(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read ddns status")
+	}
+	const n = 20
+	history, err := d.ddnsLogs.list(n)
+	if err != nil {
+		return nil, errcode.Annotate(err, "list ddns history")
+	}
+	return &DDNSInfo{Status: status, History: history}, nil
+}
+
+func (s *adminTasks) apiSetDoorwayTrustedProxies(
+	c *aries.C, list []string,
+) error {
+	if _, err := doorwaypkg.ParseIPList(list); err != nil {
+		return err
+	}
+	d := s.server.drive
+	if err := d.settings.Set(keyDoorwayTrustedProxies, list); err != nil {
+		return errcode.Annotate(err, "set trusted proxies")
+	}
+	t := &taskRecreateDoorway{drive: d}
+	return d.tasks.run("recreate doorway", t)
+}
+
+func (s *adminTasks) apiSetFabricsServers(
+	c *aries.C, servers []*doorwaypkg.FabricsServer,
+) error {
+	for _, server := range servers {
+		if server.Host == "" {
+			return errcode.InvalidArgf("fabrics server host is empty")
+		}
+		if server.Weight < 0 {
+			return errcode.InvalidArgf(
+				"negative weight for %q", server.Host,
+			)
+		}
+	}
+	d := s.server.drive
+	if err := d.settings.Set(keyFabricsServers, servers); err != nil {
+		return errcode.Annotate(err, "set fabrics servers")
+	}
+	t := &taskRecreateDoorway{drive: d}
+	return d.tasks.run("recreate doorway", t)
+}
+
+// SetDoorwayErrorPageRequest sets a custom error page of doorway.
+type SetDoorwayErrorPageRequest struct {
+	Name string // doorwaypkg.ErrorPageUnavailable or ErrorPageMaintenance.
+	HTML string // Template of the page. Empty to use the default page.
+}
+
+func (s *adminTasks) apiSetDoorwayErrorPage(
+	c *aries.C, req *SetDoorwayErrorPageRequest,
+) error {
+	switch req.Name {
+	case doorwaypkg.ErrorPageUnavailable, doorwaypkg.ErrorPageMaintenance:
+	default:
+		return errcode.InvalidArgf("unknown error page %q", req.Name)
+	}
+	if req.HTML != "" {
+		if _, err := template.New(req.Name).Parse(req.HTML); err != nil {
+			return errcode.InvalidArgf("invalid template: %s", err)
+		}
+	}
+
+	d := s.server.drive
+	pages, err := loadDoorwayErrorPages(d.settings)
+	if err != nil {
+		return errcode.Annotate(err, "read error pages")
+	}
+	if pages == nil {
+		pages = make(map[string]string)
+	}
+	if req.HTML == "" {
+		delete(pages, req.Name)
+	} else {
+		pages[req.Name] = req.HTML
+	}
+	if err := d.settings.Set(keyDoorwayErrorPages, pages); err != nil {
+		return errcode.Annotate(err, "save error pages")
+	}
+	t := &taskRecreateDoorway{drive: d}
+	return d.tasks.run("recreate doorway", t)
+}
+
+// IssueClientCertRequest is the request to issue a client certificate.
+type IssueClientCertRequest struct {
+	User     string // Default to the current user.
+	Name     string // Name of the device or the purpose.
+	Password string // Password to protect the PKCS#12 file.
+}
+
+// IssueClientCertResponse is the response of issuing a client certificate.
+type IssueClientCertResponse struct {
+	Cert *ClientCertInfo
+	P12  []byte // PKCS#12 file with the key and the certificates.
+}
+
+func (s *adminTasks) apiIssueClientCert(
+	c *aries.C, req *IssueClientCertRequest,
+) (*IssueClientCertResponse, error) {
+	user := req.User
+	if user == "" {
+		user = c.User
+	}
+	if user == "" {
+		return nil, errcode.InvalidArgf("user is empty")
+	}
+	if req.Name == "" {
+		return nil, errcode.InvalidArgf("name is empty")
+	}
+	if req.Password == "" {
+		return nil, errcode.InvalidArgf("password is empty")
+	}
+	certs := s.server.drive.clientCerts
+	info, p12, err := certs.issue(user, req.Name, req.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &IssueClientCertResponse{Cert: info, P12: p12}, nil
+}
+
+func (s *adminTasks) apiListClientCerts(c *aries.C) (
+	[]*ClientCertInfo, error,
+) {
+	return s.server.drive.clientCerts.list()
+}
+
+func (s *adminTasks) apiRevokeClientCert(c *aries.C, serial string) error {
+	d := s.server.drive
+	if err := d.clientCerts.revoke(serial); err != nil {
+		return err
+	}
+	t := &taskRecreateDoorway{drive: d}
+	return d.tasks.run("recreate doorway", t)
+}
+
+func (s *adminTasks) apiSetClientCertHosts(
+	c *aries.C, hosts []string,
+) error {
+	d := s.server.drive
+	var list []string
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			continue
+		}
+		list = append(list, host)
+	}
+	if len(list) > 0 {
+		if err := d.clientCerts.ensureCA(); err != nil {
+			return errcode.Annotate(err, "create client ca")
+		}
+	}
+	if err := d.settings.Set(keyClientCertHosts, list); err != nil {
+		return errcode.Annotate(err, "set client cert hosts")
+	}
+	t := &taskRecreateDoorway{drive: d}
+	return d.tasks.run("recreate doorway", t)
+}
+
 func (s *adminTasks) apiSetRootPassword(c *aries.C, pwd string) error {
 	return s.server.users.setPassword(rootUser, pwd, nil)
 }
@@ -101,6 +329,26 @@ func (s *adminTasks) apiSetNextcloudVersionHint(c *aries.C, v string) error {
 	return s.server.drive.settings.Set(nextcloud.KeyVersionHint, v)
 }
 
+func (s *adminTasks) apiMetricsToken(c *aries.C, renew bool) (
+	string, error,
+) {
+	settings := s.server.drive.settings
+	if !renew {
+		token, err := metricsToken(settings)
+		if err != nil {
+			return "", errcode.Annotate(err, "read metrics token")
+		}
+		if token != "" {
+			return token, nil
+		}
+	}
+	token, err := newMetricsToken(settings)
+	if err != nil {
+		return "", errcode.Annotate(err, "create metrics token")
+	}
+	return token, nil
+}
+
 func adminTasksAPI(s *server) *aries.Router {
 	tasks := &adminTasks{server: s}
 
@@ -109,6 +357,23 @@ func adminTasksAPI(s *server) *aries.Router {
 	r.Call("push-update", tasks.apiPushUpdate)
 	r.Call("recreate-doorway", tasks.apiRecreateDoorway)
 	r.Call("fix-doorway", tasks.apiFixDoorway)
+	r.Call("set-doorway-dns01", tasks.apiSetDoorwayDNS01)
+	r.Call("set-doorway-error-page", tasks.apiSetDoorwayErrorPage)
+	r.Call(
+		"set-doorway-trusted-proxies", tasks.apiSetDoorwayTrustedProxies,
+	)
+	r.Call("set-fabrics-servers", tasks.apiSetFabricsServers)
+	r.Call("set-ddns-config", tasks.apiSetDDNSConfig)
+	r.Call("ddns", tasks.apiDDNS)
+	r.Call("set-mdns-config", tasks.apiSetMDNSConfig)
+	r.Call("reboot", tasks.apiReboot)
+	r.Call("list-unused-disks", tasks.apiListUnusedDisks)
+	r.Call("format-disk", tasks.apiFormatDisk)
+	r.Call("mount-disk", tasks.apiMountDisk)
+	r.Call("issue-client-cert", tasks.apiIssueClientCert)
+	r.Call("list-client-certs", tasks.apiListClientCerts)
+	r.Call("revoke-client-cert", tasks.apiRevokeClientCert)
+	r.Call("set-client-cert-hosts", tasks.apiSetClientCertHosts)
 	r.Call("set-root-password", tasks.apiSetRootPassword)
 	r.Call("disable-totp", tasks.apiDisableTOTP)
 	r.Call("reinstall-app", tasks.apiReinstallApp)
@@ -116,6 +381,7 @@ func adminTasksAPI(s *server) *aries.Router {
 	r.Call("set-nextcloud-extramnt", tasks.apiSetNextcloudExtraMounts)
 	r.Call("set-nextcloud-version-hint", tasks.apiSetNextcloudVersionHint)
 	r.Call("nextcloud-cron", tasks.apiNextcloudCron)
+	r.Call("metrics-token", tasks.apiMetricsToken)
 
 	return r
 }
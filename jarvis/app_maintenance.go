@@ -0,0 +1,160 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"log"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/settings"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
+	"shanhu.io/homedrv/drv/homeapp"
+)
+
+// maintenanceExpiry is how long doorway keeps a maintenance mark after the
+// ETA if it is never cleared.
+const maintenanceExpiry = time.Hour
+
+// appMaintenance tracks the apps that are under maintenance. The marks are
+// saved in settings so that they survive restarts of doorway, and pushed to
+// the running doorway on each change.
+type appMaintenance struct {
+	settings settings.Settings
+	domains  *appDomains
+	push     func(hosts map[string]*doorwaypkg.HostMaintenance) error
+}
+
+func newAppMaintenance(d *drive) *appMaintenance {
+	return &appMaintenance{
+		settings: d.settings,
+		domains:  d.appDomains,
+		push: func(hosts map[string]*doorwaypkg.HostMaintenance) error {
+			const p = "/set-maintenance"
+			return callDoorwayAdmin(d, p, hosts, nil)
+		},
+	}
+}
+
+func (m *appMaintenance) load() (map[string]*homeapp.Maintenance, error) {
+	apps := make(map[string]*homeapp.Maintenance)
+	if err := m.settings.Get(keyAppsMaintenance, &apps); err != nil {
+		if errcode.IsNotFound(err) {
+			return apps, nil
+		}
+		return nil, err
+	}
+	return apps, nil
+}
+
+// hosts returns the hosts that are under maintenance, for doorway.
+func (m *appMaintenance) hosts() (
+	map[string]*doorwaypkg.HostMaintenance, error,
+) {
+	apps, err := m.load()
+	if err != nil {
+		return nil, errcode.Annotate(err, "load apps under maintenance")
+	}
+	if len(apps) == 0 {
+		return nil, nil
+	}
+	domains, err := m.domains.list()
+	if err != nil {
+		return nil, errcode.Annotate(err, "list app domains")
+	}
+
+	hosts := make(map[string]*doorwaypkg.HostMaintenance)
+	for _, dm := range domains {
+		mt, ok := apps[dm.App]
+		if !ok {
+			continue
+		}
+		var expires time.Time
+		if !mt.ETA.IsZero() {
+			expires = mt.ETA.Add(maintenanceExpiry)
+		}
+		for domain := range dm.Map {
+			hosts[domain] = &doorwaypkg.HostMaintenance{
+				Message: mt.Message,
+				ETA:     mt.ETA,
+				Expires: expires,
+			}
+		}
+	}
+	return hosts, nil
+}
+
+func (m *appMaintenance) save(apps map[string]*homeapp.Maintenance) error {
+	if err := m.settings.Set(keyAppsMaintenance, apps); err != nil {
+		return errcode.Annotate(err, "save apps under maintenance")
+	}
+	hosts, err := m.hosts()
+	if err != nil {
+		return err
+	}
+	if err := m.push(hosts); err != nil {
+		// Doorway might be down or being upgraded. It reads the marks
+		// from settings when it is recreated.
+		log.Println("push maintenance hosts to doorway: ", err)
+	}
+	return nil
+}
+
+func (m *appMaintenance) SetMaintenance(
+	app string, mt *homeapp.Maintenance,
+) error {
+	apps, err := m.load()
+	if err != nil {
+		return errcode.Annotate(err, "load apps under maintenance")
+	}
+	if mt == nil {
+		mt = new(homeapp.Maintenance)
+	}
+	apps[app] = mt
+	return m.save(apps)
+}
+
+func (m *appMaintenance) ClearMaintenance(app string) error {
+	apps, err := m.load()
+	if err != nil {
+		return errcode.Annotate(err, "load apps under maintenance")
+	}
+	if _, ok := apps[app]; !ok {
+		return nil
+	}
+	delete(apps, app)
+	return m.save(apps)
+}
+
+// clearAll clears all the maintenance marks. This is called when jarvis
+// starts, as no app can be under maintenance by then; the marks are left
+// over when jarvis restarted in the middle of an upgrade.
+func (m *appMaintenance) clearAll() error {
+	apps, err := m.load()
+	if err != nil {
+		return errcode.Annotate(err, "load apps under maintenance")
+	}
+	if len(apps) == 0 {
+		return nil
+	}
+	return m.save(make(map[string]*homeapp.Maintenance))
+}
+
+// driveDomains implements homeapp.Domains for apps.
+type driveDomains struct {
+	*appDomains
+	*appMaintenance
+}
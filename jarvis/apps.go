@@ -16,11 +16,14 @@
 package jarvis
 
 import (
+	"fmt"
 	"log"
+	"time"
 
 	"shanhu.io/g/errcode"
 	"shanhu.io/g/strutil"
 	"shanhu.io/homedrv/drv/drvapi"
+	"shanhu.io/homedrv/drv/homeapp"
 )
 
 func sameAppVersion(m1, m2 *drvapi.AppMeta) bool {
@@ -36,6 +39,10 @@ type apps struct {
 	store   appsStateStore
 	querier appQuerier
 	maker   appMaker // app stub maker
+
+	// For marking app domains as under maintenance during upgrades.
+	// Optional.
+	domains homeapp.Domains
 }
 
 type appsConfig struct {
@@ -78,8 +85,44 @@ func (a *apps) setMaker(m appMaker) error {
 	return nil
 }
 
+func (a *apps) setDomains(d homeapp.Domains) { a.domains = d }
+
 func (a *apps) saveState() error { return a.store.save(a.state) }
 
+// upgradeETA estimates how long an upgrade takes. Apps with upgrade
+// ladders take longer for each step.
+func upgradeETA(old, m *drvapi.AppMeta) time.Duration {
+	const base = 5 * time.Minute
+	const perStep = 10 * time.Minute
+	steps := len(m.Steps) - len(old.Steps)
+	if steps < 1 {
+		return base
+	}
+	return base + time.Duration(steps)*perStep
+}
+
+func (a *apps) startMaintenance(name string, old, m *drvapi.AppMeta) {
+	if a.domains == nil {
+		return
+	}
+	mt := &homeapp.Maintenance{
+		Message: fmt.Sprintf("Upgrading %s.", name),
+		ETA:     time.Now().Add(upgradeETA(old, m)),
+	}
+	if err := a.domains.SetMaintenance(name, mt); err != nil {
+		log.Printf("set maintenance for %q: %s", name, err)
+	}
+}
+
+func (a *apps) endMaintenance(name string) {
+	if a.domains == nil {
+		return
+	}
+	if err := a.domains.ClearMaintenance(name); err != nil {
+		log.Printf("clear maintenance for %q: %s", name, err)
+	}
+}
+
 func (a *apps) stub(name string) (*appStub, error) {
 	stub, ok := a.m[name]
 	if !ok {
@@ -172,7 +215,14 @@ func (a *apps) apply(anchored []string) error {
 			}
 
 			log.Printf("%s %s", action, name)
-			if err := app.Change(old, m); err != nil {
+			if old != nil {
+				a.startMaintenance(name, old, m)
+			}
+			err = app.Change(old, m)
+			if old != nil {
+				a.endMaintenance(name)
+			}
+			if err != nil {
 				return errcode.Annotatef(err, "%s %q", action, name)
 			}
 
@@ -35,6 +35,7 @@ type backend struct {
 	users        *users
 	securityLogs *securityLogs
 	appDomains   *appDomains
+	ddnsLogs     *ddnsLogs
 }
 
 func newBackend(h *osutil.Home) (*backend, error) {
@@ -67,6 +68,7 @@ func newBackend(h *osutil.Home) (*backend, error) {
 		users:        users,
 		securityLogs: secLogs,
 		appDomains:   newAppDomains(tables),
+		ddnsLogs:     newDDNSLogs(tables),
 	}
 
 	users.setOnChangePassword(func(u string) {
@@ -131,5 +133,6 @@ func (b *backend) kernel() *kernel {
 	return &kernel{
 		settings:   b.settings,
 		appDomains: b.appDomains,
+		ddnsLogs:   b.ddnsLogs,
 	}
 }
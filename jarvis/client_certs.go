@@ -0,0 +1,341 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/settings"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	caValidity         = 10 * 365 * 24 * time.Hour
+	clientCertValidity = 2 * 365 * 24 * time.Hour
+)
+
+// caData is a private CA, like the one that signs client certificates.
+// It is saved in settings, like the identity of jarvis.
+type caData struct {
+	Key  []byte // PKCS#8 encoded private key.
+	Cert []byte // DER encoded certificate.
+}
+
+func (d *caData) parse() (*x509.Certificate, crypto.Signer, error) {
+	cert, err := x509.ParseCertificate(d.Cert)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "parse ca cert")
+	}
+	k, err := x509.ParsePKCS8PrivateKey(d.Key)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "parse ca key")
+	}
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, nil, errcode.Internalf("ca key is not a signer")
+	}
+	return cert, key, nil
+}
+
+// loadCA loads a private CA from settings. It returns nil when the CA
+// does not exist yet.
+func loadCA(s settings.Settings, key string) (
+	*x509.Certificate, crypto.Signer, error,
+) {
+	data := new(caData)
+	if err := s.Get(key, data); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return data.parse()
+}
+
+// caTemplate returns the certificate template of a new private CA.
+func caTemplate(name string) (*x509.Certificate, error) {
+	serial, err := randSerial()
+	if err != nil {
+		return nil, errcode.Annotate(err, "generate serial")
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil
+}
+
+// createCA creates a new private CA with the certificate template, and
+// saves it in settings.
+func createCA(s settings.Settings, key string, tmpl *x509.Certificate) (
+	*x509.Certificate, crypto.Signer, error,
+) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "generate ca key")
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, tmpl, tmpl, ecKey.Public(), ecKey,
+	)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "create ca cert")
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "marshal ca key")
+	}
+	data := &caData{Key: keyBytes, Cert: der}
+	if err := s.Set(key, data); err != nil {
+		return nil, nil, errcode.Annotate(err, "save ca")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "parse ca cert")
+	}
+	return cert, ecKey, nil
+}
+
+// ClientCertInfo is the record of an issued client certificate.
+type ClientCertInfo struct {
+	Serial  string // Lower case hex, same as doorwaypkg.CertSerial.
+	User    string
+	Name    string // Name of the device or the purpose.
+	Issued  time.Time
+	Expires time.Time
+	Revoked *time.Time `json:",omitempty"`
+}
+
+// clientCerts manages the client CA and the client certificates that
+// doorway uses to authenticate clients of selected hosts.
+type clientCerts struct {
+	settings settings.Settings
+	caName   string
+
+	mu sync.Mutex
+}
+
+func newClientCerts(s settings.Settings, name string) *clientCerts {
+	return &clientCerts{
+		settings: s,
+		caName:   "HomeDrive client CA " + name,
+	}
+}
+
+func randSerial() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, limit)
+}
+
+// ca loads the client CA, or creates one if it does not exist yet.
+func (c *clientCerts) ca() (*x509.Certificate, crypto.Signer, error) {
+	cert, key, err := loadCA(c.settings, keyClientCA)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cert != nil {
+		return cert, key, nil
+	}
+	tmpl, err := caTemplate(c.caName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return createCA(c.settings, keyClientCA, tmpl)
+}
+
+// ensureCA creates the client CA if it does not exist yet.
+func (c *clientCerts) ensureCA() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, _, err := c.ca()
+	return err
+}
+
+func (c *clientCerts) load() ([]*ClientCertInfo, error) {
+	var certs []*ClientCertInfo
+	if err := c.settings.Get(keyClientCerts, &certs); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return certs, nil
+}
+
+func (c *clientCerts) list() ([]*ClientCertInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.load()
+}
+
+// issue issues a new client certificate for a user, and returns it in a
+// PKCS#12 file protected by the password.
+func (c *clientCerts) issue(user, name, password string) (
+	*ClientCertInfo, []byte, error,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certs, err := c.load()
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "load issued certs")
+	}
+	caCert, caKey, err := c.ca()
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "load client ca")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "generate key")
+	}
+	serial, err := randSerial()
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "generate serial")
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         user,
+			OrganizationalUnit: []string{name},
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(clientCertValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, tmpl, caCert, key.Public(), caKey,
+	)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "create cert")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "parse cert")
+	}
+
+	p12, err := pkcs12.Modern.Encode(
+		key, cert, []*x509.Certificate{caCert}, password,
+	)
+	if err != nil {
+		return nil, nil, errcode.Annotate(err, "encode pkcs12")
+	}
+
+	info := &ClientCertInfo{
+		Serial:  doorwaypkg.CertSerial(cert),
+		User:    user,
+		Name:    name,
+		Issued:  now,
+		Expires: cert.NotAfter,
+	}
+	certs = append(certs, info)
+	if err := c.settings.Set(keyClientCerts, certs); err != nil {
+		return nil, nil, errcode.Annotate(err, "save issued certs")
+	}
+	return info, p12, nil
+}
+
+// revoke revokes an issued client certificate.
+func (c *clientCerts) revoke(serial string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certs, err := c.load()
+	if err != nil {
+		return errcode.Annotate(err, "load issued certs")
+	}
+	serial = strings.ToLower(serial)
+	for _, info := range certs {
+		if info.Serial != serial {
+			continue
+		}
+		if info.Revoked == nil {
+			now := time.Now()
+			info.Revoked = &now
+		}
+		return c.settings.Set(keyClientCerts, certs)
+	}
+	return errcode.NotFoundf("client cert %q not found", serial)
+}
+
+// doorwayConfig returns the client auth config for doorway. It returns
+// nil when the client CA is not created yet.
+func (c *clientCerts) doorwayConfig() (*doorwaypkg.ClientAuthConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	caCert, _, err := loadCA(c.settings, keyClientCA)
+	if err != nil {
+		return nil, errcode.Annotate(err, "load client ca")
+	}
+	if caCert == nil {
+		return nil, nil
+	}
+	certs, err := c.load()
+	if err != nil {
+		return nil, errcode.Annotate(err, "load issued certs")
+	}
+
+	now := time.Now()
+	var revoked []string
+	for _, info := range certs {
+		// Expired certificates fail the verification anyway.
+		if info.Revoked != nil && now.Before(info.Expires) {
+			revoked = append(revoked, info.Serial)
+		}
+	}
+	sort.Strings(revoked)
+
+	pemBytes := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: caCert.Raw,
+	})
+	return &doorwaypkg.ClientAuthConfig{
+		CACerts: string(pemBytes),
+		Revoked: revoked,
+	}, nil
+}
+
+func loadClientCertHosts(s settings.Settings) ([]string, error) {
+	var hosts []string
+	if err := s.Get(keyClientCertHosts, &hosts); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return hosts, nil
+}
@@ -19,6 +19,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"os"
+	"strconv"
 	"strings"
 
 	"shanhu.io/g/errcode"
@@ -27,7 +29,9 @@ import (
 	"shanhu.io/g/jsonutil"
 	"shanhu.io/g/jsonx"
 	"shanhu.io/g/subcmd"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
 	"shanhu.io/homedrv/drv/drvapi"
+	"shanhu.io/homedrv/drv/hostos"
 )
 
 func clientCommands() *subcmd.List {
@@ -40,12 +44,66 @@ func clientCommands() *subcmd.List {
 	c.Add("settings", "prints settings", cmdSettings)
 
 	c.Add("update", "hints to check update", cmdUpdate)
+	c.Add("reboot", "reboots the host", cmdReboot)
+	c.Add("disks", "lists unused disks and partitions", cmdDisks)
+	c.Add("format-disk", "formats an unused disk as ext4", cmdFormatDisk)
+	c.Add("mount-disk", "mounts a data disk persistently", cmdMountDisk)
 	c.Add("set-password", "sets password of a user", cmdSetPassword)
 	c.Add("disable-totp", "disables TOTP 2FA", cmdDisableTOTP)
 	c.Add(
 		"custom-subs", "view or modify additional custom subdomains",
 		cmdCustomSubs,
 	)
+	c.Add(
+		"domain-ip-filters", "view or modify IP filters of domains",
+		cmdDomainIPFilters,
+	)
+	c.Add(
+		"set-doorway-dns01",
+		"sets the dns01 certificate config of doorway",
+		cmdSetDoorwayDNS01,
+	)
+	c.Add(
+		"set-doorway-error-page",
+		"sets a custom error page of doorway",
+		cmdSetDoorwayErrorPage,
+	)
+	c.Add(
+		"set-fabrics-servers",
+		"sets the fabrics servers for doorway to choose from",
+		cmdSetFabricsServers,
+	)
+	c.Add(
+		"set-doorway-trusted-proxies",
+		"sets the load balancers that may send PROXY protocol headers",
+		cmdSetDoorwayTrustedProxies,
+	)
+	c.Add(
+		"set-ddns", "sets the dynamic DNS updater config", cmdSetDDNS,
+	)
+	c.Add("ddns", "prints dynamic DNS status and history", cmdDDNS)
+	c.Add(
+		"set-mdns", "enables or disables announcing on the local network",
+		cmdSetMDNS,
+	)
+	c.Add(
+		"metrics-token", "prints the bearer token for scraping metrics",
+		cmdMetricsToken,
+	)
+	c.Add(
+		"issue-client-cert", "issues a client certificate as PKCS#12",
+		cmdIssueClientCert,
+	)
+	c.Add("client-certs", "lists issued client certificates", cmdClientCerts)
+	c.Add(
+		"revoke-client-cert", "revokes a client certificate",
+		cmdRevokeClientCert,
+	)
+	c.Add(
+		"set-client-cert-hosts",
+		"sets the hosts that require client certificates",
+		cmdSetClientCertHosts,
+	)
 
 	// Nextcloud related
 	c.Add(
@@ -62,6 +120,11 @@ func clientCommands() *subcmd.List {
 	)
 	c.Add("nextcloud-cron", "runs nextcloud cron job", cmdNextcloudCron)
 
+	// Runs inside the mdns responder container.
+	c.Add(
+		"mdns-responder", "runs the mdns responder", cmdMDNSResponder,
+	)
+
 	// OS upgrade
 	// Important for OS upgrade; do not remove this.
 	c.Add(
@@ -88,6 +151,68 @@ func cmdUpdate(args []string) error {
 	return c.Call("/api/admin/update", nil, nil)
 }
 
+func cmdReboot(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	args = flags.ParseArgs(args)
+	if len(args) != 0 {
+		return errcode.InvalidArgf("expect no arg")
+	}
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/reboot", nil, nil)
+}
+
+func cmdDisks(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	_ = flags.ParseArgs(args)
+
+	var devs []*hostos.BlockDevice
+	c := httputil.NewUnixClient(*sock)
+	if err := c.Call("/api/admin/list-unused-disks", nil, &devs); err != nil {
+		return err
+	}
+	jsonutil.Print(devs)
+	return nil
+}
+
+func cmdFormatDisk(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	label := flags.String("label", "", "label of the filesystem")
+	confirm := flags.String(
+		"confirm", "", "repeat the device to confirm; all data is lost",
+	)
+	args = flags.ParseArgs(args)
+	if len(args) != 1 {
+		return errcode.InvalidArgf("expect the device")
+	}
+
+	req := &FormatDiskRequest{
+		Device:  args[0],
+		Label:   *label,
+		Confirm: *confirm,
+	}
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/format-disk", req, nil)
+}
+
+func cmdMountDisk(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	args = flags.ParseArgs(args)
+	if len(args) != 2 {
+		return errcode.InvalidArgf("expect the device and the mountpoint")
+	}
+
+	req := &MountDiskRequest{
+		Device:     args[0],
+		Mountpoint: args[1],
+	}
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/mount-disk", req, nil)
+}
+
 func cmdVersion(args []string) error {
 	flags := cmdFlags.New()
 	cflags := newClientFlags(flags)
@@ -191,6 +316,245 @@ func cmdSetNextcloudExtraMount(args []string) error {
 	return c.Call("/api/admin/set-nextcloud-extramnt", m, nil)
 }
 
+func cmdSetDoorwayDNS01(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	disable := flags.Bool("disable", false, "disables dns01")
+	args = flags.ParseArgs(args)
+
+	config := new(doorwaypkg.DNS01Config)
+	if *disable {
+		if len(args) != 0 {
+			return errcode.InvalidArgf("disable takes no arg")
+		}
+	} else {
+		if len(args) != 1 {
+			return errcode.InvalidArgf("expect a config file")
+		}
+		if err := jsonx.ReadFile(args[0], config); err != nil {
+			return errcode.Annotate(err, "read config file")
+		}
+	}
+
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/set-doorway-dns01", config, nil)
+}
+
+func cmdSetDoorwayErrorPage(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	reset := flags.Bool("reset", false, "uses the default page")
+	args = flags.ParseArgs(args)
+
+	req := new(SetDoorwayErrorPageRequest)
+	if *reset {
+		if len(args) != 1 {
+			return errcode.InvalidArgf("expect the page name")
+		}
+		req.Name = args[0]
+	} else {
+		if len(args) != 2 {
+			return errcode.InvalidArgf("expect the page name and a file")
+		}
+		req.Name = args[0]
+		bs, err := os.ReadFile(args[1])
+		if err != nil {
+			return errcode.Annotate(err, "read page file")
+		}
+		req.HTML = string(bs)
+	}
+
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/set-doorway-error-page", req, nil)
+}
+
+func cmdSetFabricsServers(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	args = flags.ParseArgs(args)
+
+	// Each arg is a host with an optional weight, like "host:2". No args
+	// goes back to the single default server.
+	servers := []*doorwaypkg.FabricsServer{}
+	for _, arg := range args {
+		server := &doorwaypkg.FabricsServer{Host: arg}
+		if i := strings.LastIndex(arg, ":"); i >= 0 {
+			w, err := strconv.Atoi(arg[i+1:])
+			if err != nil {
+				return errcode.InvalidArgf("invalid weight in %q", arg)
+			}
+			server.Host = arg[:i]
+			server.Weight = w
+		}
+		servers = append(servers, server)
+	}
+
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/set-fabrics-servers", servers, nil)
+}
+
+func cmdSetDDNS(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	disable := flags.Bool("disable", false, "disables dynamic dns")
+	args = flags.ParseArgs(args)
+
+	config := new(DDNSConfig)
+	if *disable {
+		if len(args) != 0 {
+			return errcode.InvalidArgf("disable takes no arg")
+		}
+	} else {
+		if len(args) != 1 {
+			return errcode.InvalidArgf("expect a config file")
+		}
+		if err := jsonx.ReadFile(args[0], config); err != nil {
+			return errcode.Annotate(err, "read config file")
+		}
+	}
+
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/set-ddns-config", config, nil)
+}
+
+func cmdSetMDNS(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	disable := flags.Bool("disable", false, "disables mdns announcing")
+	args = flags.ParseArgs(args)
+	if len(args) != 0 {
+		return errcode.InvalidArgf("expects no args")
+	}
+
+	config := &MDNSConfig{Disabled: *disable}
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/set-mdns-config", config, nil)
+}
+
+func cmdDDNS(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	_ = flags.ParseArgs(args)
+
+	info := new(DDNSInfo)
+	c := httputil.NewUnixClient(*sock)
+	if err := c.Call("/api/admin/ddns", nil, info); err != nil {
+		return err
+	}
+	jsonutil.Print(info)
+	return nil
+}
+
+func cmdSetDoorwayTrustedProxies(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	args = flags.ParseArgs(args)
+
+	// No args clears the list, which disables PROXY protocol.
+	list := []string{}
+	for _, arg := range args {
+		for _, entry := range strings.Split(arg, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				list = append(list, entry)
+			}
+		}
+	}
+
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/set-doorway-trusted-proxies", list, nil)
+}
+
+func cmdIssueClientCert(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	user := flags.String("user", rootUser, "user of the certificate")
+	name := flags.String("name", "", "name of the device")
+	pass := flags.String("pass", "", "password of the PKCS#12 file")
+	out := flags.String("out", "", "output file; default to <name>.p12")
+	_ = flags.ParseArgs(args)
+
+	if *name == "" {
+		return errcode.InvalidArgf("name is empty")
+	}
+	if *pass == "" {
+		return errcode.InvalidArgf("password is empty")
+	}
+	req := &IssueClientCertRequest{
+		User:     *user,
+		Name:     *name,
+		Password: *pass,
+	}
+	resp := new(IssueClientCertResponse)
+	c := httputil.NewUnixClient(*sock)
+	if err := c.Call("/api/admin/issue-client-cert", req, resp); err != nil {
+		return err
+	}
+
+	f := *out
+	if f == "" {
+		f = *name + ".p12"
+	}
+	if err := os.WriteFile(f, resp.P12, 0600); err != nil {
+		return errcode.Annotate(err, "write pkcs12 file")
+	}
+	fmt.Printf("issued %s, saved in %s\n", resp.Cert.Serial, f)
+	return nil
+}
+
+func cmdClientCerts(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	_ = flags.ParseArgs(args)
+
+	var certs []*ClientCertInfo
+	c := httputil.NewUnixClient(*sock)
+	if err := c.Call("/api/admin/list-client-certs", nil, &certs); err != nil {
+		return err
+	}
+	jsonutil.Print(certs)
+	return nil
+}
+
+func cmdRevokeClientCert(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	args = flags.ParseArgs(args)
+	if len(args) != 1 {
+		return errcode.InvalidArgf("expect the serial number")
+	}
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/revoke-client-cert", args[0], nil)
+}
+
+func cmdSetClientCertHosts(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	args = flags.ParseArgs(args)
+
+	// No args clears the list.
+	hosts := append([]string{}, args...)
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/set-client-cert-hosts", hosts, nil)
+}
+
+func cmdMetricsToken(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	renew := flags.Bool("renew", false, "replaces the token with a new one")
+	args = flags.ParseArgs(args)
+	if len(args) != 0 {
+		return errcode.InvalidArgf("expect no arg")
+	}
+
+	c := httputil.NewUnixClient(*sock)
+	var token string
+	if err := c.Call("/api/admin/metrics-token", *renew, &token); err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
 func cmdNextcloudCron(args []string) error {
 	flags := cmdFlags.New()
 	sock := declareJarvisSockFlag(flags)
@@ -16,19 +16,141 @@
 package jarvis
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
 
+	"golang.org/x/crypto/bcrypt"
 	"shanhu.io/g/errcode"
 	"shanhu.io/g/httputil"
 	"shanhu.io/g/nameutil"
 	"shanhu.io/g/settings"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
 	"shanhu.io/homedrv/drv/homeapp"
 )
 
-func loadCustomSubs(s settings.Settings) (map[string]string, error) {
-	customSubs := make(map[string]string)
+// customSub is a custom sub domain entry. The key of a custom sub might
+// include a path prefix, like "example.com/git/", which makes it a path
+// prefix route under the host.
+type customSub struct {
+	Dest        string
+	StripPrefix bool `json:",omitempty"`
+
+	// Auth is the authentication required for visiting the sub, can be
+	// customSubAuthBasic or customSubAuthJarvis. As browsers only send the
+	// session cookie of jarvis to the main domain, customSubAuthJarvis is
+	// only for path prefix subs under the main domain.
+	Auth string `json:",omitempty"`
+
+	// BasicAuth has htpasswd style "user:hash" entries for basic auth.
+	BasicAuth []string `json:",omitempty"`
+
+	// IPAllow and IPDeny are the IP allow and deny lists of the sub.
+	IPAllow []string `json:",omitempty"`
+	IPDeny  []string `json:",omitempty"`
+}
+
+const (
+	customSubAuthBasic  = "basic"
+	customSubAuthJarvis = "jarvis"
+)
+
+type customSubJSON customSub
+
+// UnmarshalJSON unmarshals a custom sub. Legacy custom subs are saved as
+// plain destination strings.
+func (s *customSub) UnmarshalJSON(bs []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(bs), []byte(`"`)) {
+		var dest string
+		if err := json.Unmarshal(bs, &dest); err != nil {
+			return err
+		}
+		*s = customSub{Dest: dest}
+		return nil
+	}
+	return json.Unmarshal(bs, (*customSubJSON)(s))
+}
+
+func (s *customSub) String() string {
+	var opts []string
+	if s.StripPrefix {
+		opts = append(opts, "strip prefix")
+	}
+	if s.Auth != "" {
+		opts = append(opts, s.Auth+" auth")
+	}
+	if len(s.IPAllow) > 0 {
+		opts = append(opts, "allow "+strings.Join(s.IPAllow, ","))
+	}
+	if len(s.IPDeny) > 0 {
+		opts = append(opts, "deny "+strings.Join(s.IPDeny, ","))
+	}
+	if len(opts) == 0 {
+		return s.Dest
+	}
+	return fmt.Sprintf("%s (%s)", s.Dest, strings.Join(opts, ", "))
+}
+
+func (s *customSub) doorwayAuth(jarvisURL string) *doorwaypkg.HostAuth {
+	switch s.Auth {
+	case customSubAuthBasic:
+		return &doorwaypkg.HostAuth{Basic: s.BasicAuth}
+	case customSubAuthJarvis:
+		return &doorwaypkg.HostAuth{Forward: jarvisURL}
+	}
+	return nil
+}
+
+func makeCustomSubAuth(sub *customSub, auth, user, pass string) error {
+	switch auth {
+	case "":
+		return nil
+	case customSubAuthJarvis:
+		sub.Auth = auth
+		return nil
+	case customSubAuthBasic:
+		if user == "" || pass == "" {
+			return errcode.InvalidArgf("basic auth needs user and password")
+		}
+		if strings.Contains(user, ":") {
+			return errcode.InvalidArgf("user name cannot contain colon")
+		}
+		hash, err := bcrypt.GenerateFromPassword(
+			[]byte(pass), bcrypt.DefaultCost,
+		)
+		if err != nil {
+			return errcode.Annotate(err, "hash password")
+		}
+		sub.Auth = auth
+		sub.BasicAuth = []string{user + ":" + string(hash)}
+		return nil
+	}
+	return errcode.InvalidArgf("unknown auth %q", auth)
+}
+
+// jarvisAuthWorks checks if jarvis auth can work for a custom sub key. The
+// session cookie of jarvis is only sent to the main domain, so it only
+// works for path prefix subs under the main domain.
+func jarvisAuthWorks(key, mainDomain string) bool {
+	domain, prefix := splitCustomSub(key)
+	return mainDomain != "" && domain == mainDomain &&
+		strings.TrimSuffix(prefix, "/") != ""
+}
+
+// splitCustomSub splits a custom sub key into the domain and the path
+// prefix.
+func splitCustomSub(sub string) (domain, prefix string) {
+	idx := strings.Index(sub, "/")
+	if idx < 0 {
+		return sub, ""
+	}
+	return sub[:idx], sub[idx:]
+}
+
+func loadCustomSubs(s settings.Settings) (map[string]*customSub, error) {
+	customSubs := make(map[string]*customSub)
 	if err := s.Get(keyCustomSubs, &customSubs); err != nil {
 		if errcode.IsNotFound(err) {
 			// Just ignore and start with empty subs list.
@@ -46,6 +168,24 @@ func cmdCustomSubs(args []string) error {
 	)
 	add := flags.Bool("add", false, "adds a sub domain")
 	remove := flags.Bool("remove", false, "removes a sub domain")
+	stripPrefix := flags.Bool(
+		"strip_prefix", false,
+		"strips the path prefix when adding a sub domain with a path",
+	)
+	auth := flags.String(
+		"auth", "",
+		`requires authentication when adding; can be "basic" or "jarvis"`,
+	)
+	authUser := flags.String("auth_user", "", "user name for basic auth")
+	authPass := flags.String("auth_pass", "", "password for basic auth")
+	ipAllow := flags.String(
+		"ip_allow", "",
+		"comma separated IP ranges or presets (lan, tailscale, loopback) "+
+			"that can visit the sub",
+	)
+	ipDeny := flags.String(
+		"ip_deny", "", "comma separated IP ranges or presets to block",
+	)
 	cflags := newClientFlags(flags)
 	args = flags.ParseArgs(args)
 	list := !*add && !*remove
@@ -76,26 +216,38 @@ func cmdCustomSubs(args []string) error {
 	}
 
 	fullDomain := func(sub string) (string, error) {
+		// Path prefix, if any, is kept as is.
+		sub, prefix := splitCustomSub(sub)
+
+		// A wildcard can only be the whole left-most label.
+		rest := strings.TrimPrefix(sub, "*.")
+		if sub != "*" && strings.Contains(rest, "*") {
+			return "", errcode.InvalidArgf("invalid wildcard domain %q", sub)
+		}
+
 		// See if user specified a full domain name or just the subdomain.
 		idx := strings.Index(sub, ".")
 		if idx > 0 {
 			// User specified a full domain name. Nothing else to do.
-			return sub, nil
+			return sub + prefix, nil
 		}
 		if idx == 0 {
 			return "", errcode.InvalidArgf("subdomain can not start with dot")
 		}
 
-		// User only specified the custom subdomain label.
-		if err := nameutil.CheckLabel(sub); err != nil {
-			return "", errcode.Annotate(err, "check subdomain")
+		// User only specified the custom subdomain label, or "*" for all
+		// subdomains under the main domain.
+		if sub != "*" {
+			if err := nameutil.CheckLabel(sub); err != nil {
+				return "", errcode.Annotate(err, "check subdomain")
+			}
 		}
 
 		mainDomain, err := settings.String(d.settings, homeapp.KeyMainDomain)
 		if err != nil {
 			return "", errcode.Annotate(err, "expand subdomain")
 		}
-		return sub + "." + mainDomain, nil
+		return sub + "." + mainDomain + prefix, nil
 	}
 
 	if *add {
@@ -106,12 +258,48 @@ func cmdCustomSubs(args []string) error {
 		if err != nil {
 			return errcode.Annotate(err, "get full domain name")
 		}
+		if *stripPrefix {
+			if _, prefix := splitCustomSub(domain); prefix == "" {
+				return errcode.InvalidArgf(
+					"strip prefix requires a path prefix",
+				)
+			}
+		}
 		dest := args[1]
 
 		if _, ok := subMap[domain]; ok {
 			return errcode.InvalidArgf("subdomain %q already exist", domain)
 		}
-		subMap[domain] = dest
+		sub := &customSub{
+			Dest:        dest,
+			StripPrefix: *stripPrefix,
+		}
+		if err := makeCustomSubAuth(
+			sub, *auth, *authUser, *authPass,
+		); err != nil {
+			return errcode.Annotate(err, "set auth")
+		}
+		if sub.Auth == customSubAuthJarvis {
+			mainDomain, err := settings.String(
+				d.settings, homeapp.KeyMainDomain,
+			)
+			if err != nil {
+				return errcode.Annotate(err, "read main domain")
+			}
+			if !jarvisAuthWorks(domain, mainDomain) {
+				return errcode.InvalidArgf(
+					"jarvis auth only works for paths under %q",
+					mainDomain,
+				)
+			}
+		}
+		filter, err := parseIPFilterFlags(*ipAllow, *ipDeny)
+		if err != nil {
+			return err
+		}
+		sub.IPAllow = filter.Allow
+		sub.IPDeny = filter.Deny
+		subMap[domain] = sub
 	} else if *remove {
 		if len(args) != 1 {
 			return errcode.InvalidArgf("remove command takes 1 argument")
@@ -0,0 +1,45 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"testing"
+)
+
+func TestJarvisAuthWorks(t *testing.T) {
+	const main = "example.com"
+	for _, test := range []struct {
+		key  string
+		want bool
+	}{
+		{"example.com/git/", true},
+		{"example.com/git", true},
+		{"example.com/", false},
+		{"example.com", false},
+		{"git.example.com/x/", false},
+		{"git.example.com", false},
+	} {
+		if got := jarvisAuthWorks(test.key, main); got != test.want {
+			t.Errorf(
+				"jarvisAuthWorks(%q) got %t, want %t",
+				test.key, got, test.want,
+			)
+		}
+	}
+	if jarvisAuthWorks("example.com/git/", "") {
+		t.Error("jarvis auth should not work without main domain")
+	}
+}
@@ -39,6 +39,10 @@ type DashboardData struct {
 	TwoFactorAuth *Dashboard2FAData          `json:",omitempty"`
 	SecurityLogs  *DashboardSecurityLogsData `json:",omitempty"`
 	SSHKeys       *DashboardSSHKeysData      `json:",omitempty"`
+	AccessLogs    *DashboardAccessLogsData   `json:",omitempty"`
+	Upstreams     *DashboardUpstreamsData    `json:",omitempty"`
+	LocalHTTPS    *DashboardLocalHTTPSData   `json:",omitempty"`
+	Disks         *DashboardDisksData        `json:",omitempty"`
 }
 
 func newDashboardData(s *server, c *aries.C, req *DashboardDataRequest) (
@@ -90,6 +94,30 @@ func newDashboardData(s *server, c *aries.C, req *DashboardDataRequest) (
 			return nil, err
 		}
 		d.SSHKeys = dat
+	case "access-logs":
+		dat, err := newDashboardAccessLogsData(s, c)
+		if err != nil {
+			return nil, err
+		}
+		d.AccessLogs = dat
+	case "upstreams":
+		dat, err := newDashboardUpstreamsData(s, c)
+		if err != nil {
+			return nil, err
+		}
+		d.Upstreams = dat
+	case "local-https":
+		dat, err := newDashboardLocalHTTPSData(s, c)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalHTTPS = dat
+	case "disks":
+		dat, err := newDashboardDisksData(s, c)
+		if err != nil {
+			return nil, err
+		}
+		d.Disks = dat
 	}
 	return d, nil
 }
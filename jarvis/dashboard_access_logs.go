@@ -0,0 +1,99 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"net/url"
+
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/httputil"
+	"shanhu.io/g/rand"
+	"shanhu.io/g/settings"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
+)
+
+// doorwayAdminToken returns the token for calling doorway's admin
+// server. A new token is generated on the first call.
+func doorwayAdminToken(s settings.Settings) (string, error) {
+	token, err := settings.String(s, keyDoorwayAdminToken)
+	if err == nil {
+		return token, nil
+	}
+	if !errcode.IsNotFound(err) {
+		return "", err
+	}
+	const tokenLen = 32
+	token = rand.Letters(tokenLen)
+	if err := s.Set(keyDoorwayAdminToken, token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// doorwayAdmin returns the client for calling doorway's admin server.
+// The admin server is only reachable from the container network, and
+// requires the admin token.
+func doorwayAdmin(d *drive) (*httputil.Client, error) {
+	token, err := doorwayAdminToken(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read doorway admin token")
+	}
+	return &httputil.Client{
+		Server: &url.URL{
+			Scheme: "http",
+			Host:   d.cont(nameDoorway) + doorwaypkg.DefaultAdminAddr,
+		},
+		Token: token,
+	}, nil
+}
+
+// callDoorwayAdmin calls an API on doorway's admin server.
+func callDoorwayAdmin(d *drive, p string, req, resp interface{}) error {
+	c, err := doorwayAdmin(d)
+	if err != nil {
+		return err
+	}
+	return c.Call(p, req, resp)
+}
+
+func doorwayAccessLogs(d *drive, n int) (
+	[]*doorwaypkg.AccessLogEntry, error,
+) {
+	req := &doorwaypkg.AccessLogsRequest{N: n}
+	var entries []*doorwaypkg.AccessLogEntry
+	const p = "/access-logs"
+	if err := callDoorwayAdmin(d, p, req, &entries); err != nil {
+		return nil, errcode.Annotate(err, "fetch doorway access logs")
+	}
+	return entries, nil
+}
+
+// DashboardAccessLogsData has the recent access log entries of doorway
+// for the dashboard.
+type DashboardAccessLogsData struct {
+	Entries []*doorwaypkg.AccessLogEntry
+}
+
+func newDashboardAccessLogsData(s *server, _ *aries.C) (
+	*DashboardAccessLogsData, error,
+) {
+	entries, err := doorwayAccessLogs(s.drive, 200)
+	if err != nil {
+		return nil, aries.AltInternal(err, "fail to fetch access logs")
+	}
+	return &DashboardAccessLogsData{Entries: entries}, nil
+}
@@ -16,20 +16,59 @@
 package jarvis
 
 import (
+	"fmt"
+	"log"
 	"net/url"
+	"time"
 
 	"shanhu.io/g/errcode"
-	"shanhu.io/homedrv/drv/burmilla"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
 	"shanhu.io/homedrv/drv/homeapp/nextcloud"
+	"shanhu.io/homedrv/drv/hostos"
 )
 
 // DashboardOverviewData contains the data for dashboard overview.
 type DashboardOverviewData struct {
 	NoSysDock       bool
+	HostOS          string `json:",omitempty"` // Like "burmilla".
 	NextcloudDomain string
 	IPAddrs         []string
-	DiskUsage       *diskUsage
+	IPv6Addrs       []string
+	DiskUsage       *diskUsage // Of the docker data root.
 	UptimeSecs      int64
+
+	// NextcloudDataUsage is the disk usage of the nextcloud data mount.
+	// Nil when the nextcloud data is in a docker volume.
+	NextcloudDataUsage *diskUsage `json:",omitempty"`
+
+	// Tunnel summarizes the fabrics tunnel status, like "connected via
+	// fabrics.homedrive.io for 3h0m0s". Empty when not using fabrics.
+	Tunnel  string
+	Fabrics *doorwaypkg.FabricsStatus
+
+	// DDNS is the status of the dynamic DNS updater. Nil when it is not
+	// enabled.
+	DDNS *DDNSStatus `json:",omitempty"`
+}
+
+// tunnelSummary summarizes the fabrics tunnel status in one line.
+func tunnelSummary(status *doorwaypkg.FabricsStatus, now time.Time) string {
+	t := status.Tunnel
+	if t == nil {
+		return ""
+	}
+	if t.Connected {
+		via := status.Active
+		if via == "" {
+			via = t.Addr
+		}
+		d := now.Sub(t.Since).Truncate(time.Minute)
+		return fmt.Sprintf("connected via %s for %s", via, d)
+	}
+	if t.LastError != "" {
+		return fmt.Sprintf("down: %s", t.LastError)
+	}
+	return "down"
 }
 
 type diskSize struct {
@@ -49,6 +88,13 @@ type diskUsage struct {
 	Free  *diskSize
 }
 
+func newDiskUsage(du *hostos.DiskUsage) *diskUsage {
+	return &diskUsage{
+		Total: newDiskSize(du.Total),
+		Free:  newDiskSize(du.Free),
+	}
+}
+
 func newDashboardOverviewData(s *server) (*DashboardOverviewData, error) {
 	d := new(DashboardOverviewData)
 
@@ -67,28 +113,68 @@ func newDashboardOverviewData(s *server) (*DashboardOverviewData, error) {
 		}).String()
 	}
 
-	if s.drive.sysDock != nil {
-		b, err := s.drive.burmilla()
+	// Doorway might be restarting; do not fail the whole page.
+	if fabrics, err := doorwayFabrics(s.drive); err != nil {
+		log.Println("fetch fabrics status: ", err)
+	} else {
+		d.Fabrics = fabrics
+		d.Tunnel = tunnelSummary(fabrics, time.Now())
+	}
+
+	ddns, err := loadDDNSConfig(s.drive.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read ddns config")
+	}
+	if ddns != nil && ddns.enabled() {
+		status, err := loadDDNSStatus(s.drive.settings)
+		if err != nil {
+			return nil, errcode.Annotate(err, "read ddns status")
+		}
+		if status == nil {
+			status = new(DDNSStatus)
+		}
+		d.DDNS = status
+	}
+
+	if s.drive.hasHostOS() {
+		host, err := s.drive.hostOS()
 		if err != nil {
-			return nil, errcode.Annotate(err, "init burmilla OS stub")
+			return nil, errcode.Annotate(err, "init host OS stub")
 		}
+		d.HostOS = host.Name()
 
-		ips, err := burmilla.HostIPs(b, "eth0")
+		addrs, err := host.Addrs()
 		if err != nil {
 			return nil, errcode.Annotate(err, "get IP address")
 		}
-		d.IPAddrs = ips
+		for _, a := range addrs {
+			line := a.Dev + ": " + a.Addr
+			if a.IPv6 {
+				d.IPv6Addrs = append(d.IPv6Addrs, line)
+			} else {
+				d.IPAddrs = append(d.IPAddrs, line)
+			}
+		}
 
-		du, err := burmilla.QueryDiskUsage(b)
+		du, err := host.DiskUsage()
 		if err != nil {
 			return nil, errcode.Annotate(err, "get disk usage")
 		}
-		d.DiskUsage = &diskUsage{
-			Total: newDiskSize(du.Total),
-			Free:  newDiskSize(du.Free),
+		d.DiskUsage = newDiskUsage(du)
+
+		ncPath, err := nextcloudDataPath(s.drive.settings)
+		if err != nil {
+			return nil, errcode.Annotate(err, "read nextcloud data mount")
+		}
+		if ncPath != hostos.DockerRoot {
+			ncUsage, err := host.PathUsage(ncPath)
+			if err != nil {
+				return nil, errcode.Annotate(err, "get nextcloud data usage")
+			}
+			d.NextcloudDataUsage = newDiskUsage(ncUsage)
 		}
 
-		uptime, err := burmilla.Uptime(b)
+		uptime, err := host.Uptime()
 		if err != nil {
 			return nil, errcode.Annotate(err, "query system uptime")
 		}
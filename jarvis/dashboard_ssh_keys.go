@@ -31,7 +31,7 @@ type DashboardSSHKeysData struct {
 func newDashboardSSHKeysData(s *server, _ *aries.C) (
 	*DashboardSSHKeysData, error,
 ) {
-	if !s.drive.hasSys() {
+	if !s.drive.hasHostOS() {
 		return &DashboardSSHKeysData{Disabled: true}, nil
 	}
 
@@ -0,0 +1,59 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
+)
+
+func doorwayUpstreams(d *drive) ([]*doorwaypkg.UpstreamStatus, error) {
+	var ups []*doorwaypkg.UpstreamStatus
+	if err := callDoorwayAdmin(d, "/upstreams", nil, &ups); err != nil {
+		return nil, errcode.Annotate(err, "fetch doorway upstreams")
+	}
+	return ups, nil
+}
+
+func doorwayFabrics(d *drive) (*doorwaypkg.FabricsStatus, error) {
+	status := new(doorwaypkg.FabricsStatus)
+	if err := callDoorwayAdmin(d, "/fabrics", nil, status); err != nil {
+		return nil, errcode.Annotate(err, "fetch doorway fabrics status")
+	}
+	return status, nil
+}
+
+// DashboardUpstreamsData has the health status of the upstreams that
+// doorway proxies to, and the fabrics servers that doorway connects to.
+type DashboardUpstreamsData struct {
+	Upstreams []*doorwaypkg.UpstreamStatus
+	Fabrics   *doorwaypkg.FabricsStatus
+}
+
+func newDashboardUpstreamsData(s *server, _ *aries.C) (
+	*DashboardUpstreamsData, error,
+) {
+	ups, err := doorwayUpstreams(s.drive)
+	if err != nil {
+		return nil, aries.AltInternal(err, "fail to fetch upstreams")
+	}
+	fabrics, err := doorwayFabrics(s.drive)
+	if err != nil {
+		return nil, aries.AltInternal(err, "fail to fetch fabrics status")
+	}
+	return &DashboardUpstreamsData{Upstreams: ups, Fabrics: fabrics}, nil
+}
@@ -0,0 +1,421 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/pisces"
+	"shanhu.io/g/settings"
+	"shanhu.io/homedrv/drv/dnsupdate"
+	"shanhu.io/homedrv/drv/homeapp"
+	"shanhu.io/homedrv/drv/homeapp/nextcloud"
+)
+
+// DDNSConfig is the config of the dynamic DNS updater, which keeps the
+// address records of the drive's domains pointing to its public IP.
+// Exactly one provider needs to be set.
+type DDNSConfig struct {
+	// EchoURL returns the public IP address of the caller in plain text.
+	// Default is "https://api64.ipify.org". It is fetched over IPv4 and
+	// IPv6 separately.
+	EchoURL string `json:",omitempty"`
+
+	// IPv6 also maintains the AAAA records.
+	IPv6 bool `json:",omitempty"`
+
+	// Domains are the domains to update. When empty, the main domain,
+	// nextcloud domains, app domains and custom subs are updated.
+	Domains []string `json:",omitempty"`
+
+	// TTL is the TTL of the records in seconds. Default is 300.
+	TTL uint32 `json:",omitempty"`
+
+	RFC2136    *dnsupdate.Config     `json:",omitempty"`
+	Cloudflare *DDNSCloudflareConfig `json:",omitempty"`
+	Webhook    *DDNSWebhookConfig    `json:",omitempty"`
+}
+
+func (c *DDNSConfig) provider() (ddnsProvider, error) {
+	var ps []ddnsProvider
+	if c.RFC2136 != nil {
+		p, err := newRFC2136DDNS(c.RFC2136)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	if c.Cloudflare != nil {
+		p, err := newCloudflareDDNS(c.Cloudflare)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	if c.Webhook != nil {
+		p, err := newWebhookDDNS(c.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	if len(ps) != 1 {
+		return nil, errcode.InvalidArgf(
+			"need exactly one ddns provider, got %d", len(ps),
+		)
+	}
+	return ps[0], nil
+}
+
+func (c *DDNSConfig) enabled() bool {
+	return c.RFC2136 != nil || c.Cloudflare != nil || c.Webhook != nil
+}
+
+func (c *DDNSConfig) ttl() uint32 {
+	if c.TTL == 0 {
+		return 300
+	}
+	return c.TTL
+}
+
+// DDNSStatus is the status of the dynamic DNS updater.
+type DDNSStatus struct {
+	IPv4    string   `json:",omitempty"`
+	IPv6    string   `json:",omitempty"`
+	Domains []string `json:",omitempty"`
+
+	Updated   time.Time // Last successful update.
+	Checked   time.Time // Last address check.
+	LastError string    `json:",omitempty"`
+
+	// IPv4Error and IPv6Error are the errors of fetching the public
+	// addresses. When one fails, the records of the other type are still
+	// updated.
+	IPv4Error string `json:",omitempty"`
+	IPv6Error string `json:",omitempty"`
+}
+
+func loadDDNSConfig(s settings.Settings) (*DDNSConfig, error) {
+	c := new(DDNSConfig)
+	if err := s.Get(keyDDNSConfig, c); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func loadDDNSStatus(s settings.Settings) (*DDNSStatus, error) {
+	status := new(DDNSStatus)
+	if err := s.Get(keyDDNSStatus, status); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return status, nil
+}
+
+type ddnsLogs struct {
+	t *pisces.KV
+}
+
+func newDDNSLogs(b *pisces.Tables) *ddnsLogs {
+	return &ddnsLogs{t: b.NewOrderedKV("ddns_logs")}
+}
+
+type ddnsUpdateEvent struct {
+	Type   string
+	IP     string
+	Domain string
+	Error  string `json:",omitempty"`
+}
+
+func (b *ddnsLogs) record(ev *ddnsUpdateEvent) error {
+	msg := fmt.Sprintf("%s record of %q set to %s", ev.Type, ev.Domain, ev.IP)
+	if ev.Error != "" {
+		msg = fmt.Sprintf(
+			"failed to set %s record of %q to %s: %s",
+			ev.Type, ev.Domain, ev.IP, ev.Error,
+		)
+	}
+	entry := newLogEntry("", msg)
+	if err := entry.setJSONValue(logTypeDDNSUpdate, ev); err != nil {
+		return errcode.Annotate(err, "set log value")
+	}
+	return b.t.Add(entry.K, entry)
+}
+
+func (b *ddnsLogs) list(n int) ([]*LogEntry, error) {
+	partial := &pisces.KVPartial{N: uint64(n), Desc: true}
+	var entries []*LogEntry
+	it := &pisces.Iter{
+		Make: func() interface{} { return new(LogEntry) },
+		Do: func(_ string, v interface{}) error {
+			entries = append(entries, v.(*LogEntry))
+			return nil
+		},
+	}
+	if err := b.t.WalkPartial(partial, it); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// fetchPublicIP fetches the public IP address with the echo URL over the
+// given network, "tcp4" or "tcp6".
+func fetchPublicIP(ctx context.Context, echoURL, network string) (
+	string, error,
+) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (
+				net.Conn, error,
+			) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+		Timeout: 30 * time.Second,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, echoURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errcode.Internalf("echo got status %s", resp.Status)
+	}
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", errcode.Annotate(err, "read echo response")
+	}
+
+	s := strings.TrimSpace(string(bs))
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", errcode.Internalf("invalid ip address %q", s)
+	}
+	if isV4 := ip.To4() != nil; isV4 != (network == "tcp4") {
+		return "", errcode.Internalf("got %q over %s", s, network)
+	}
+	return ip.String(), nil
+}
+
+// ddnsDomains lists the domains served by the drive.
+func ddnsDomains(d *drive) ([]string, error) {
+	set := make(map[string]bool)
+
+	main, err := settings.String(d.settings, homeapp.KeyMainDomain)
+	if err != nil && !errcode.IsNotFound(err) {
+		return nil, errcode.Annotate(err, "read main domain")
+	}
+	if main != "" {
+		set[main] = true
+	}
+
+	var ncDomains []string
+	if err := d.settings.Get(nextcloud.KeyDomains, &ncDomains); err != nil {
+		if !errcode.IsNotFound(err) {
+			return nil, errcode.Annotate(err, "read nextcloud domains")
+		}
+	}
+	for _, domain := range ncDomains {
+		set[domain] = true
+	}
+
+	apps, err := d.appDomains.list()
+	if err != nil {
+		return nil, errcode.Annotate(err, "list app domains")
+	}
+	for _, app := range apps {
+		for domain := range app.Map {
+			set[domain] = true
+		}
+	}
+
+	subs, err := loadCustomSubs(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read custom subs")
+	}
+	for key := range subs {
+		domain, _ := splitCustomSub(key)
+		set[domain] = true
+	}
+
+	var domains []string
+	for domain := range set {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+const defaultDDNSEchoURL = "https://api64.ipify.org"
+
+// updateDDNS checks the public address, and updates the records when the
+// address or the domains change.
+func updateDDNS(ctx context.Context, d *drive, config *DDNSConfig) error {
+	p, err := config.provider()
+	if err != nil {
+		return err
+	}
+
+	old, err := loadDDNSStatus(d.settings)
+	if err != nil {
+		return errcode.Annotate(err, "read ddns status")
+	}
+	if old == nil {
+		old = new(DDNSStatus)
+	}
+
+	domains := config.Domains
+	if len(domains) == 0 {
+		domains, err = ddnsDomains(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	echo := config.EchoURL
+	if echo == "" {
+		echo = defaultDDNSEchoURL
+	}
+	status := &DDNSStatus{
+		Domains: domains,
+		Updated: old.Updated,
+		Checked: time.Now(),
+	}
+	fail := func(err error) error {
+		status.LastError = err.Error()
+		if err := d.settings.Set(keyDDNSStatus, status); err != nil {
+			log.Println("save ddns status: ", err)
+		}
+		return err
+	}
+
+	// Failing to fetch one address should not block updating the records
+	// of the other, as the network might only have IPv4 or IPv6. The
+	// records of the failed type are left as they are.
+	ipv4, ipv4Err := fetchPublicIP(ctx, echo, "tcp4")
+	if ipv4Err != nil {
+		ipv4Err = errcode.Annotate(ipv4Err, "fetch public ipv4")
+		status.IPv4 = old.IPv4
+		status.IPv4Error = ipv4Err.Error()
+	} else {
+		status.IPv4 = ipv4
+	}
+	var ipv6Err error
+	if config.IPv6 {
+		ipv6, err := fetchPublicIP(ctx, echo, "tcp6")
+		if err != nil {
+			ipv6Err = errcode.Annotate(err, "fetch public ipv6")
+			status.IPv6 = old.IPv6
+			status.IPv6Error = ipv6Err.Error()
+		} else {
+			status.IPv6 = ipv6
+		}
+	}
+	if ipv4Err != nil && (!config.IPv6 || ipv6Err != nil) {
+		return fail(ipv4Err) // No address fetched.
+	}
+
+	changed := status.IPv4 != old.IPv4 ||
+		status.IPv6 != old.IPv6 ||
+		!reflect.DeepEqual(status.Domains, old.Domains) ||
+		old.LastError != ""
+	if changed {
+		records := make(map[string]string)
+		if ipv4Err == nil {
+			records["A"] = status.IPv4
+		}
+		if config.IPv6 && ipv6Err == nil {
+			records["AAAA"] = status.IPv6
+		}
+
+		var lastErr error
+		for _, domain := range domains {
+			for _, typ := range []string{"A", "AAAA"} {
+				ip, ok := records[typ]
+				if !ok {
+					continue
+				}
+				ev := &ddnsUpdateEvent{Type: typ, IP: ip, Domain: domain}
+				if err := p.update(
+					ctx, domain, typ, ip, config.ttl(),
+				); err != nil {
+					lastErr = err
+					ev.Error = err.Error()
+				}
+				if err := d.ddnsLogs.record(ev); err != nil {
+					log.Println("record ddns update: ", err)
+				}
+			}
+		}
+		if lastErr != nil {
+			return fail(errcode.Annotate(lastErr, "update records"))
+		}
+		status.Updated = status.Checked
+	}
+
+	if err := d.settings.Set(keyDDNSStatus, status); err != nil {
+		return errcode.Annotate(err, "save ddns status")
+	}
+	if ipv4Err != nil {
+		return ipv4Err
+	}
+	return ipv6Err
+}
+
+// DDNSInfo is the status and recent history of the dynamic DNS updater.
+type DDNSInfo struct {
+	Status  *DDNSStatus `json:",omitempty"`
+	History []*LogEntry `json:",omitempty"`
+}
+
+func cronDDNS(d *drive) {
+	const period = 5 * time.Minute
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		config, err := loadDDNSConfig(d.settings)
+		if err != nil {
+			log.Println("read ddns config: ", err)
+		} else if config != nil && config.enabled() {
+			ctx, cancel := context.WithTimeout(context.Background(), period)
+			if err := updateDDNS(ctx, d, config); err != nil {
+				log.Println("update ddns: ", err)
+			}
+			cancel()
+		}
+		<-ticker.C
+	}
+}
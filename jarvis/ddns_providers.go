@@ -0,0 +1,287 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/homedrv/drv/dnsupdate"
+)
+
+// ddnsProvider publishes address records of domains.
+type ddnsProvider interface {
+	// update sets the record of the given type ("A" or "AAAA") of the
+	// domain to the IP address, replacing existing records.
+	update(ctx context.Context, domain, typ, ip string, ttl uint32) error
+}
+
+type rfc2136DDNS struct {
+	client *dnsupdate.Client
+}
+
+func newRFC2136DDNS(config *dnsupdate.Config) (*rfc2136DDNS, error) {
+	client, err := dnsupdate.NewClient(config)
+	if err != nil {
+		return nil, errcode.Annotate(err, "make rfc2136 client")
+	}
+	return &rfc2136DDNS{client: client}, nil
+}
+
+func (p *rfc2136DDNS) update(
+	ctx context.Context, domain, typ, ip string, ttl uint32,
+) error {
+	return p.client.Update(ctx, &dnsupdate.Update{
+		Delete: []*dnsupdate.Record{{Name: domain, Type: typ}},
+		Add: []*dnsupdate.Record{{
+			Name:  domain,
+			Type:  typ,
+			TTL:   ttl,
+			Value: ip,
+		}},
+	})
+}
+
+// DDNSCloudflareConfig is the config of a Cloudflare style REST API for
+// managing DNS records.
+type DDNSCloudflareConfig struct {
+	// Token is the API token, which needs the permission to edit DNS
+	// records of the zone.
+	Token string
+
+	// ZoneID is the ID of the zone.
+	ZoneID string
+
+	// BaseURL is the base URL of the API. Default is
+	// "https://api.cloudflare.com/client/v4".
+	BaseURL string `json:",omitempty"`
+}
+
+type cloudflareDDNS struct {
+	token  string
+	zone   string
+	base   string
+	client *http.Client
+}
+
+func newCloudflareDDNS(config *DDNSCloudflareConfig) (
+	*cloudflareDDNS, error,
+) {
+	if config.Token == "" {
+		return nil, errcode.InvalidArgf("cloudflare api token missing")
+	}
+	if config.ZoneID == "" {
+		return nil, errcode.InvalidArgf("cloudflare zone id missing")
+	}
+	base := config.BaseURL
+	if base == "" {
+		base = "https://api.cloudflare.com/client/v4"
+	}
+	return &cloudflareDDNS{
+		token:  config.Token,
+		zone:   config.ZoneID,
+		base:   base,
+		client: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     uint32 `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+type cloudflareError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type cloudflareResponse struct {
+	Success bool               `json:"success"`
+	Errors  []*cloudflareError `json:"errors"`
+	Result  json.RawMessage    `json:"result"`
+}
+
+func (p *cloudflareDDNS) call(
+	ctx context.Context, method, p2 string, req, resp interface{},
+) error {
+	var body io.Reader
+	if req != nil {
+		bs, err := json.Marshal(req)
+		if err != nil {
+			return errcode.Annotate(err, "marshal request")
+		}
+		body = bytes.NewReader(bs)
+	}
+	u := p.base + "/zones/" + url.PathEscape(p.zone) + p2
+	httpReq, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return errcode.Annotate(err, "make request")
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.token)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	r := new(cloudflareResponse)
+	if err := json.NewDecoder(httpResp.Body).Decode(r); err != nil {
+		return errcode.Annotatef(
+			err, "decode response, status %s", httpResp.Status,
+		)
+	}
+	if !r.Success {
+		if len(r.Errors) > 0 {
+			return errcode.Internalf(
+				"cloudflare error %d: %s",
+				r.Errors[0].Code, r.Errors[0].Message,
+			)
+		}
+		return errcode.Internalf("cloudflare call failed")
+	}
+	if resp != nil {
+		if err := json.Unmarshal(r.Result, resp); err != nil {
+			return errcode.Annotate(err, "decode result")
+		}
+	}
+	return nil
+}
+
+func (p *cloudflareDDNS) update(
+	ctx context.Context, domain, typ, ip string, ttl uint32,
+) error {
+	q := make(url.Values)
+	q.Set("type", typ)
+	q.Set("name", domain)
+	var records []*cloudflareRecord
+	if err := p.call(
+		ctx, http.MethodGet, "/dns_records?"+q.Encode(), nil, &records,
+	); err != nil {
+		return errcode.Annotate(err, "list records")
+	}
+
+	r := &cloudflareRecord{
+		Type:    typ,
+		Name:    domain,
+		Content: ip,
+		TTL:     ttl,
+	}
+	if len(records) == 0 {
+		if err := p.call(
+			ctx, http.MethodPost, "/dns_records", r, nil,
+		); err != nil {
+			return errcode.Annotate(err, "create record")
+		}
+		return nil
+	}
+
+	// Keep the first record and remove the rest.
+	first := records[0]
+	r.Proxied = first.Proxied
+	p2 := "/dns_records/" + url.PathEscape(first.ID)
+	if err := p.call(ctx, http.MethodPut, p2, r, nil); err != nil {
+		return errcode.Annotate(err, "update record")
+	}
+	for _, extra := range records[1:] {
+		p2 := "/dns_records/" + url.PathEscape(extra.ID)
+		if err := p.call(ctx, http.MethodDelete, p2, nil, nil); err != nil {
+			return errcode.Annotate(err, "delete extra record")
+		}
+	}
+	return nil
+}
+
+// DDNSWebhookConfig is the config of a generic HTTP webhook for updating
+// address records. Jarvis posts a DDNSWebhookRequest to the URL.
+type DDNSWebhookConfig struct {
+	URL string
+
+	// Token is sent as a bearer token when not empty.
+	Token string `json:",omitempty"`
+}
+
+// DDNSWebhookRequest is the request that jarvis sends to a DDNS webhook.
+type DDNSWebhookRequest struct {
+	Domain string
+	Type   string // "A" or "AAAA"
+	IP     string
+	TTL    uint32
+}
+
+type webhookDDNS struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newWebhookDDNS(config *DDNSWebhookConfig) (*webhookDDNS, error) {
+	if config.URL == "" {
+		return nil, errcode.InvalidArgf("webhook url missing")
+	}
+	return &webhookDDNS{
+		url:    config.URL,
+		token:  config.Token,
+		client: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func (p *webhookDDNS) update(
+	ctx context.Context, domain, typ, ip string, ttl uint32,
+) error {
+	bs, err := json.Marshal(&DDNSWebhookRequest{
+		Domain: domain,
+		Type:   typ,
+		IP:     ip,
+		TTL:    ttl,
+	})
+	if err != nil {
+		return errcode.Annotate(err, "marshal request")
+	}
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, p.url, bytes.NewReader(bs),
+	)
+	if err != nil {
+		return errcode.Annotate(err, "make request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errcode.Internalf("webhook got status %s", resp.Status)
+	}
+	return nil
+}
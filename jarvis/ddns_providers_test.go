@@ -0,0 +1,120 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeCloudflare is a fake Cloudflare DNS records API for one zone.
+type fakeCloudflare struct {
+	t       *testing.T
+	records map[string]*cloudflareRecord
+	nextID  int
+}
+
+func (f *fakeCloudflare) reply(w http.ResponseWriter, result interface{}) {
+	bs, err := json.Marshal(result)
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	json.NewEncoder(w).Encode(&cloudflareResponse{
+		Success: true,
+		Result:  bs,
+	})
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if got := req.Header.Get("Authorization"); got != "Bearer tok" {
+		f.t.Errorf("got auth %q", got)
+	}
+	const prefix = "/zones/z1/dns_records"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(w, req)
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, prefix), "/")
+
+	switch req.Method {
+	case http.MethodGet:
+		q := req.URL.Query()
+		list := []*cloudflareRecord{}
+		for _, r := range f.records {
+			if r.Type == q.Get("type") && r.Name == q.Get("name") {
+				list = append(list, r)
+			}
+		}
+		f.reply(w, list)
+	case http.MethodPost, http.MethodPut:
+		r := new(cloudflareRecord)
+		if err := json.NewDecoder(req.Body).Decode(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if id == "" {
+			f.nextID++
+			id = strconv.Itoa(f.nextID)
+		}
+		r.ID = id
+		f.records[id] = r
+		f.reply(w, r)
+	default:
+		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestCloudflareDDNS(t *testing.T) {
+	fake := &fakeCloudflare{
+		t:       t,
+		records: make(map[string]*cloudflareRecord),
+	}
+	s := httptest.NewServer(fake)
+	defer s.Close()
+
+	p, err := newCloudflareDDNS(&DDNSCloudflareConfig{
+		Token:   "tok",
+		ZoneID:  "z1",
+		BaseURL: s.URL,
+	})
+	if err != nil {
+		t.Fatal("new provider: ", err)
+	}
+
+	ctx := context.Background()
+	const domain = "www.example.com"
+	if err := p.update(ctx, domain, "A", "1.2.3.4", 300); err != nil {
+		t.Fatal("create: ", err)
+	}
+	if err := p.update(ctx, domain, "A", "5.6.7.8", 300); err != nil {
+		t.Fatal("update: ", err)
+	}
+
+	if len(fake.records) != 1 {
+		t.Fatalf("got %d records, want 1", len(fake.records))
+	}
+	for _, r := range fake.records {
+		if r.Name != domain || r.Type != "A" || r.Content != "5.6.7.8" {
+			t.Errorf("got record %+v", r)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/settings"
+	"shanhu.io/homedrv/drv/homedial"
+)
+
+func loadServerDialConfig(s settings.Settings) (*homedial.Config, error) {
+	c := new(homedial.Config)
+	if err := s.Get(keyDialConfig, c); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// mergeDialConfig merges the local dial config on top of the one
+// refreshed from DialConfigURL. Local settings always win. The outbound
+// proxy is a property of the local network, so it only comes from the
+// local config.
+func mergeDialConfig(server, local *homedial.Config) *homedial.Config {
+	if server == nil {
+		return local
+	}
+	if local == nil {
+		local = new(homedial.Config)
+	}
+
+	c := &homedial.Config{
+		DoH:        server.DoH,
+		DNSServer:  server.DNSServer,
+		PreferIPv6: server.PreferIPv6 || local.PreferIPv6,
+		Proxy:      local.Proxy,
+	}
+	if local.DoH != "" || local.DNSServer != "" {
+		c.DoH = local.DoH
+		c.DNSServer = local.DNSServer
+	}
+	c.Hosts = mergeHosts(server.Hosts, local.Hosts)
+	c.Hosts6 = mergeHosts(server.Hosts6, local.Hosts6)
+	return c
+}
+
+func mergeHosts(server, local map[string]string) map[string]string {
+	if len(server)+len(local) == 0 {
+		return nil
+	}
+	m := make(map[string]string)
+	for host, ip := range server {
+		m[host] = ip
+	}
+	for host, ip := range local {
+		m[host] = ip
+	}
+	return m
+}
+
+func (d *drive) dialConfig() (*homedial.Config, error) {
+	server, err := loadServerDialConfig(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read server dial config")
+	}
+	return mergeDialConfig(server, d.config.Dial), nil
+}
+
+func applyDialConfig(d *drive) error {
+	c, err := d.dialConfig()
+	if err != nil {
+		return err
+	}
+	return homedial.SetConfig(c)
+}
+
+// fetchDialConfig fetches the dial config from the URL.
+func fetchDialConfig(ctx context.Context, u string) (
+	*homedial.Config, error,
+) {
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: homedial.Dial},
+		Timeout:   time.Minute,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errcode.Internalf("got status %s", resp.Status)
+	}
+
+	const maxSize = 1 << 20
+	c := new(homedial.Config)
+	dec := json.NewDecoder(io.LimitReader(resp.Body, maxSize))
+	if err := dec.Decode(c); err != nil {
+		return nil, errcode.Annotate(err, "decode")
+	}
+	return c, nil
+}
+
+// refreshDialConfig fetches the dial config from the configured URL. It
+// returns true if the config has changed.
+func refreshDialConfig(ctx context.Context, d *drive) (bool, error) {
+	c, err := fetchDialConfig(ctx, d.config.DialConfigURL)
+	if err != nil {
+		return false, errcode.Annotate(err, "fetch dial config")
+	}
+	// Apply before saving, so that a bad config never gets saved.
+	merged := mergeDialConfig(c, d.config.Dial)
+	if err := homedial.SetConfig(merged); err != nil {
+		return false, errcode.Annotate(err, "apply dial config")
+	}
+
+	old, err := loadServerDialConfig(d.settings)
+	if err != nil {
+		return false, errcode.Annotate(err, "read server dial config")
+	}
+	if old != nil && reflect.DeepEqual(old, c) {
+		return false, nil
+	}
+	if err := d.settings.Set(keyDialConfig, c); err != nil {
+		return false, errcode.Annotate(err, "save dial config")
+	}
+	return true, nil
+}
+
+func cronDialConfig(d *drive) {
+	if d.config.DialConfigURL == "" {
+		return
+	}
+
+	const period = time.Hour
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), period)
+		changed, err := refreshDialConfig(ctx, d)
+		cancel()
+		if err != nil {
+			log.Printf("refresh dial config: %s", err)
+		} else if changed {
+			// Doorway reads the dial config on start.
+			t := &taskRecreateDoorway{drive: d}
+			if err := d.tasks.run("recreate doorway", t); err != nil {
+				log.Printf("recreate doorway for dial config: %s", err)
+			}
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"log"
+	"path"
+	"strings"
+	"unicode"
+
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/settings"
+	"shanhu.io/homedrv/drv/homeapp/nextcloud"
+	"shanhu.io/homedrv/drv/hostos"
+)
+
+// nextcloudDataPath returns the host path that holds the data of
+// nextcloud. When no data mount is set, the data is in a docker volume,
+// under the docker data root.
+func nextcloudDataPath(s settings.Settings) (string, error) {
+	m, err := settings.String(s, nextcloud.KeyDataMount)
+	if err != nil {
+		if errcode.IsNotFound(err) {
+			return hostos.DockerRoot, nil
+		}
+		return "", err
+	}
+	if m == "" {
+		return hostos.DockerRoot, nil
+	}
+	return m, nil
+}
+
+// diskMountRoots are the directories where data disks can be mounted. On
+// BurmillaOS, the console shares these with the host and the user docker.
+var diskMountRoots = []string{"/mnt", "/media"}
+
+func checkDiskMountpoint(p string) error {
+	if !path.IsAbs(p) {
+		return errcode.InvalidArgf("mountpoint %q is not absolute", p)
+	}
+	if strings.IndexFunc(p, unicode.IsSpace) >= 0 {
+		return errcode.InvalidArgf("mountpoint %q has white spaces", p)
+	}
+	p = path.Clean(p)
+	for _, root := range diskMountRoots {
+		if strings.HasPrefix(p, root+"/") {
+			return nil
+		}
+	}
+	return errcode.InvalidArgf(
+		"mountpoint %q is not under %s",
+		p, strings.Join(diskMountRoots, " or "),
+	)
+}
+
+// loadDiskMounts loads the data disk mounts that were added by jarvis.
+func loadDiskMounts(s settings.Settings) ([]*hostos.Mount, error) {
+	var mounts []*hostos.Mount
+	if err := s.Get(keyDiskMounts, &mounts); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return mounts, nil
+}
+
+func saveDiskMount(s settings.Settings, m *hostos.Mount) error {
+	mounts, err := loadDiskMounts(s)
+	if err != nil {
+		return errcode.Annotate(err, "load disk mounts")
+	}
+	var newMounts []*hostos.Mount
+	for _, old := range mounts {
+		if old.Mountpoint != m.Mountpoint {
+			newMounts = append(newMounts, old)
+		}
+	}
+	newMounts = append(newMounts, m)
+	return s.Set(keyDiskMounts, newMounts)
+}
+
+// pathMounts returns the mounts that hold the host path, from both the
+// data disk mounts added by jarvis and the mounts in the host OS config.
+// Mounts of the root directory are skipped.
+func pathMounts(d *drive, host hostos.HostOS, p string) (
+	[]*hostos.Mount, error,
+) {
+	mounts, err := loadDiskMounts(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "load disk mounts")
+	}
+	osMounts, err := host.Mounts()
+	if err != nil {
+		// Still checks the mounts added by jarvis.
+		log.Printf("load os mounts: %s", err)
+	}
+
+	var found []*hostos.Mount
+	seen := make(map[string]bool)
+	for _, m := range append(mounts, osMounts...) {
+		mp := path.Clean(m.Mountpoint)
+		if mp == "/" || seen[mp] {
+			continue
+		}
+		if p == mp || strings.HasPrefix(p, mp+"/") {
+			seen[mp] = true
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
+// CheckHostPath implements homeapp.Core. A host path is refused when a
+// configured mount that holds it is not mounted, so that apps do not
+// write into the empty mountpoint on the system disk. Paths on the system
+// disk are only warned about, as they might be intended.
+func (d *drive) CheckHostPath(p string) error {
+	if !d.hasHostOS() {
+		return nil
+	}
+	host, err := d.hostOS()
+	if err != nil {
+		return errcode.Annotate(err, "init host OS stub")
+	}
+
+	p = path.Clean(p)
+	mounts, err := pathMounts(d, host, p)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		mounted, err := host.Mounted(m.Mountpoint)
+		if err != nil {
+			return errcode.Annotatef(err, "check mount %q", m.Mountpoint)
+		}
+		if !mounted {
+			return errcode.Internalf(
+				"disk %q is not mounted at %q", m.Device, m.Mountpoint,
+			)
+		}
+	}
+	if len(mounts) == 0 {
+		warnSystemDiskPath(host, p)
+	}
+	return nil
+}
+
+// warnSystemDiskPath logs a warning when a host path that is not under a
+// configured mount is on the root or the docker data filesystem.
+func warnSystemDiskPath(host hostos.HostOS, p string) {
+	inv, err := host.DiskInventory()
+	if err != nil {
+		log.Printf("get disk inventory for %q: %s", p, err)
+		return
+	}
+	fs := inv.PathFilesystem(p)
+	if fs == nil {
+		return
+	}
+	if fs.Mountpoint == "/" {
+		log.Printf("warning: %q is on the root filesystem", p)
+		return
+	}
+	if docker := inv.PathFilesystem(hostos.DockerRoot); docker != nil &&
+		docker.Mountpoint == fs.Mountpoint {
+		log.Printf("warning: %q is on the docker data filesystem", p)
+	}
+}
+
+// FormatDiskRequest is the request to format a data disk.
+type FormatDiskRequest struct {
+	Device string // Like "/dev/sdb".
+	Label  string // Optional filesystem label, at most 16 bytes.
+
+	// Confirm must repeat Device, as all data on the device is lost.
+	Confirm string
+}
+
+func formatDisk(d *drive, req *FormatDiskRequest) error {
+	if req.Device == "" {
+		return errcode.InvalidArgf("device is empty")
+	}
+	if req.Confirm != req.Device {
+		return errcode.InvalidArgf("confirm does not match the device")
+	}
+	if len(req.Label) > 16 {
+		return errcode.InvalidArgf("label %q is too long", req.Label)
+	}
+	host, err := d.hostOS()
+	if err != nil {
+		return errcode.Annotate(err, "init host OS stub")
+	}
+	return host.FormatDisk(req.Device, req.Label)
+}
+
+func (s *adminTasks) apiFormatDisk(c *aries.C, req *FormatDiskRequest) error {
+	return formatDisk(s.server.drive, req)
+}
+
+// MountDiskRequest is the request to mount a data disk.
+type MountDiskRequest struct {
+	Device     string // Like "/dev/sdb".
+	Mountpoint string // Under /mnt or /media.
+}
+
+func mountDisk(d *drive, req *MountDiskRequest) error {
+	if err := checkDiskMountpoint(req.Mountpoint); err != nil {
+		return err
+	}
+	host, err := d.hostOS()
+	if err != nil {
+		return errcode.Annotate(err, "init host OS stub")
+	}
+	inv, err := host.DiskInventory()
+	if err != nil {
+		return errcode.Annotate(err, "get disk inventory")
+	}
+	dev := hostos.FindDevice(inv.Devices, req.Device)
+	if dev == nil {
+		return errcode.NotFoundf("device %q not found", req.Device)
+	}
+	if dev.FSType == "" || dev.UUID == "" {
+		return errcode.InvalidArgf("device %q is not formatted", req.Device)
+	}
+
+	// Mounts by UUID, as the device name might change after reboots.
+	m := &hostos.Mount{
+		Device:     "/dev/disk/by-uuid/" + dev.UUID,
+		Mountpoint: path.Clean(req.Mountpoint),
+		FSType:     dev.FSType,
+	}
+	if err := host.AddMount(m); err != nil {
+		return errcode.Annotate(err, "add mount")
+	}
+	if err := saveDiskMount(d.settings, m); err != nil {
+		return errcode.Annotate(err, "save disk mount")
+	}
+	return nil
+}
+
+func (s *adminTasks) apiMountDisk(c *aries.C, req *MountDiskRequest) error {
+	return mountDisk(s.server.drive, req)
+}
+
+func (s *adminTasks) apiListUnusedDisks(c *aries.C) (
+	[]*hostos.BlockDevice, error,
+) {
+	host, err := s.server.drive.hostOS()
+	if err != nil {
+		return nil, errcode.Annotate(err, "init host OS stub")
+	}
+	inv, err := host.DiskInventory()
+	if err != nil {
+		return nil, errcode.Annotate(err, "get disk inventory")
+	}
+	return inv.Unused(), nil
+}
+
+// disksAPI is the API for the disks dashboard page. Formatting and
+// mounting disks require a sudo session.
+func disksAPI(s *server) *aries.Router {
+	r := aries.NewRouter()
+	r.Call("format", func(c *aries.C, req *FormatDiskRequest) error {
+		if err := s.sudoSessions.Check(c); err != nil {
+			return errcode.Annotate(err, "check sudo session")
+		}
+		return formatDisk(s.drive, req)
+	})
+	r.Call("mount", func(c *aries.C, req *MountDiskRequest) error {
+		if err := s.sudoSessions.Check(c); err != nil {
+			return errcode.Annotate(err, "check sudo session")
+		}
+		return mountDisk(s.drive, req)
+	})
+	return r
+}
+
+// DashboardDisksData contains the data for the disks dashboard page.
+type DashboardDisksData struct {
+	Disabled    bool
+	Devices     []*hostos.BlockDevice `json:",omitempty"`
+	Filesystems []*hostos.Filesystem  `json:",omitempty"`
+
+	// Unused are the disks and partitions that can be formatted or
+	// mounted.
+	Unused []*hostos.BlockDevice `json:",omitempty"`
+
+	// Mounts are the data disk mounts that were added by jarvis.
+	Mounts []*hostos.Mount `json:",omitempty"`
+
+	// DockerRoot is the filesystem that holds the docker data root.
+	DockerRoot *hostos.Filesystem `json:",omitempty"`
+
+	// NextcloudData is the filesystem that holds the nextcloud data, at
+	// NextcloudDataPath.
+	NextcloudData     *hostos.Filesystem `json:",omitempty"`
+	NextcloudDataPath string             `json:",omitempty"`
+}
+
+func newDashboardDisksData(s *server, _ *aries.C) (
+	*DashboardDisksData, error,
+) {
+	if !s.drive.hasHostOS() {
+		return &DashboardDisksData{Disabled: true}, nil
+	}
+	host, err := s.drive.hostOS()
+	if err != nil {
+		return nil, errcode.Annotate(err, "init host OS stub")
+	}
+	inv, err := host.DiskInventory()
+	if err != nil {
+		return nil, errcode.Annotate(err, "get disk inventory")
+	}
+	ncPath, err := nextcloudDataPath(s.drive.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read nextcloud data mount")
+	}
+	mounts, err := loadDiskMounts(s.drive.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "load disk mounts")
+	}
+	return &DashboardDisksData{
+		Devices:           inv.Devices,
+		Filesystems:       inv.Filesystems,
+		Unused:            inv.Unused(),
+		Mounts:            mounts,
+		DockerRoot:        inv.PathFilesystem(hostos.DockerRoot),
+		NextcloudData:     inv.PathFilesystem(ncPath),
+		NextcloudDataPath: ncPath,
+	}, nil
+}
@@ -22,6 +22,7 @@ import (
 	"shanhu.io/g/dock"
 	"shanhu.io/g/errcode"
 	"shanhu.io/g/rsautil"
+	"shanhu.io/g/settings"
 	"shanhu.io/g/tarutil"
 	doorwaypkg "shanhu.io/homedrv/drv/doorway"
 	"shanhu.io/homedrv/drv/drvapi"
@@ -88,15 +89,60 @@ func (d *doorway) coreAddr() string {
 	return d.core() + ":3377"
 }
 
-func (d *doorway) hostMap() (map[string]string, error) {
-	m := make(map[string]string)
+func (d *doorway) forwardAuthURL() string {
+	return "http://" + d.coreAddr() + "/forward-auth"
+}
+
+func hostMapEntry(
+	m map[string]*doorwaypkg.HostMapEntry, domain string,
+) *doorwaypkg.HostMapEntry {
+	entry, ok := m[domain]
+	if !ok {
+		entry = new(doorwaypkg.HostMapEntry)
+		m[domain] = entry
+	}
+	return entry
+}
+
+func (d *doorway) hostMap() (map[string]*doorwaypkg.HostMapEntry, error) {
+	m := make(map[string]*doorwaypkg.HostMapEntry)
 
 	subs, err := loadCustomSubs(d.settings)
 	if err != nil {
 		return nil, errcode.Annotate(err, "check custom subs")
 	}
-	for sub, dest := range subs {
-		m[sub] = dest
+	var subKeys []string
+	for sub := range subs {
+		subKeys = append(subKeys, sub)
+	}
+	sort.Strings(subKeys)
+	forwardAuth := d.forwardAuthURL()
+	for _, key := range subKeys {
+		sub := subs[key]
+		if sub.Auth == customSubAuthJarvis &&
+			!jarvisAuthWorks(key, d.config.domain) {
+			// Would redirect to sign in forever; keep it closed.
+			log.Printf("custom sub %q: jarvis auth does not work", key)
+			continue
+		}
+		domain, prefix := splitCustomSub(key)
+		entry := hostMapEntry(m, domain)
+		auth := sub.doorwayAuth(forwardAuth)
+		if prefix == "" {
+			entry.To = sub.Dest
+			entry.Auth = auth
+			entry.IPAllow = sub.IPAllow
+			entry.IPDeny = sub.IPDeny
+			continue
+		}
+		entry.Routes = append(entry.Routes, &doorwaypkg.HostRoute{
+			Prefix:      prefix,
+			To:          sub.Dest,
+			StripPrefix: sub.StripPrefix,
+			Auth:        auth,
+			IPAllow:     sub.IPAllow,
+			IPDeny:      sub.IPDeny,
+		})
 	}
 
 	apps, err := d.appDomains.list()
@@ -110,26 +156,128 @@ func (d *doorway) hostMap() (map[string]string, error) {
 		}
 		sort.Strings(domains)
 		for _, domain := range domains {
-			m[domain] = app.Map[domain].Dest
+			hostMapEntry(m, domain).To = app.Map[domain].Dest
 		}
 	}
 
 	if !d.drive.config.External {
 		if main := d.config.domain; main != "" {
-			m[main] = d.coreAddr()
+			hostMapEntry(m, main).To = d.coreAddr()
+		}
+	}
+
+	filters, err := loadDomainIPFilters(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "load domain ip filters")
+	}
+	for domain, f := range filters {
+		if entry, ok := m[domain]; ok {
+			entry.IPAllow = f.Allow
+			entry.IPDeny = f.Deny
+		}
+	}
+
+	certHosts, err := loadClientCertHosts(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "load client cert hosts")
+	}
+	for _, host := range certHosts {
+		if entry, ok := m[host]; ok {
+			entry.ClientCert = true
 		}
 	}
 
 	return m, nil
 }
 
+// loadDoorwayDNS01 loads the DNS-01 config of doorway. It returns nil
+// when DNS-01 is not enabled.
+func loadDoorwayDNS01(s settings.Settings) (*doorwaypkg.DNS01Config, error) {
+	c := new(doorwaypkg.DNS01Config)
+	if err := s.Get(keyDoorwayDNS01, c); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if c.RFC2136 == nil && c.Webhook == nil {
+		return nil, nil
+	}
+	return c, nil
+}
+
+func loadDoorwayErrorPages(s settings.Settings) (map[string]string, error) {
+	pages := make(map[string]string)
+	if err := s.Get(keyDoorwayErrorPages, &pages); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return pages, nil
+}
+
+// loadFabricsServers loads the fabrics servers to choose from. It returns
+// nil when doorway should just use the single fabrics server.
+func loadFabricsServers(s settings.Settings) (
+	[]*doorwaypkg.FabricsServer, error,
+) {
+	var servers []*doorwaypkg.FabricsServer
+	if err := s.Get(keyFabricsServers, &servers); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return servers, nil
+}
+
+func loadDoorwayTrustedProxies(s settings.Settings) ([]string, error) {
+	var list []string
+	if err := s.Get(keyDoorwayTrustedProxies, &list); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return list, nil
+}
+
+// doorwayHostPorts returns the ports on the host that are bound to
+// doorway's HTTP and HTTPS ports. A port is 0 when it is not bound.
+func doorwayHostPorts(d *drive) (httpPort, httpsPort int) {
+	// TODO(h8liu): read from settings rather than drive config.
+	// makes the port binding configurable.
+	hostPort := func(port, def int) int {
+		if port == 0 && shouldBindPort0(d) {
+			return def
+		}
+		if port < 0 {
+			return 0
+		}
+		return port
+	}
+	return hostPort(d.config.HTTPPort, 80), hostPort(d.config.HTTPSPort, 443)
+}
+
 func (d *doorway) etcFiles() (*tarutil.Stream, error) {
 	s := tarutil.NewStream()
 
+	adminToken, err := doorwayAdminToken(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read admin token")
+	}
+	s.AddString("admin-token", d.tarMeta(0600), adminToken)
+
 	if !d.config.noFabrics {
+		servers, err := loadFabricsServers(d.settings)
+		if err != nil {
+			return nil, errcode.Annotate(err, "read fabrics servers")
+		}
 		fc := &doorwaypkg.FabricsConfig{
-			User: d.name,
-			Host: d.config.fabricsServer,
+			User:    d.name,
+			Host:    d.config.fabricsServer,
+			Servers: servers,
 		}
 		if err := addJSONXToTarStream(
 			s, "fabrics.jsonx", d.tarMeta(0600), fc,
@@ -137,6 +285,96 @@ func (d *doorway) etcFiles() (*tarutil.Stream, error) {
 			return nil, errcode.Annotate(err, "prepare fabrics config")
 		}
 	}
+	dns01, err := loadDoorwayDNS01(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read dns01 config")
+	}
+	if dns01 != nil {
+		if err := addJSONXToTarStream(
+			s, "dns01.jsonx", d.tarMeta(0600), dns01,
+		); err != nil {
+			return nil, errcode.Annotate(err, "prepare dns01 config")
+		}
+	}
+
+	trusted, err := loadDoorwayTrustedProxies(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read trusted proxies")
+	}
+	if len(trusted) > 0 {
+		if err := addJSONXToTarStream(
+			s, "trusted-proxies.jsonx", d.tarMeta(0600), trusted,
+		); err != nil {
+			return nil, errcode.Annotate(err, "prepare trusted proxies")
+		}
+	}
+
+	dial, err := d.drive.dialConfig()
+	if err != nil {
+		return nil, errcode.Annotate(err, "read dial config")
+	}
+	if dial != nil {
+		if err := addJSONXToTarStream(
+			s, "dial.jsonx", d.tarMeta(0600), dial,
+		); err != nil {
+			return nil, errcode.Annotate(err, "prepare dial config")
+		}
+	}
+
+	if !d.drive.config.External {
+		ips, err := lanIPs(d.drive)
+		if err != nil {
+			// Still serves <name>.local.
+			log.Println("get lan ips: ", err)
+		}
+		local, err := d.drive.localHTTPS.doorwayConfig(ips, d.coreAddr())
+		if err != nil {
+			return nil, errcode.Annotate(err, "make local https config")
+		}
+		if err := addJSONXToTarStream(
+			s, "local.jsonx", d.tarMeta(0600), local,
+		); err != nil {
+			return nil, errcode.Annotate(err, "prepare local https config")
+		}
+	}
+
+	clientAuth, err := d.drive.clientCerts.doorwayConfig()
+	if err != nil {
+		return nil, errcode.Annotate(err, "make client auth config")
+	}
+	if clientAuth != nil {
+		if err := addJSONXToTarStream(
+			s, "client-auth.jsonx", d.tarMeta(0600), clientAuth,
+		); err != nil {
+			return nil, errcode.Annotate(err, "prepare client auth config")
+		}
+	}
+
+	maintenance, err := d.drive.maintenance.hosts()
+	if err != nil {
+		return nil, errcode.Annotate(err, "read maintenance hosts")
+	}
+	if len(maintenance) > 0 {
+		if err := addJSONXToTarStream(
+			s, "maintenance.jsonx", d.tarMeta(0600), maintenance,
+		); err != nil {
+			return nil, errcode.Annotate(err, "prepare maintenance hosts")
+		}
+	}
+
+	pages, err := loadDoorwayErrorPages(d.settings)
+	if err != nil {
+		return nil, errcode.Annotate(err, "read error pages")
+	}
+	var pageNames []string
+	for name := range pages {
+		pageNames = append(pageNames, name)
+	}
+	sort.Strings(pageNames)
+	for _, name := range pageNames {
+		s.AddBytes(name+".html", d.tarMeta(0644), []byte(pages[name]))
+	}
+
 	m, err := d.hostMap()
 	if err != nil {
 		return nil, errcode.Annotate(err, "make host map")
@@ -147,6 +385,18 @@ func (d *doorway) etcFiles() (*tarutil.Stream, error) {
 	); err != nil {
 		return nil, errcode.Annotate(err, "prepare host map")
 	}
+
+	ports, err := portForwards(d.drive)
+	if err != nil {
+		return nil, errcode.Annotate(err, "make port forwards")
+	}
+	if len(ports) > 0 {
+		if err := addJSONXToTarStream(
+			s, "ports.jsonx", d.tarMeta(0600), ports,
+		); err != nil {
+			return nil, errcode.Annotate(err, "prepare port forwards")
+		}
+	}
 	return s, nil
 }
 
@@ -212,30 +462,25 @@ func (d *doorway) start(
 	}
 
 	var portBinds []*dock.PortBind
-	// TODO(h8liu): read from settings rather than drive config.
-	// makes the port binding configurable.
-	drvConfig := d.drive.config
-	if port := drvConfig.HTTPPort; port >= 0 {
-		if port == 0 && shouldBindPort0(d.drive) {
-			port = 80
-		}
-		if port > 0 {
-			portBinds = append(portBinds, &dock.PortBind{
-				HostPort: port, ContPort: 8080,
-			})
-		}
+	httpPort, httpsPort := doorwayHostPorts(d.drive)
+	if httpPort > 0 {
+		portBinds = append(portBinds, &dock.PortBind{
+			HostPort: httpPort, ContPort: 8080,
+		})
 	}
-	if port := drvConfig.HTTPSPort; port >= 0 {
-		if port == 0 && shouldBindPort0(d.drive) {
-			port = 443
-		}
-		if port > 0 {
-			portBinds = append(portBinds, &dock.PortBind{
-				HostPort: port, ContPort: 8443,
-			})
-		}
+	if httpsPort > 0 {
+		portBinds = append(portBinds, &dock.PortBind{
+			HostPort: httpsPort, ContPort: 8443,
+		})
 	}
 
+	ports, err := portForwards(d.drive)
+	if err != nil {
+		return errcode.Annotate(err, "make port forwards")
+	}
+	tcpBinds, udpBinds := portForwardBinds(ports)
+	portBinds = append(portBinds, tcpBinds...)
+
 	config := &dock.ContConfig{
 		Name:        d.cont(nameDoorway),
 		Network:     d.network(),
@@ -246,6 +491,7 @@ func (d *doorway) start(
 			Cont: doorwayVarDir,
 		}},
 		TCPBinds:      portBinds,
+		UDPBinds:      udpBinds,
 		Labels:        labels,
 		JSONLogConfig: dock.LimitedJSONLog(),
 	}
@@ -288,6 +534,10 @@ func (d *doorway) pingDomains() {
 
 	var domains []string
 	for d := range m {
+		// Wildcard domains cannot be pinged.
+		if doorwaypkg.IsWildcardDomain(d) {
+			continue
+		}
 		domains = append(domains, d)
 	}
 	pingDomains(domains)
@@ -48,6 +48,9 @@ type kernel struct {
 
 	// Objects store.
 	objects *objects
+
+	// History of dynamic DNS updates.
+	ddnsLogs *ddnsLogs
 }
 
 type drive struct {
@@ -72,6 +75,15 @@ type drive struct {
 	// HomeDrive kernel.
 	*kernel
 
+	// Apps under maintenance.
+	maintenance *appMaintenance
+
+	// Client certificates for doorway.
+	clientCerts *clientCerts
+
+	// Local CA and certificate for serving local network hosts.
+	localHTTPS *localHTTPS
+
 	// System task runner.
 	tasks *taskLoop
 }
@@ -140,7 +152,7 @@ func newDrive(config *drvcfg.Config, k *kernel) (*drive, error) {
 
 	tasks := newTaskLoop()
 
-	return &drive{
+	d := &drive{
 		config:         config,
 		server:         server,
 		name:           name,
@@ -149,7 +161,11 @@ func newDrive(config *drvcfg.Config, k *kernel) (*drive, error) {
 		sysDock:        sysDock,
 		kernel:         k,
 		tasks:          tasks,
-	}, nil
+	}
+	d.maintenance = newAppMaintenance(d)
+	d.clientCerts = newClientCerts(d.settings, name)
+	d.localHTTPS = newLocalHTTPS(d.settings, name)
+	return d, nil
 }
 
 func (d *drive) hasServer() bool {
@@ -215,9 +231,15 @@ func (d *drive) downloadConfig() *homeboot.DownloadConfig {
 	}
 }
 
-func (d *drive) Docker() *dock.Client     { return d.dock }
-func (d *drive) Naming() *drvcfg.Naming   { return d.config.Naming }
-func (d *drive) Domains() homeapp.Domains { return d.appDomains }
+func (d *drive) Docker() *dock.Client   { return d.dock }
+func (d *drive) Naming() *drvcfg.Naming { return d.config.Naming }
+
+func (d *drive) Domains() homeapp.Domains {
+	return &driveDomains{
+		appDomains:     d.appDomains,
+		appMaintenance: d.maintenance,
+	}
+}
 
 func (d *drive) Settings() settings.Settings {
 	if d.settings == nil {
@@ -0,0 +1,105 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"sync"
+
+	"shanhu.io/g/dock"
+	"shanhu.io/g/errcode"
+	drvcfg "shanhu.io/homedrv/drv/drvconfig"
+	"shanhu.io/homedrv/drv/homeapp/apputil"
+	"shanhu.io/homedrv/drv/hostos"
+)
+
+const (
+	hostOSSystemd = "systemd"
+
+	nameHostHelper = "hostos"
+	nameToolbox    = "toolbox"
+)
+
+// hasHostOS checks if the drive manages the host OS, either BurmillaOS
+// through the system docker, or a systemd host through the helper.
+func (d *drive) hasHostOS() bool {
+	if d.hasSys() {
+		return true
+	}
+	return !d.config.External && d.config.HostOS == hostOSSystemd
+}
+
+func (d *drive) hostOS() (hostos.HostOS, error) {
+	if d.hasSys() {
+		b, err := d.burmilla()
+		if err != nil {
+			return nil, err
+		}
+		return hostos.NewBurmilla(b), nil
+	}
+	if !d.hasHostOS() {
+		return nil, errcode.Internalf("this drive does not manage the OS")
+	}
+	cont, err := ensureHostHelper(d)
+	if err != nil {
+		return nil, errcode.Annotate(err, "start host helper")
+	}
+	config := &hostos.SystemdConfig{SSHUser: d.config.HostSSHUser}
+	return hostos.NewSystemd(hostos.NewHelper(cont), config), nil
+}
+
+var hostHelperMu sync.Mutex
+
+// ensureHostHelper starts the privileged helper container for managing
+// a systemd host, if it is not running yet. The helper runs the toolbox
+// image, which only sleeps; commands are executed in it.
+func ensureHostHelper(d *drive) (*dock.Cont, error) {
+	hostHelperMu.Lock()
+	defer hostHelperMu.Unlock()
+
+	name := d.cont(nameHostHelper)
+	cont := dock.NewCont(d.dock, name)
+	info, err := cont.Inspect()
+	if err == nil && info.State != nil && info.State.Running {
+		return cont, nil
+	}
+	if err != nil && !errcode.IsNotFound(err) {
+		return nil, errcode.Annotate(err, "inspect host helper")
+	}
+	if err := apputil.DropIfExists(cont); err != nil {
+		return nil, errcode.Annotate(err, "drop stopped host helper")
+	}
+
+	config := &dock.ContConfig{
+		Name:        name,
+		Network:     "host",
+		Privileged:  true,
+		AutoRestart: true,
+		Mounts: []*dock.ContMount{{
+			Host: "/",
+			Cont: hostos.HelperRoot,
+		}},
+		Labels:        drvcfg.NewNameLabel(nameHostHelper),
+		JSONLogConfig: dock.LimitedJSONLog(),
+	}
+	c, err := dock.CreateCont(d.dock, d.image(nameToolbox), config)
+	if err != nil {
+		return nil, errcode.Annotate(err, "create host helper")
+	}
+	if err := c.Start(); err != nil {
+		return nil, errcode.Annotate(err, "start host helper")
+	}
+	return c, nil
+}
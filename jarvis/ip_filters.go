@@ -0,0 +1,149 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/httputil"
+	"shanhu.io/g/settings"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
+)
+
+// ipFilter is the IP allow and deny lists of a domain. Entries can be
+// CIDRs, IP addresses or named presets of doorway.
+type ipFilter struct {
+	Allow []string `json:",omitempty"`
+	Deny  []string `json:",omitempty"`
+}
+
+func (f *ipFilter) String() string {
+	var parts []string
+	if len(f.Allow) > 0 {
+		parts = append(parts, "allow "+strings.Join(f.Allow, ","))
+	}
+	if len(f.Deny) > 0 {
+		parts = append(parts, "deny "+strings.Join(f.Deny, ","))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func splitIPList(s string) []string {
+	var list []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func parseIPFilterFlags(allow, deny string) (*ipFilter, error) {
+	f := &ipFilter{
+		Allow: splitIPList(allow),
+		Deny:  splitIPList(deny),
+	}
+	if _, err := doorwaypkg.ParseIPList(f.Allow); err != nil {
+		return nil, errcode.Annotate(err, "invalid allow list")
+	}
+	if _, err := doorwaypkg.ParseIPList(f.Deny); err != nil {
+		return nil, errcode.Annotate(err, "invalid deny list")
+	}
+	return f, nil
+}
+
+func loadDomainIPFilters(s settings.Settings) (map[string]*ipFilter, error) {
+	filters := make(map[string]*ipFilter)
+	if err := s.Get(keyDomainIPFilters, &filters); err != nil {
+		if errcode.IsNotFound(err) {
+			return filters, nil
+		}
+		return nil, err
+	}
+	return filters, nil
+}
+
+func cmdDomainIPFilters(args []string) error {
+	flags := cmdFlags.New()
+	sock := declareJarvisSockFlag(flags)
+	set := flags.Bool("set", false, "sets the IP filter of a domain")
+	remove := flags.Bool("remove", false, "removes the IP filter of a domain")
+	allow := flags.String(
+		"allow", "",
+		"comma separated IP ranges or presets (lan, tailscale, loopback) "+
+			"that can visit the domain",
+	)
+	deny := flags.String(
+		"deny", "", "comma separated IP ranges or presets to block",
+	)
+	cflags := newClientFlags(flags)
+	args = flags.ParseArgs(args)
+
+	d, err := newClientDrive(cflags)
+	if err != nil {
+		return err
+	}
+	filters, err := loadDomainIPFilters(d.settings)
+	if err != nil {
+		return errcode.Annotate(err, "read domain ip filters")
+	}
+
+	if !*set && !*remove {
+		if len(args) != 0 {
+			return errcode.InvalidArgf("list takes no arg")
+		}
+		var domains []string
+		for domain := range filters {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		for _, domain := range domains {
+			fmt.Printf("%s: %s\n", domain, filters[domain])
+		}
+		return nil
+	}
+
+	if len(args) != 1 {
+		return errcode.InvalidArgf("expect a domain")
+	}
+	domain := args[0]
+	if *set {
+		f, err := parseIPFilterFlags(*allow, *deny)
+		if err != nil {
+			return err
+		}
+		if len(f.Allow) == 0 && len(f.Deny) == 0 {
+			return errcode.InvalidArgf("allow and deny lists are empty")
+		}
+		filters[domain] = f
+	} else {
+		if _, ok := filters[domain]; !ok {
+			return errcode.InvalidArgf("domain %q has no ip filter", domain)
+		}
+		delete(filters, domain)
+	}
+
+	if err := d.settings.Set(keyDomainIPFilters, filters); err != nil {
+		return errcode.Annotate(err, "save domain ip filters")
+	}
+
+	// Ping jarvis to recreate doorway so that hostmap will be updated.
+	c := httputil.NewUnixClient(*sock)
+	return c.Call("/api/admin/recreate-doorway", nil, nil)
+}
@@ -0,0 +1,389 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/settings"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
+)
+
+const (
+	// Browsers reject server certificates that are valid for longer.
+	localCertValidity = 397 * 24 * time.Hour
+
+	localCertRenewBefore = 30 * 24 * time.Hour
+)
+
+// localCertData is the server certificate for local network hosts,
+// issued by the local CA.
+type localCertData struct {
+	Key   []byte   // PKCS#8 encoded private key.
+	Cert  []byte   // DER encoded certificate.
+	Names []string // DNS names in the certificate.
+	IPs   []string // IP addresses in the certificate.
+}
+
+// localHTTPS manages the local CA and the certificate that doorway uses
+// to serve HTTPS for <name>.local and the LAN IP addresses.
+type localHTTPS struct {
+	settings settings.Settings
+	name     string
+
+	mu sync.Mutex
+}
+
+func newLocalHTTPS(s settings.Settings, name string) *localHTTPS {
+	return &localHTTPS{settings: s, name: name}
+}
+
+// localCAIPRanges are the IP ranges that the local CA can sign for.
+var localCAIPRanges = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"127.0.0.0/8",
+	"fc00::/7",
+	"fe80::/10",
+	"::1/128",
+}
+
+// localCATemplate returns the certificate template of the local CA. Users
+// install the local CA on their devices, so it is name constrained to
+// .local names and private IP addresses; a leaked CA key cannot sign
+// certificates for other domains.
+func localCATemplate(name string) (*x509.Certificate, error) {
+	tmpl, err := caTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.PermittedDNSDomainsCritical = true
+	tmpl.PermittedDNSDomains = []string{".local"}
+	for _, r := range localCAIPRanges {
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, errcode.Annotatef(err, "parse ip range %q", r)
+		}
+		tmpl.PermittedIPRanges = append(tmpl.PermittedIPRanges, ipNet)
+	}
+	return tmpl, nil
+}
+
+// localCAConstrained checks if the local CA is name constrained. CAs
+// created by older versions are not.
+func localCAConstrained(cert *x509.Certificate) bool {
+	return cert.PermittedDNSDomainsCritical &&
+		len(cert.PermittedDNSDomains) > 0 &&
+		len(cert.PermittedIPRanges) > 0
+}
+
+func (l *localHTTPS) ca() (*x509.Certificate, crypto.Signer, error) {
+	cert, key, err := loadCA(l.settings, keyLocalCA)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cert != nil {
+		if localCAConstrained(cert) {
+			return cert, key, nil
+		}
+		log.Println("replacing local ca that has no name constraints")
+	}
+	tmpl, err := localCATemplate("HomeDrive local CA " + l.name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return createCA(l.settings, keyLocalCA, tmpl)
+}
+
+func (l *localHTTPS) names() []string {
+	return []string{l.name + ".local"}
+}
+
+func (l *localHTTPS) loadCert() (*localCertData, error) {
+	data := new(localCertData)
+	if err := l.settings.Get(keyLocalCert, data); err != nil {
+		if errcode.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (l *localHTTPS) certValid(
+	data *localCertData, ips []string, ca *x509.Certificate,
+) bool {
+	if data == nil {
+		return false
+	}
+	if !reflect.DeepEqual(data.Names, l.names()) {
+		return false
+	}
+	if !reflect.DeepEqual(data.IPs, ips) {
+		return false
+	}
+	cert, err := x509.ParseCertificate(data.Cert)
+	if err != nil {
+		return false
+	}
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		return false // Issued by a replaced CA.
+	}
+	return time.Now().Add(localCertRenewBefore).Before(cert.NotAfter)
+}
+
+// cert returns the certificate for the given IP addresses. A new
+// certificate is issued when the hosts change or the current one is
+// expiring. It also returns if the certificate is newly issued.
+func (l *localHTTPS) cert(ips []string) (*localCertData, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cur, err := l.loadCert()
+	if err != nil {
+		return nil, false, errcode.Annotate(err, "load local cert")
+	}
+	caCert, caKey, err := l.ca()
+	if err != nil {
+		return nil, false, errcode.Annotate(err, "load local ca")
+	}
+	if l.certValid(cur, ips, caCert) {
+		return cur, false, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, false, errcode.Annotate(err, "generate key")
+	}
+	serial, err := randSerial()
+	if err != nil {
+		return nil, false, errcode.Annotate(err, "generate serial")
+	}
+	names := l.names()
+	var ipAddrs []net.IP
+	for _, ip := range ips {
+		ipAddrs = append(ipAddrs, net.ParseIP(ip))
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		IPAddresses:  ipAddrs,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(localCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, tmpl, caCert, key.Public(), caKey,
+	)
+	if err != nil {
+		return nil, false, errcode.Annotate(err, "create cert")
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, false, errcode.Annotate(err, "marshal key")
+	}
+
+	data := &localCertData{
+		Key:   keyBytes,
+		Cert:  der,
+		Names: names,
+		IPs:   ips,
+	}
+	if err := l.settings.Set(keyLocalCert, data); err != nil {
+		return nil, false, errcode.Annotate(err, "save local cert")
+	}
+	return data, true, nil
+}
+
+func pemCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// caCert returns the local CA certificate, creating the CA if it does
+// not exist yet.
+func (l *localHTTPS) caCert() (*x509.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cert, _, err := l.ca()
+	return cert, err
+}
+
+// doorwayConfig returns the local HTTPS config for doorway, which serves
+// the local hosts with the certificate and forwards them to to.
+func (l *localHTTPS) doorwayConfig(ips []string, to string) (
+	*doorwaypkg.LocalFileConfig, error,
+) {
+	data, _, err := l.cert(ips)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := l.caCert()
+	if err != nil {
+		return nil, errcode.Annotate(err, "load local ca")
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: data.Key,
+	})
+	certs := string(pemCert(data.Cert)) + string(pemCert(caCert.Raw))
+	return &doorwaypkg.LocalFileConfig{
+		Key:   string(keyPEM),
+		Certs: certs,
+		To:    to,
+	}, nil
+}
+
+// lanIPs returns the IP addresses of the host on the local network. It
+// returns nil when the drive does not manage the OS.
+func lanIPs(d *drive) ([]string, error) {
+	if !d.hasHostOS() {
+		return nil, nil
+	}
+	host, err := d.hostOS()
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := host.Addrs()
+	if err != nil {
+		return nil, errcode.Annotate(err, "get host addresses")
+	}
+	var ips []string
+	for _, a := range addrs {
+		if isDockerDev(a.Dev) {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(a.Addr)
+		if err != nil {
+			continue
+		}
+		ips = append(ips, ip.String())
+	}
+	return ips, nil
+}
+
+func isDockerDev(dev string) bool {
+	for _, p := range mdnsSkipInterfaces {
+		if strings.HasPrefix(dev, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// cronLocalHTTPS renews the local certificate when the LAN addresses
+// change or the certificate is expiring, and recreates doorway to use it.
+func cronLocalHTTPS(d *drive) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		ips, err := lanIPs(d)
+		if err != nil {
+			log.Println("get lan ips: ", err)
+			continue
+		}
+		_, renewed, err := d.localHTTPS.cert(ips)
+		if err != nil {
+			log.Println("renew local cert: ", err)
+			continue
+		}
+		if renewed {
+			t := &taskRecreateDoorway{drive: d}
+			if err := d.tasks.run("recreate doorway", t); err != nil {
+				log.Println("recreate doorway for local cert: ", err)
+			}
+		}
+	}
+}
+
+// DashboardLocalHTTPSData has the info of the local CA, for installing it
+// on home devices.
+type DashboardLocalHTTPSData struct {
+	CACert        string // PEM encoded.
+	CAFingerprint string // SHA-256 fingerprint, in hex.
+	Names         []string
+	IPs           []string `json:",omitempty"`
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	var parts []string
+	for _, b := range sum {
+		parts = append(parts, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+func newDashboardLocalHTTPSData(s *server, _ *aries.C) (
+	*DashboardLocalHTTPSData, error,
+) {
+	l := s.drive.localHTTPS
+	caCert, err := l.caCert()
+	if err != nil {
+		return nil, errcode.Annotate(err, "load local ca")
+	}
+	data := &DashboardLocalHTTPSData{
+		CACert:        string(pemCert(caCert.Raw)),
+		CAFingerprint: certFingerprint(caCert),
+		Names:         l.names(),
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cert, err := l.loadCert()
+	if err != nil {
+		return nil, errcode.Annotate(err, "load local cert")
+	}
+	if cert != nil {
+		data.IPs = cert.IPs
+	}
+	return data, nil
+}
+
+// serveLocalCACert serves the local CA certificate for downloading. The
+// certificate is public, so it does not need signing in.
+func serveLocalCACert(s *server, c *aries.C) error {
+	caCert, err := s.drive.localHTTPS.caCert()
+	if err != nil {
+		return errcode.Annotate(err, "load local ca")
+	}
+	h := c.Resp.Header()
+	h.Set("Content-Type", "application/x-x509-ca-cert")
+	h.Set(
+		"Content-Disposition",
+		fmt.Sprintf("attachment; filename=%q", s.drive.name+"-ca.crt"),
+	)
+	_, err = c.Resp.Write(pemCert(caCert.Raw))
+	return err
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLocalCATemplate(t *testing.T) {
+	tmpl, err := localCATemplate("test local ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, tmpl, tmpl, caKey.Public(), caKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !localCAConstrained(ca) {
+		t.Fatal("local ca is not name constrained")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	now := time.Now()
+	for i, test := range []struct {
+		names []string
+		ips   []string
+		ok    bool
+	}{
+		{names: []string{"drive.local"}, ok: true},
+		{ips: []string{"192.168.1.2", "fd00::2"}, ok: true},
+		{names: []string{"example.com"}, ok: false},
+		{names: []string{"drive.local.example.com"}, ok: false},
+		{ips: []string{"8.8.8.8"}, ok: false},
+	} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf := &x509.Certificate{
+			SerialNumber: tmpl.SerialNumber,
+			Subject:      pkix.Name{CommonName: "test"},
+			DNSNames:     test.names,
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		for _, ip := range test.ips {
+			leaf.IPAddresses = append(leaf.IPAddresses, net.ParseIP(ip))
+		}
+		der, err := x509.CreateCertificate(
+			rand.Reader, leaf, ca, key.Public(), caKey,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{Roots: roots})
+		if test.ok && err != nil {
+			t.Errorf("test %d: verify failed: %s", i, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("test %d: verify succeeded, want error", i)
+		}
+	}
+}
@@ -65,4 +65,5 @@ const (
 	logTypeLoginAttempt   = "loginAttempt"
 	logTypeTwoFactorEvent = "twoFactorEvent"
 	logTypeChangePassword = "changePassword"
+	logTypeDDNSUpdate     = "ddnsUpdate"
 )
@@ -49,6 +49,11 @@ func makeService(s *server, api aries.Service) aries.Service {
 func bg(s *server) {
 	d := s.Drive()
 
+	// No app can be under maintenance at this point.
+	if err := d.maintenance.clearAll(); err != nil {
+		log.Println("clear maintenance marks:", err)
+	}
+
 	// Before starting the system tasks scheduler, make sure the system is
 	// properlly installed.
 	installed, err := d.settings.Has(keyBuild)
@@ -69,12 +74,24 @@ func bg(s *server) {
 		fixThings(d)
 	}
 
+	if !d.config.External {
+		// Restarts the responder, so it runs the current jarvis image.
+		if err := startMDNS(d); err != nil {
+			log.Println("start mdns responder:", err)
+		}
+	}
+
 	if d.config.Channel != "" {
 		// Subscribe channel and maybe schedule update task.
 		go cronUpdateOnChannel(d, s.updateSignal)
 	}
 
 	go cronNextcloud(d)
+	go cronDialConfig(d)
+	go cronDDNS(d)
+	if !d.config.External {
+		go cronLocalHTTPS(d)
+	}
 
 	d.tasks.bg() // Handle background system tasks now.
 }
@@ -106,6 +123,10 @@ func run(homeDir, addr string) error {
 		}
 	}
 	d := s.Drive()
+	if err := applyDialConfig(d); err != nil {
+		// Still can dial with the built-in addresses.
+		log.Println("apply dial config:", err)
+	}
 	if err := maybeUpdateOS(d); err != nil {
 		// Just exit here. If this is a temp error, it will retry the next
 		// time the container starts.
@@ -121,6 +142,9 @@ func run(homeDir, addr string) error {
 	go func(api aries.Service) {
 		r := aries.NewRouter()
 		r.DirService("api", api)
+		r.File("metrics", aries.Func(func(c *aries.C) error {
+			return writeMetrics(s.drive, c)
+		}))
 
 		if err := aries.ListenAndServe(sock, r); err != nil {
 			log.Fatal(errcode.Annotate(err, "listen and serve on socket"))
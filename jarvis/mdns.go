@@ -0,0 +1,145 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"context"
+	"encoding/json"
+
+	"shanhu.io/g/dock"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/settings"
+	drvcfg "shanhu.io/homedrv/drv/drvconfig"
+	"shanhu.io/homedrv/drv/homeapp/apputil"
+	"shanhu.io/homedrv/drv/mdns"
+)
+
+const nameMDNS = "mdns"
+
+// MDNSConfig is the config for announcing the drive on the local network
+// as <name>.local with multicast DNS.
+type MDNSConfig struct {
+	// Disabled stops the announcing. It is enabled by default.
+	Disabled bool `json:",omitempty"`
+}
+
+func loadMDNSConfig(s settings.Settings) (*MDNSConfig, error) {
+	c := new(MDNSConfig)
+	if err := s.Get(keyMDNSConfig, c); err != nil {
+		if errcode.IsNotFound(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// mdnsServices are the DNS-SD service types that the drive announces.
+var mdnsServices = []string{"_https._tcp", "_homedrive._tcp"}
+
+// mdnsSkipInterfaces are the virtual interfaces of docker, whose addresses
+// are not reachable from the local network.
+var mdnsSkipInterfaces = []string{"docker", "br-", "veth"}
+
+func mdnsResponderConfig(d *drive) *mdns.Config {
+	c := &mdns.Config{
+		Host:           d.name,
+		SkipInterfaces: mdnsSkipInterfaces,
+	}
+
+	_, port := doorwayHostPorts(d)
+	if port == 0 {
+		return c // Not serving HTTPS on the host; only announce the name.
+	}
+	for _, typ := range mdnsServices {
+		c.Services = append(c.Services, &mdns.Service{
+			Instance: "HomeDrive " + d.name,
+			Type:     typ,
+			Port:     port,
+			TXT:      []string{"name=" + d.name},
+		})
+	}
+	return c
+}
+
+// startMDNS (re)starts the mDNS responder container. The responder runs
+// the jarvis image with host networking, as multicast packets sent from
+// the docker network do not reach the local network.
+func startMDNS(d *drive) error {
+	cont := dock.NewCont(d.dock, d.cont(nameMDNS))
+	if err := apputil.DropIfExists(cont); err != nil {
+		return errcode.Annotate(err, "drop old mdns responder")
+	}
+
+	config, err := loadMDNSConfig(d.settings)
+	if err != nil {
+		return errcode.Annotate(err, "load mdns config")
+	}
+	if config.Disabled {
+		return nil
+	}
+
+	self, err := dock.InspectCont(d.dock, d.core())
+	if err != nil {
+		return errcode.Annotate(err, "inspect self")
+	}
+	responder, err := json.Marshal(mdnsResponderConfig(d))
+	if err != nil {
+		return errcode.Annotate(err, "marshal responder config")
+	}
+
+	dockConfig := &dock.ContConfig{
+		Name:          d.cont(nameMDNS),
+		Network:       "host",
+		AutoRestart:   true,
+		Labels:        drvcfg.NewNameLabel(nameMDNS),
+		JSONLogConfig: dock.LimitedJSONLog(),
+		Cmd: []string{
+			"jarvis", "mdns-responder", "-config", string(responder),
+		},
+	}
+	c, err := dock.CreateCont(d.dock, self.Image, dockConfig)
+	if err != nil {
+		return errcode.Annotate(err, "create mdns responder")
+	}
+	if err := c.Start(); err != nil {
+		return errcode.Annotate(err, "start mdns responder")
+	}
+	return nil
+}
+
+type taskRestartMDNS struct {
+	drive *drive
+}
+
+func (t *taskRestartMDNS) run() error {
+	return startMDNS(t.drive)
+}
+
+func cmdMDNSResponder(args []string) error {
+	flags := cmdFlags.New()
+	configJSON := flags.String("config", "", "responder config in JSON")
+	args = flags.ParseArgs(args)
+	if len(args) != 0 {
+		return errcode.InvalidArgf("expects no args")
+	}
+
+	config := new(mdns.Config)
+	if err := json.Unmarshal([]byte(*configJSON), config); err != nil {
+		return errcode.Annotate(err, "parse config")
+	}
+	return mdns.Serve(context.Background(), config)
+}
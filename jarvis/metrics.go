@@ -0,0 +1,346 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"crypto/subtle"
+	"log"
+	"strings"
+	"time"
+
+	"shanhu.io/g/aries"
+	"shanhu.io/g/dock"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/rand"
+	"shanhu.io/g/settings"
+	"shanhu.io/homedrv/drv/drvapi"
+	"shanhu.io/homedrv/drv/homeapp"
+	"shanhu.io/homedrv/drv/metrics"
+)
+
+// jarvisMetrics are the metrics that are counted as things happen.
+// Metrics that reflect the current state are collected on each scrape.
+type jarvisMetrics struct {
+	reg   *metrics.Registry
+	start time.Time
+
+	tasksQueued   *metrics.Gauge
+	tasksRunning  *metrics.Gauge
+	taskDuration  *metrics.Histogram
+	updateResult  *metrics.Gauge
+	updateTime    *metrics.Gauge
+	logins        *metrics.Counter
+	loginFailures *metrics.Counter
+}
+
+func newJarvisMetrics() *jarvisMetrics {
+	r := metrics.NewRegistry()
+	return &jarvisMetrics{
+		reg:   r,
+		start: time.Now(),
+
+		tasksQueued: r.NewGauge(
+			"jarvis_tasks_queued", "Number of tasks waiting to run.",
+		),
+		tasksRunning: r.NewGauge(
+			"jarvis_tasks_running", "Number of tasks running.",
+		),
+		taskDuration: r.NewHistogram(
+			"jarvis_task_duration_seconds",
+			"Time spent on running system tasks.",
+			[]float64{1, 5, 15, 60, 300, 900, 1800, 3600},
+			"result",
+		),
+		updateResult: r.NewGauge(
+			"jarvis_update_last_success",
+			"1 if the last update attempt succeeded, 0 if failed.",
+		),
+		updateTime: r.NewGauge(
+			"jarvis_update_last_timestamp_seconds",
+			"Time of the last update attempt, by result.",
+			"result",
+		),
+		logins: r.NewCounter(
+			"jarvis_logins_total", "Number of successful logins.",
+			"method",
+		),
+		loginFailures: r.NewCounter(
+			"jarvis_login_failures_total",
+			"Number of failed logins, by method and reason.",
+			"method", "reason",
+		),
+	}
+}
+
+// stats is the global metrics of jarvis.
+var stats = newJarvisMetrics()
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+func (m *jarvisMetrics) recordUpdate(err error) {
+	v := 1.0
+	if err != nil {
+		v = 0
+	}
+	m.updateResult.Set(v)
+	m.updateTime.Set(float64(time.Now().Unix()), resultLabel(err))
+}
+
+func loginMethodLabel(twoFactor string) string {
+	if twoFactor == "" {
+		return "password"
+	}
+	return twoFactor
+}
+
+// loginFailureReason returns the reason label of a failed login with
+// wrong credentials, like "wrong-password" or "wrong-totp".
+func loginFailureReason(twoFactor string) string {
+	return "wrong-" + loginMethodLabel(twoFactor)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func gaugeSample(v float64, labels ...string) []*metrics.Sample {
+	return []*metrics.Sample{{Labels: labels, Value: v}}
+}
+
+// stateMetrics collects metrics that reflect the current state of the
+// system on each scrape.
+func stateMetrics(d *drive) *metrics.Registry {
+	r := metrics.NewRegistry()
+	gauge := func(name, help string, v float64) {
+		r.NewCollector(
+			name, help, metrics.TypeGauge, nil,
+			func() []*metrics.Sample { return gaugeSample(v) },
+		)
+	}
+
+	gauge(
+		"jarvis_uptime_seconds", "Seconds since jarvis started.",
+		time.Since(stats.start).Seconds(),
+	)
+
+	build := new(drvapi.Release)
+	if err := d.settings.Get(keyBuild, build); err != nil {
+		if !errcode.IsNotFound(err) {
+			log.Println("metrics: read build: ", err)
+		}
+	}
+	r.NewCollector(
+		"jarvis_build_info", "Current build of the system.",
+		metrics.TypeGauge, []string{"name", "type"},
+		func() []*metrics.Sample {
+			return gaugeSample(1, build.Name, build.Type)
+		},
+	)
+
+	updating := new(drvapi.Release)
+	if err := d.settings.Get(keyBuildUpdating, updating); err != nil {
+		if !errcode.IsNotFound(err) {
+			log.Println("metrics: read updating build: ", err)
+		}
+	}
+	gauge(
+		"jarvis_update_pending", "1 if an update is not finished yet.",
+		boolValue(updating.Name != ""),
+	)
+
+	// Reads from the settings rather than d.apps, as d.apps is only
+	// accessed in the task loop.
+	appsStore := &appsStateSettings{key: keyAppsState, settings: d.settings}
+	state, err := appsStore.load()
+	if err != nil {
+		log.Println("metrics: load apps state: ", err)
+		state = new(appsState)
+	}
+	var appInfos, appUps []*metrics.Sample
+	for _, name := range state.list() {
+		meta := state.meta(name)
+		appInfos = append(appInfos, &metrics.Sample{
+			Labels: []string{name, meta.SemVersion},
+			Value:  float64(meta.Version),
+		})
+		appUps = append(appUps, &metrics.Sample{
+			Labels: []string{name},
+			Value:  boolValue(appContRunning(d, name)),
+		})
+	}
+	r.NewCollector(
+		"jarvis_app_version", "Installed version counter of an app.",
+		metrics.TypeGauge, []string{"app", "sem_version"},
+		func() []*metrics.Sample { return appInfos },
+	)
+	r.NewCollector(
+		"jarvis_app_up", "1 if the container of an app is running.",
+		metrics.TypeGauge, []string{"app"},
+		func() []*metrics.Sample { return appUps },
+	)
+
+	if d.hasHostOS() {
+		if err := hostMetrics(d, r, gauge); err != nil {
+			log.Println("metrics: query host: ", err)
+		}
+	}
+	return r
+}
+
+func appContRunning(d *drive, app string) bool {
+	info, err := dock.InspectCont(d.dock, homeapp.Cont(d, app))
+	if err != nil {
+		if !errcode.IsNotFound(err) {
+			log.Printf("metrics: inspect %q: %s", app, err)
+		}
+		return false
+	}
+	return info.State != nil && info.State.Running
+}
+
+func hostMetrics(
+	d *drive, r *metrics.Registry, gauge func(name, help string, v float64),
+) error {
+	host, err := d.hostOS()
+	if err != nil {
+		return errcode.Annotate(err, "init host OS stub")
+	}
+	du, err := host.DiskUsage()
+	if err != nil {
+		return errcode.Annotate(err, "get disk usage")
+	}
+	gauge(
+		"jarvis_host_disk_total_bytes",
+		"Total size of the disk that holds the docker data root.",
+		float64(du.Total),
+	)
+	gauge(
+		"jarvis_host_disk_free_bytes",
+		"Free space of the disk that holds the docker data root.",
+		float64(du.Free),
+	)
+
+	ncPath, err := nextcloudDataPath(d.settings)
+	if err != nil {
+		return errcode.Annotate(err, "read nextcloud data mount")
+	}
+	ncUsage, err := host.PathUsage(ncPath)
+	if err != nil {
+		return errcode.Annotate(err, "get nextcloud data usage")
+	}
+	gauge(
+		"jarvis_host_nextcloud_data_total_bytes",
+		"Total size of the disk that holds the nextcloud data.",
+		float64(ncUsage.Total),
+	)
+	gauge(
+		"jarvis_host_nextcloud_data_free_bytes",
+		"Free space of the disk that holds the nextcloud data.",
+		float64(ncUsage.Free),
+	)
+
+	inv, err := host.DiskInventory()
+	if err != nil {
+		return errcode.Annotate(err, "get disk inventory")
+	}
+	var totals, frees []*metrics.Sample
+	for _, fs := range inv.Filesystems {
+		labels := []string{fs.Device, fs.Mountpoint}
+		totals = append(totals, &metrics.Sample{
+			Labels: labels, Value: float64(fs.Total),
+		})
+		frees = append(frees, &metrics.Sample{
+			Labels: labels, Value: float64(fs.Free),
+		})
+	}
+	fsLabels := []string{"device", "mountpoint"}
+	r.NewCollector(
+		"jarvis_host_fs_total_bytes", "Total size of a mounted filesystem.",
+		metrics.TypeGauge, fsLabels,
+		func() []*metrics.Sample { return totals },
+	)
+	r.NewCollector(
+		"jarvis_host_fs_free_bytes", "Free space of a mounted filesystem.",
+		metrics.TypeGauge, fsLabels,
+		func() []*metrics.Sample { return frees },
+	)
+
+	uptime, err := host.Uptime()
+	if err != nil {
+		return errcode.Annotate(err, "query system uptime")
+	}
+	gauge(
+		"jarvis_host_uptime_seconds", "Seconds since the host booted.",
+		uptime.Seconds(),
+	)
+	return nil
+}
+
+func writeMetrics(d *drive, c *aries.C) error {
+	c.Resp.Header().Set("Content-Type", metrics.ContentType)
+	if _, err := stats.reg.WriteTo(c.Resp); err != nil {
+		return err
+	}
+	_, err := stateMetrics(d).WriteTo(c.Resp)
+	return err
+}
+
+func metricsToken(s settings.Settings) (string, error) {
+	token, err := settings.String(s, keyMetricsToken)
+	if err != nil {
+		if errcode.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+func newMetricsToken(s settings.Settings) (string, error) {
+	const tokenLen = 32
+	token := rand.Letters(tokenLen)
+	if err := s.Set(keyMetricsToken, token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// serveMetrics serves metrics for requests that carry the metrics token
+// as a bearer token.
+func serveMetrics(s *server, c *aries.C) error {
+	want, err := metricsToken(s.drive.settings)
+	if err != nil {
+		return aries.AltInternal(err, "read metrics token")
+	}
+	const prefix = "Bearer "
+	auth := c.Req.Header.Get("Authorization")
+	if want == "" || !strings.HasPrefix(auth, prefix) {
+		return errcode.Unauthorizedf("metrics token required")
+	}
+	got := strings.TrimPrefix(auth, prefix)
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return errcode.Unauthorizedf("invalid metrics token")
+	}
+	return writeMetrics(s.drive, c)
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+
+	"shanhu.io/g/dock"
+	"shanhu.io/g/errcode"
+	doorwaypkg "shanhu.io/homedrv/drv/doorway"
+	"shanhu.io/homedrv/drv/homeapp"
+)
+
+// doorwayReservedPorts are the ports that doorway uses itself inside its
+// container.
+var doorwayReservedPorts = map[int]bool{
+	8080: true, // HTTP
+	8443: true, // HTTPS
+	8090: true, // Admin
+}
+
+// portForwards returns the port forwarding rules of the installed apps,
+// declared in their app metadata.
+func portForwards(d *drive) ([]*doorwaypkg.PortForward, error) {
+	store := &appsStateSettings{key: keyAppsState, settings: d.settings}
+	state, err := store.load()
+	if err != nil {
+		return nil, errcode.Annotate(err, "load apps state")
+	}
+
+	type portKey struct {
+		proto string
+		port  int
+	}
+	used := make(map[portKey]string)
+
+	// The HTTP and HTTPS ports of doorway on the host are bound already.
+	httpPort, httpsPort := doorwayHostPorts(d)
+	for _, port := range []int{httpPort, httpsPort} {
+		if port > 0 {
+			used[portKey{proto: doorwaypkg.ProtoTCP, port: port}] = "doorway"
+		}
+	}
+
+	var rules []*doorwaypkg.PortForward
+	for _, app := range state.list() {
+		for _, p := range state.meta(app).Ports {
+			proto := p.Protocol
+			if proto == "" {
+				proto = doorwaypkg.ProtoTCP
+			}
+			if proto != doorwaypkg.ProtoTCP && proto != doorwaypkg.ProtoUDP {
+				log.Printf("app %q: unknown protocol %q", app, proto)
+				continue
+			}
+			if p.Port <= 0 || p.ContPort <= 0 {
+				log.Printf(
+					"app %q: invalid port %d:%d", app, p.Port, p.ContPort,
+				)
+				continue
+			}
+			if doorwayReservedPorts[p.Port] {
+				log.Printf("app %q: port %d is reserved", app, p.Port)
+				continue
+			}
+			k := portKey{proto: proto, port: p.Port}
+			if other, ok := used[k]; ok {
+				log.Printf(
+					"app %q: %s port %d is already used by %q",
+					app, proto, p.Port, other,
+				)
+				continue
+			}
+			used[k] = app
+
+			to := net.JoinHostPort(
+				homeapp.Cont(d, app), strconv.Itoa(p.ContPort),
+			)
+			rules = append(rules, &doorwaypkg.PortForward{
+				Protocol: proto,
+				Port:     p.Port,
+				To:       to,
+			})
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Protocol != rules[j].Protocol {
+			return rules[i].Protocol < rules[j].Protocol
+		}
+		return rules[i].Port < rules[j].Port
+	})
+	return rules, nil
+}
+
+// portForwardBinds returns the port bindings of doorway's container for the
+// forwarding rules.
+func portForwardBinds(rules []*doorwaypkg.PortForward) (
+	tcp, udp []*dock.PortBind,
+) {
+	for _, r := range rules {
+		bind := &dock.PortBind{HostPort: r.Port, ContPort: r.Port}
+		if r.Protocol == doorwaypkg.ProtoUDP {
+			udp = append(udp, bind)
+		} else {
+			tcp = append(tcp, bind)
+		}
+	}
+	return tcp, udp
+}
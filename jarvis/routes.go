@@ -29,6 +29,10 @@ func guestRouter(s *server) *aries.Router {
 	r.Get("overview", dash)
 	r.Get("ssh-keys", dash)
 	r.Get("security-logs", dash)
+	r.Get("access-logs", dash)
+	r.Get("upstreams", dash)
+	r.Get("local-https", dash)
+	r.Get("disks", dash)
 	r.Get("change-password", dash)
 	r.Get("2fa", dash)
 	r.Get("2fa/enable-totp", dash)
@@ -39,6 +43,9 @@ func guestRouter(s *server) *aries.Router {
 	r.File("sudo", s.f(serveSudo))
 	r.File("input-totp", s.f(serveInputTOTP))
 	r.File("totp", s.f(serveCheckTOTP))
+	r.File("forward-auth", s.f(serveForwardAuth))
+	r.File("metrics", s.f(serveMetrics))
+	r.File("local-ca.crt", s.f(serveLocalCACert))
 
 	static := s.static.Serve
 	r.Get("style.css", static)
@@ -64,6 +71,7 @@ func apiRouter(s *server) *aries.Router {
 	r.DirService("totp", s.totp.api())
 	r.DirService("sshkeys", s.sshKeys.api())
 	r.DirService("dashboard", dashboardAPI(s))
+	r.DirService("disks", disksAPI(s))
 	r.DirService("id", identity.NewService(s.identity))
 	r.DirService("obj", s.drive.objects.api())
 
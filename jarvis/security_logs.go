@@ -41,6 +41,7 @@ type loginEvent struct {
 }
 
 func (b *securityLogs) recordLogin(user, from, twoFactor string) error {
+	stats.logins.Inc(loginMethodLabel(twoFactor))
 	msg := fmt.Sprintf("login from %q", from)
 	entry := newLogEntry(user, msg)
 	if err := entry.setJSONValue(logTypeLoginAttempt, &loginEvent{
@@ -55,6 +56,9 @@ func (b *securityLogs) recordLogin(user, from, twoFactor string) error {
 func (b *securityLogs) recordFailedLogin(
 	user, from, twoFactor string,
 ) error {
+	stats.loginFailures.Inc(
+		loginMethodLabel(twoFactor), loginFailureReason(twoFactor),
+	)
 	msg := fmt.Sprintf("failed login from %q", from)
 	entry := newLogEntry(user, msg)
 	if err := entry.setJSONValue(logTypeLoginAttempt, &loginEvent{
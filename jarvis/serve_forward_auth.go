@@ -0,0 +1,47 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jarvis
+
+import (
+	"net/url"
+
+	"shanhu.io/g/aries"
+	"shanhu.io/g/errcode"
+	"shanhu.io/g/settings"
+	"shanhu.io/homedrv/drv/homeapp"
+)
+
+// serveForwardAuth serves forward auth requests from doorway. It passes
+// when the request carries a signed in session of jarvis. Browsers only
+// send the session cookie for the host of jarvis, so this works for path
+// routes under the main domain.
+func serveForwardAuth(s *server, c *aries.C) error {
+	aries.NeverCache(c)
+	if c.User != "" {
+		return nil
+	}
+
+	mainDomain, err := settings.String(s.drive.settings, homeapp.KeyMainDomain)
+	if err != nil {
+		if errcode.IsNotFound(err) {
+			return errcode.Unauthorizedf("user not signed in")
+		}
+		return errcode.Annotate(err, "read main domain")
+	}
+	u := &url.URL{Scheme: "https", Host: mainDomain, Path: "/"}
+	c.Redirect(u.String())
+	return nil
+}
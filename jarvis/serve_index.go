@@ -42,6 +42,7 @@ func serveLogin(s *server, c *aries.C) error {
 			}
 
 			if err == errTooManyFailures {
+				stats.loginFailures.Inc("password", "rate-limited")
 				c.Redirect("/?err=too-many-failures")
 			} else {
 				c.Redirect("/?err=wrong-password")
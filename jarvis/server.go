@@ -90,6 +90,7 @@ func newServer(h *osutil.Home, c *drvcfg.Config) (*server, error) {
 		appRegistry: appReg,
 		apps:        apps,
 		objects:     objs,
+		ddnsLogs:    back.ddnsLogs,
 	}
 	drive, err := newDrive(c, kernel)
 	if err != nil {
@@ -99,6 +100,7 @@ func newServer(h *osutil.Home, c *drvcfg.Config) (*server, error) {
 	if err := apps.setMaker(newBuiltInApps(drive)); err != nil {
 		return nil, errcode.Annotate(err, "setup builtin app stubs")
 	}
+	apps.setDomains(drive.Domains())
 
 	sessionKey, err := settings.String(back.settings, keySessionHMAC)
 	if err != nil {
@@ -22,7 +22,16 @@ const (
 	keyJarvisPass = "jarvis.pass"
 
 	keyFabricsServerDomain = "fabrics-server.domain"
+	keyFabricsServers      = "fabrics-server.list"
+	keyDialConfig          = "homedial.config"
 	keyCustomSubs          = "custom.subs"
+	keyDoorwayDNS01        = "doorway.dns01"
+	keyDomainIPFilters     = "domain.ip-filters"
+	keyDoorwayErrorPages   = "doorway.error-pages"
+
+	keyDoorwayTrustedProxies = "doorway.trusted-proxies"
+	keyClientCertHosts       = "doorway.client-cert-hosts"
+	keyDoorwayAdminToken     = "doorway.admin-token"
 
 	keyBuild         = "build"
 	keyBuildUpdating = "build-updating"
@@ -30,5 +39,21 @@ const (
 
 	keyIdentity = "identity"
 
-	keyAppsState = "apps.state"
+	keyClientCA    = "client-ca"
+	keyClientCerts = "client-certs"
+
+	keyLocalCA   = "local-ca"
+	keyLocalCert = "local-cert"
+
+	keyMetricsToken = "metrics.token"
+
+	keyDDNSConfig = "ddns.config"
+	keyDDNSStatus = "ddns.status"
+
+	keyMDNSConfig = "mdns.config"
+
+	keyDiskMounts = "disk.mounts"
+
+	keyAppsState       = "apps.state"
+	keyAppsMaintenance = "apps.maintenance"
 )
@@ -19,10 +19,7 @@ import (
 	"strings"
 
 	"shanhu.io/g/aries"
-	"shanhu.io/g/bosinit"
 	"shanhu.io/g/errcode"
-	"shanhu.io/g/tarutil"
-	"shanhu.io/homedrv/drv/burmilla"
 )
 
 type sshKeys struct {
@@ -40,21 +37,21 @@ type updateSSHKeysRequest struct {
 type updateSSHKeysResponse struct{}
 
 func (k *sshKeys) list() ([]string, error) {
-	b, err := k.drive.burmilla()
+	host, err := k.drive.hostOS()
 	if err != nil {
 		return nil, errcode.Annotate(err, "create os stub")
 	}
-	config, err := burmilla.ConfigExport(b)
+	keys, err := host.SSHKeys()
 	if err != nil {
 		return nil, errcode.Annotate(err, "get ssh keys")
 	}
-	return config.SSHAuthorizedKeys, nil
+	return keys, nil
 }
 
 func (k *sshKeys) apiUpdate(c *aries.C, req *updateSSHKeysRequest) (
 	*updateSSHKeysResponse, error,
 ) {
-	b, err := k.drive.burmilla()
+	host, err := k.drive.hostOS()
 	if err != nil {
 		return nil, errcode.Annotate(err, "create os stub")
 	}
@@ -68,41 +65,9 @@ func (k *sshKeys) apiUpdate(c *aries.C, req *updateSSHKeysRequest) (
 		}
 	}
 
-	config := &bosinit.Config{SSHAuthorizedKeys: keys}
-	if err := burmilla.ConfigMerge(b, config); err != nil {
-		return nil, errcode.Annotate(err, "merge config")
+	if err := host.SetSSHKeys(keys); err != nil {
+		return nil, errcode.Annotate(err, "set ssh keys")
 	}
-
-	// Update authorized_keys file.
-	const (
-		uname   = "rancher"
-		dir     = "/home/rancher/.ssh"
-		keyFile = "authorized_keys"
-	)
-
-	uid, err := burmilla.UserID(b, uname)
-	if err != nil {
-		return nil, errcode.Annotate(err, "get uid")
-	}
-	gid, err := burmilla.GroupID(b, uname)
-	if err != nil {
-		return nil, errcode.Annotate(err, "get gid")
-	}
-	if err := burmilla.Mkdir(b, dir, uname); err != nil {
-		return nil, errcode.Annotate(err, "make .ssh directory")
-	}
-
-	stream := tarutil.NewStream()
-	meta := &tarutil.Meta{
-		Mode:    0600,
-		UserID:  uid,
-		GroupID: gid,
-	}
-	stream.AddString(keyFile, meta, strings.Join(keys, "\n")+"\n")
-	if err := b.CopyInTarStream(stream, dir); err != nil {
-		return nil, errcode.Annotate(err, "write authorized_keys")
-	}
-
 	return &updateSSHKeysResponse{}, nil
 }
 
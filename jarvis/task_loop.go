@@ -15,6 +15,10 @@
 
 package jarvis
 
+import (
+	"time"
+)
+
 type task interface {
 	run() error
 }
@@ -41,12 +45,26 @@ func (l *taskLoop) run(name string, t task) error {
 		task: t,
 		done: make(chan error),
 	}
+	stats.tasksQueued.Inc()
 	l.tasks <- entry
 	return <-entry.done
 }
 
+func runTaskEntry(t *taskEntry) error {
+	stats.tasksQueued.Dec()
+	stats.tasksRunning.Inc()
+	defer stats.tasksRunning.Dec()
+
+	start := time.Now()
+	err := t.task.run()
+	stats.taskDuration.Observe(
+		time.Since(start).Seconds(), resultLabel(err),
+	)
+	return err
+}
+
 func (l *taskLoop) bg() {
 	for t := range l.tasks {
-		t.done <- t.task.run()
+		t.done <- runTaskEntry(t)
 	}
 }
@@ -82,6 +82,12 @@ type taskUpdate struct {
 }
 
 func (t *taskUpdate) run() error {
+	err := t.update()
+	stats.recordUpdate(err)
+	return err
+}
+
+func (t *taskUpdate) update() error {
 	d := t.drive
 	rel := t.rel
 
@@ -258,11 +264,16 @@ func maybeFinishUpdate(d *drive) error {
 		return nil
 	}
 
+	err := finishUpdate(d, r)
+	stats.recordUpdate(err)
+	return err
+}
+
+func finishUpdate(d *drive, r *drvapi.Release) error {
 	// previous round was running on an older version of the core.
 	// so needs to recheck again here.
 	if err := checkSystem(d); err != nil {
 		return errcode.Annotate(err, "check system")
 	}
-
 	return updateAppsAndDoorway(d, r)
 }
@@ -0,0 +1,175 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Entry is a service instance found by browsing.
+type Entry struct {
+	Instance string   // Instance name, like "HomeDrive mybox".
+	Host     string   // Host name, like "mybox.local".
+	Port     int      // Port of the service.
+	IPs      []net.IP `json:",omitempty"`
+	TXT      []string `json:",omitempty"`
+}
+
+func trimDot(s string) string { return strings.TrimSuffix(s, ".") }
+
+// collectEntries collects the instances of service type typ from the
+// records in the responses.
+func collectEntries(typ string, msgs []*dnsmessage.Message) []*Entry {
+	typ = localName(typ)
+
+	var rs []dnsmessage.Resource
+	for _, m := range msgs {
+		rs = append(rs, m.Answers...)
+		rs = append(rs, m.Additionals...)
+	}
+
+	entries := make(map[string]*Entry)
+	var instances []string
+	for _, r := range rs {
+		ptr, ok := r.Body.(*dnsmessage.PTRResource)
+		if !ok || !sameName(r.Header.Name, typ) {
+			continue
+		}
+		name := strings.ToLower(ptr.PTR.String())
+		if entries[name] != nil {
+			continue
+		}
+		instance := strings.TrimSuffix(ptr.PTR.String(), "."+typ)
+		entries[name] = &Entry{Instance: instance}
+		instances = append(instances, name)
+	}
+
+	hosts := make(map[string][]net.IP)
+	for _, r := range rs {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			if e := entries[name]; e != nil {
+				e.Host = trimDot(body.Target.String())
+				e.Port = int(body.Port)
+			}
+		case *dnsmessage.TXTResource:
+			if e := entries[name]; e != nil {
+				for _, s := range body.TXT {
+					if s != "" {
+						e.TXT = append(e.TXT, s)
+					}
+				}
+			}
+		case *dnsmessage.AResource:
+			hosts[name] = append(hosts[name], net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			hosts[name] = append(hosts[name], net.IP(body.AAAA[:]))
+		}
+	}
+
+	sort.Strings(instances)
+	var ret []*Entry
+	for _, name := range instances {
+		e := entries[name]
+		if e.Host == "" {
+			continue // No SRV record.
+		}
+		host := strings.ToLower(e.Host) + "."
+		seen := make(map[string]bool)
+		for _, ip := range hosts[host] {
+			if seen[ip.String()] {
+				continue
+			}
+			seen[ip.String()] = true
+			e.IPs = append(e.IPs, ip)
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+// Browse queries for the instances of a service type, like "_https._tcp",
+// and collects the responses until the context is done or the timeout
+// passes.
+func Browse(ctx context.Context, typ string, timeout time.Duration) (
+	[]*Entry, error,
+) {
+	name, err := dnsmessage.NewName(localName(typ))
+	if err != nil {
+		return nil, fmt.Errorf("invalid service type %q: %w", typ, err)
+	}
+	q := &dnsmessage.Message{
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	bs, err := q.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("pack query: %w", err)
+	}
+
+	// Sending from a port other than 5353 makes it a legacy unicast
+	// query, so responders reply to this socket directly.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	defer conn.Close()
+
+	group := &net.UDPAddr{IP: mdnsGroup4, Port: mdnsPort}
+	if _, err := conn.WriteToUDP(bs, group); err != nil {
+		return nil, fmt.Errorf("send query: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("set deadline: %w", err)
+	}
+
+	var msgs []*dnsmessage.Message
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("read response: %w", err)
+		}
+		m := new(dnsmessage.Message)
+		if err := m.Unpack(buf[:n]); err != nil || !m.Header.Response {
+			continue
+		}
+		msgs = append(msgs, m)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return collectEntries(typ, msgs), nil
+}
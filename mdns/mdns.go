@@ -0,0 +1,273 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package mdns announces a host and its services on the local network
+// with multicast DNS and DNS-SD, as in RFC 6762 and RFC 6763. It can also
+// browse for services.
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const mdnsPort = 5353
+
+var (
+	mdnsGroup4 = net.IPv4(224, 0, 0, 251)
+	mdnsGroup6 = net.ParseIP("ff02::fb")
+)
+
+// Service is a DNS-SD service to announce.
+type Service struct {
+	// Instance is the instance name, like "HomeDrive mybox". It must not
+	// contain dots.
+	Instance string
+
+	// Type is the service type, like "_https._tcp".
+	Type string
+
+	// Port is the port that the service listens on.
+	Port int
+
+	// TXT are the "key=value" strings of the TXT record.
+	TXT []string `json:",omitempty"`
+}
+
+// Config is the config of an mDNS responder. This config is JSON
+// marshallable.
+type Config struct {
+	// Host is the host name to announce, without the ".local" suffix.
+	Host string
+
+	// IPs are the addresses to announce for the host. When empty, the
+	// addresses of the network interfaces are used.
+	IPs []string `json:",omitempty"`
+
+	// SkipInterfaces are the name prefixes of the network interfaces,
+	// like "docker", whose addresses are not announced. Only used when
+	// IPs is empty.
+	SkipInterfaces []string `json:",omitempty"`
+
+	// Services are the services to announce.
+	Services []*Service `json:",omitempty"`
+}
+
+const (
+	// classCacheFlush is the cache flush bit on the class of unique
+	// records.
+	classCacheFlush = 0x8000
+
+	// classUnicast is the bit on the class of a question, which asks for
+	// a unicast response.
+	classUnicast = 0x8000
+
+	// typeAny is the ANY query type.
+	typeAny = dnsmessage.Type(255)
+
+	defaultTTL = 120
+)
+
+const servicesName = "_services._dns-sd._udp.local."
+
+func localName(s string) string {
+	return strings.TrimSuffix(s, ".") + ".local."
+}
+
+func mustName(s string) dnsmessage.Name {
+	n, err := dnsmessage.NewName(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func sameName(a dnsmessage.Name, b string) bool {
+	return strings.EqualFold(a.String(), b)
+}
+
+type service struct {
+	typ      string // Like "_https._tcp.local."
+	instance string // Like "HomeDrive mybox._https._tcp.local."
+	port     uint16
+	txt      []string
+}
+
+// zone has the records of a host and its services.
+type zone struct {
+	host     string // Like "mybox.local."
+	ips      func() []net.IP
+	services []*service
+}
+
+func newZone(c *Config, ips func() []net.IP) (*zone, error) {
+	if c.Host == "" || strings.Contains(c.Host, ".") {
+		return nil, fmt.Errorf("invalid host name %q", c.Host)
+	}
+	z := &zone{host: localName(c.Host), ips: ips}
+	if _, err := dnsmessage.NewName(z.host); err != nil {
+		return nil, fmt.Errorf("invalid host name %q: %w", c.Host, err)
+	}
+	for _, s := range c.Services {
+		if strings.Contains(s.Instance, ".") {
+			return nil, fmt.Errorf("invalid instance name %q", s.Instance)
+		}
+		if s.Port <= 0 || s.Port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", s.Port)
+		}
+		typ := localName(s.Type)
+		svc := &service{
+			typ:      typ,
+			instance: s.Instance + "." + typ,
+			port:     uint16(s.Port),
+			txt:      s.TXT,
+		}
+		if _, err := dnsmessage.NewName(svc.instance); err != nil {
+			return nil, fmt.Errorf("invalid service %q: %w", svc.instance, err)
+		}
+		if len(svc.txt) == 0 {
+			svc.txt = []string{""} // TXT record needs at least one string.
+		}
+		z.services = append(z.services, svc)
+	}
+	return z, nil
+}
+
+func header(n string, t dnsmessage.Type, uniq bool) dnsmessage.ResourceHeader {
+	class := dnsmessage.ClassINET
+	if uniq {
+		class |= classCacheFlush
+	}
+	return dnsmessage.ResourceHeader{
+		Name:  mustName(n),
+		Type:  t,
+		Class: class,
+		TTL:   defaultTTL,
+	}
+}
+
+func (z *zone) addrRecords(typ dnsmessage.Type) []dnsmessage.Resource {
+	var rs []dnsmessage.Resource
+	for _, ip := range z.ips() {
+		if ip4 := ip.To4(); ip4 != nil {
+			if typ != dnsmessage.TypeA && typ != typeAny {
+				continue
+			}
+			r := &dnsmessage.AResource{}
+			copy(r.A[:], ip4)
+			rs = append(rs, dnsmessage.Resource{
+				Header: header(z.host, dnsmessage.TypeA, true),
+				Body:   r,
+			})
+			continue
+		}
+		if typ != dnsmessage.TypeAAAA && typ != typeAny {
+			continue
+		}
+		r := &dnsmessage.AAAAResource{}
+		copy(r.AAAA[:], ip.To16())
+		rs = append(rs, dnsmessage.Resource{
+			Header: header(z.host, dnsmessage.TypeAAAA, true),
+			Body:   r,
+		})
+	}
+	return rs
+}
+
+func (s *service) ptr() dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: header(s.typ, dnsmessage.TypePTR, false),
+		Body:   &dnsmessage.PTRResource{PTR: mustName(s.instance)},
+	}
+}
+
+func (z *zone) srv(s *service) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: header(s.instance, dnsmessage.TypeSRV, true),
+		Body: &dnsmessage.SRVResource{
+			Port:   s.port,
+			Target: mustName(z.host),
+		},
+	}
+}
+
+func (s *service) txtRecord() dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: header(s.instance, dnsmessage.TypeTXT, true),
+		Body:   &dnsmessage.TXTResource{TXT: s.txt},
+	}
+}
+
+// answer returns the answers and the additional records for a question.
+func (z *zone) answer(q dnsmessage.Question) (
+	answers, extras []dnsmessage.Resource,
+) {
+	typ := q.Type
+	if sameName(q.Name, z.host) {
+		return z.addrRecords(typ), nil
+	}
+
+	if sameName(q.Name, servicesName) {
+		if typ != dnsmessage.TypePTR && typ != typeAny {
+			return nil, nil
+		}
+		seen := make(map[string]bool)
+		for _, s := range z.services {
+			if seen[s.typ] {
+				continue
+			}
+			seen[s.typ] = true
+			answers = append(answers, dnsmessage.Resource{
+				Header: header(servicesName, dnsmessage.TypePTR, false),
+				Body:   &dnsmessage.PTRResource{PTR: mustName(s.typ)},
+			})
+		}
+		return answers, nil
+	}
+
+	for _, s := range z.services {
+		switch {
+		case sameName(q.Name, s.typ):
+			if typ != dnsmessage.TypePTR && typ != typeAny {
+				continue
+			}
+			answers = append(answers, s.ptr())
+			extras = append(extras, z.srv(s), s.txtRecord())
+		case sameName(q.Name, s.instance):
+			if typ == dnsmessage.TypeSRV || typ == typeAny {
+				answers = append(answers, z.srv(s))
+			}
+			if typ == dnsmessage.TypeTXT || typ == typeAny {
+				answers = append(answers, s.txtRecord())
+			}
+		}
+	}
+	if len(answers) > 0 {
+		extras = append(extras, z.addrRecords(typeAny)...)
+	}
+	return answers, extras
+}
+
+// announcement returns all the records of the zone, for announcing.
+func (z *zone) announcement() []dnsmessage.Resource {
+	rs := z.addrRecords(typeAny)
+	for _, s := range z.services {
+		rs = append(rs, s.ptr(), z.srv(s), s.txtRecord())
+	}
+	return rs
+}
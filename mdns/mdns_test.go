@@ -0,0 +1,155 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testZone(t *testing.T) *zone {
+	t.Helper()
+	c := &Config{
+		Host: "mybox",
+		Services: []*Service{{
+			Instance: "HomeDrive mybox",
+			Type:     "_homedrive._tcp",
+			Port:     443,
+			TXT:      []string{"name=mybox"},
+		}, {
+			Instance: "HomeDrive mybox",
+			Type:     "_https._tcp",
+			Port:     443,
+		}},
+	}
+	ips := []net.IP{
+		net.ParseIP("192.168.1.10"),
+		net.ParseIP("2001:db8::10"),
+	}
+	z, err := newZone(c, func() []net.IP { return ips })
+	if err != nil {
+		t.Fatal("new zone: ", err)
+	}
+	return z
+}
+
+func question(name string, typ dnsmessage.Type) dnsmessage.Question {
+	return dnsmessage.Question{
+		Name:  mustName(name),
+		Type:  typ,
+		Class: dnsmessage.ClassINET,
+	}
+}
+
+func TestZoneAnswer(t *testing.T) {
+	z := testZone(t)
+
+	for _, test := range []struct {
+		name string
+		typ  dnsmessage.Type
+		want int
+	}{
+		{"mybox.local.", dnsmessage.TypeA, 1},
+		{"MyBox.local.", dnsmessage.TypeAAAA, 1},
+		{"mybox.local.", typeAny, 2},
+		{"other.local.", dnsmessage.TypeA, 0},
+		{servicesName, dnsmessage.TypePTR, 2},
+		{"_https._tcp.local.", dnsmessage.TypePTR, 1},
+		{"_https._tcp.local.", dnsmessage.TypeA, 0},
+		{"HomeDrive mybox._https._tcp.local.", dnsmessage.TypeSRV, 1},
+		{"HomeDrive mybox._https._tcp.local.", typeAny, 2},
+	} {
+		answers, _ := z.answer(question(test.name, test.typ))
+		if got := len(answers); got != test.want {
+			t.Errorf(
+				"answer %q type %v: got %d records, want %d",
+				test.name, test.typ, got, test.want,
+			)
+		}
+	}
+}
+
+func TestNewZoneInvalid(t *testing.T) {
+	for _, c := range []*Config{
+		{Host: ""},
+		{Host: "my.box"},
+		{Host: "mybox", Services: []*Service{{
+			Instance: "a.b", Type: "_https._tcp", Port: 443,
+		}}},
+		{Host: "mybox", Services: []*Service{{
+			Instance: "a", Type: "_https._tcp", Port: 0,
+		}}},
+	} {
+		if _, err := newZone(c, nil); err == nil {
+			t.Errorf("newZone(%+v) got no error", c)
+		}
+	}
+}
+
+func TestReplyAndBrowse(t *testing.T) {
+	r := &responder{zone: testZone(t)}
+
+	q := &dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 7},
+		Questions: []dnsmessage.Question{question(
+			"_homedrive._tcp.local.", dnsmessage.TypePTR,
+		)},
+	}
+	from := &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 40000}
+	m, unicast := r.reply(q, from)
+	if m == nil {
+		t.Fatal("got no reply")
+	}
+	if !unicast {
+		t.Error("legacy query should get a unicast reply")
+	}
+	if m.Header.ID != 7 || len(m.Questions) != 1 {
+		t.Errorf("legacy reply header: %+v, %d questions",
+			m.Header, len(m.Questions))
+	}
+
+	bs, err := m.Pack()
+	if err != nil {
+		t.Fatal("pack: ", err)
+	}
+	got := new(dnsmessage.Message)
+	if err := got.Unpack(bs); err != nil {
+		t.Fatal("unpack: ", err)
+	}
+
+	entries := collectEntries("_homedrive._tcp", []*dnsmessage.Message{got})
+	want := []*Entry{{
+		Instance: "HomeDrive mybox",
+		Host:     "mybox.local",
+		Port:     443,
+		IPs: []net.IP{
+			net.ParseIP("192.168.1.10").To4(),
+			net.ParseIP("2001:db8::10"),
+		},
+		TXT: []string{"name=mybox"},
+	}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got entries %+v, want %+v", entries, want)
+	}
+
+	from.Port = mdnsPort
+	if _, unicast := r.reply(q, from); unicast {
+		t.Error("multicast query should get a multicast reply")
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceIPs returns the global unicast addresses of the network
+// interfaces that are up, skipping the ones with the given name prefixes.
+func interfaceIPs(skip []string) ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %w", err)
+	}
+	var ips []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || hasAnyPrefix(iface.Name, skip) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("list addresses of %q: %w", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// configIPs returns the function that returns the addresses to announce.
+// Interface addresses are listed on each call, so address changes are
+// picked up.
+func configIPs(c *Config) (func() []net.IP, error) {
+	if len(c.IPs) == 0 {
+		if _, err := interfaceIPs(c.SkipInterfaces); err != nil {
+			return nil, err
+		}
+		return func() []net.IP {
+			ips, err := interfaceIPs(c.SkipInterfaces)
+			if err != nil {
+				log.Print("mdns: ", err)
+			}
+			return ips
+		}, nil
+	}
+	var ips []net.IP
+	for _, s := range c.IPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", s)
+		}
+		ips = append(ips, ip)
+	}
+	return func() []net.IP { return ips }, nil
+}
+
+type responder struct {
+	zone  *zone
+	conn  *net.UDPConn
+	group *net.UDPAddr
+}
+
+func (r *responder) send(m *dnsmessage.Message, to *net.UDPAddr) error {
+	bs, err := m.Pack()
+	if err != nil {
+		return fmt.Errorf("pack message: %w", err)
+	}
+	if _, err := r.conn.WriteToUDP(bs, to); err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+	return nil
+}
+
+func (r *responder) announce() error {
+	m := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			Response:      true,
+			Authoritative: true,
+		},
+		Answers: r.zone.announcement(),
+	}
+	return r.send(m, r.group)
+}
+
+// reply returns the reply of a query, and if the reply should be sent
+// to the sender by unicast. It returns nil if there is nothing to reply.
+func (r *responder) reply(q *dnsmessage.Message, from *net.UDPAddr) (
+	*dnsmessage.Message, bool,
+) {
+	if q.Header.Response || q.Header.OpCode != 0 {
+		return nil, false
+	}
+
+	// Queries not from the mDNS port are legacy unicast queries, which
+	// must be answered like normal DNS.
+	legacy := from.Port != mdnsPort
+
+	m := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			Response:      true,
+			Authoritative: true,
+		},
+	}
+	unicast := legacy
+	for _, question := range q.Questions {
+		if question.Class&classUnicast != 0 {
+			unicast = true
+		}
+		question.Class &^= classUnicast
+		answers, extras := r.zone.answer(question)
+		m.Answers = append(m.Answers, answers...)
+		m.Additionals = append(m.Additionals, extras...)
+	}
+	if len(m.Answers) == 0 {
+		return nil, false
+	}
+	if legacy {
+		m.Header.ID = q.Header.ID
+		m.Questions = q.Questions
+	}
+	return m, unicast
+}
+
+func (r *responder) serve(ctx context.Context) error {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("read query: %w", err)
+		}
+
+		q := new(dnsmessage.Message)
+		if err := q.Unpack(buf[:n]); err != nil {
+			continue // Ignore malformed packets.
+		}
+		m, unicast := r.reply(q, from)
+		if m == nil {
+			continue
+		}
+		to := r.group
+		if unicast {
+			to = from
+		}
+		if err := r.send(m, to); err != nil {
+			log.Print("mdns: ", err)
+		}
+	}
+}
+
+func listen(network string, group net.IP) (*net.UDPConn, error) {
+	addr := &net.UDPAddr{IP: group, Port: mdnsPort}
+	return net.ListenMulticastUDP(network, nil, addr)
+}
+
+// Serve runs an mDNS responder that announces the host and the services
+// in the config, until the context is canceled. It serves on IPv4, and
+// also on IPv6 when the host supports it.
+func Serve(ctx context.Context, c *Config) error {
+	ips, err := configIPs(c)
+	if err != nil {
+		return err
+	}
+	z, err := newZone(c, ips)
+	if err != nil {
+		return err
+	}
+
+	var rs []*responder
+	conn4, err := listen("udp4", mdnsGroup4)
+	if err != nil {
+		return fmt.Errorf("listen mdns: %w", err)
+	}
+	rs = append(rs, &responder{
+		zone:  z,
+		conn:  conn4,
+		group: &net.UDPAddr{IP: mdnsGroup4, Port: mdnsPort},
+	})
+	if conn6, err := listen("udp6", mdnsGroup6); err != nil {
+		log.Print("mdns: ipv6 disabled: ", err)
+	} else {
+		rs = append(rs, &responder{
+			zone:  z,
+			conn:  conn6,
+			group: &net.UDPAddr{IP: mdnsGroup6, Port: mdnsPort},
+		})
+	}
+
+	errs := make(chan error, len(rs))
+	for _, r := range rs {
+		go func(r *responder) { errs <- r.serve(ctx) }(r)
+	}
+
+	// Announce twice, one second apart, as RFC 6762 section 8.3 asks.
+	go func() {
+		for i := 0; i < 2; i++ {
+			for _, r := range rs {
+				if err := r.announce(); err != nil {
+					log.Print("mdns: announce: ", err)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	for _, r := range rs {
+		r.conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,424 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package metrics keeps counters, gauges and histograms, and writes them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types.
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefaultBuckets are the default histogram buckets for latencies in
+// seconds.
+var DefaultBuckets = []float64{
+	.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// Sample is a sample of a metric that is collected on each scrape.
+type Sample struct {
+	Labels []string // Label values, in the same order as label names.
+	Value  float64
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry is a set of metrics.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry creates a new empty registry.
+func NewRegistry() *Registry {
+	return new(Registry)
+}
+
+func (r *Registry) add(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes all metrics in the registry in text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics over HTTP.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(bs []byte) (int, error) {
+	n, err := w.w.Write(bs)
+	w.n += int64(n)
+	return n, err
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	if d.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+func (d *desc) checkLabels(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf(
+			"metric %q has %d labels, got %d values",
+			d.name, len(d.labels), len(values),
+		))
+	}
+}
+
+// seriesKey joins label values into a map key.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// vec keeps a float value for each set of label values.
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*Sample
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{
+		desc: desc{
+			name:   name,
+			help:   help,
+			typ:    typ,
+			labels: labels,
+		},
+		series: make(map[string]*Sample),
+	}
+}
+
+func (v *vec) update(values []string, f func(s *Sample)) {
+	v.checkLabels(values)
+	k := seriesKey(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[k]
+	if !ok {
+		s = &Sample{Labels: append([]string(nil), values...)}
+		v.series[k] = s
+	}
+	f(s)
+}
+
+func (v *vec) remove(values []string) {
+	v.checkLabels(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.series, seriesKey(values))
+}
+
+func (v *vec) samples() []*Sample {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var samples []*Sample
+	for _, s := range v.series {
+		cp := *s
+		samples = append(samples, &cp)
+	}
+	return samples
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	writeSamples(w, &v.desc, v.samples())
+}
+
+func sortSamples(samples []*Sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return seriesKey(samples[i].Labels) < seriesKey(samples[j].Labels)
+	})
+}
+
+func writeSamples(w *bufio.Writer, d *desc, samples []*Sample) {
+	sortSamples(samples)
+	d.writeHeader(w)
+	for _, s := range samples {
+		writeLine(w, d.name, d.labels, s.Labels, "", "", s.Value)
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ v *vec }
+
+// NewCounter creates a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{v: newVec(name, help, TypeCounter, labels)}
+	r.add(c.v)
+	return c
+}
+
+// Add adds delta to the counter with the given label values.
+func (c *Counter) Add(delta float64, values ...string) {
+	c.v.update(values, func(s *Sample) { s.Value += delta })
+}
+
+// Inc increases the counter with the given label values by one.
+func (c *Counter) Inc(values ...string) { c.Add(1, values...) }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ v *vec }
+
+// NewGauge creates a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{v: newVec(name, help, TypeGauge, labels)}
+	r.add(g.v)
+	return g
+}
+
+// Set sets the gauge with the given label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.v.update(values, func(s *Sample) { s.Value = v })
+}
+
+// Add adds delta to the gauge with the given label values.
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.v.update(values, func(s *Sample) { s.Value += delta })
+}
+
+// Inc increases the gauge with the given label values by one.
+func (g *Gauge) Inc(values ...string) { g.Add(1, values...) }
+
+// Dec decreases the gauge with the given label values by one.
+func (g *Gauge) Dec(values ...string) { g.Add(-1, values...) }
+
+// Remove removes the series of the given label values.
+func (g *Gauge) Remove(values ...string) { g.v.remove(values) }
+
+type collector struct {
+	desc
+	collect func() []*Sample
+}
+
+func (c *collector) write(w *bufio.Writer) {
+	samples := c.collect()
+	for _, s := range samples {
+		c.checkLabels(s.Labels)
+	}
+	writeSamples(w, &c.desc, samples)
+}
+
+// NewCollector adds a metric whose samples are collected by calling
+// collect on each scrape. typ is TypeCounter or TypeGauge.
+func (r *Registry) NewCollector(
+	name, help, typ string, labels []string, collect func() []*Sample,
+) {
+	r.add(&collector{
+		desc: desc{
+			name:   name,
+			help:   help,
+			typ:    typ,
+			labels: labels,
+		},
+		collect: collect,
+	})
+}
+
+type histSeries struct {
+	labels []string
+	counts []uint64 // Not cumulative.
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histSeries
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds and
+// label names. Buckets must be sorted. DefaultBuckets is used when buckets
+// is nil.
+func (r *Registry) NewHistogram(
+	name, help string, buckets []float64, labels ...string,
+) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{
+		desc: desc{
+			name:   name,
+			help:   help,
+			typ:    TypeHistogram,
+			labels: labels,
+		},
+		buckets: buckets,
+		series:  make(map[string]*histSeries),
+	}
+	r.add(h)
+	return h
+}
+
+// Observe adds an observation to the histogram with the given label
+// values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.checkLabels(values)
+	k := seriesKey(values)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histSeries{
+			labels: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[k] = s
+	}
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	var series []*histSeries
+	for _, s := range h.series {
+		cp := *s
+		cp.counts = append([]uint64(nil), s.counts...)
+		series = append(series, &cp)
+	}
+	h.mu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		return seriesKey(series[i].labels) < seriesKey(series[j].labels)
+	})
+
+	h.writeHeader(w)
+	bucketName := h.name + "_bucket"
+	for _, s := range series {
+		var total uint64
+		for i, b := range h.buckets {
+			total += s.counts[i]
+			writeLine(
+				w, bucketName, h.labels, s.labels,
+				"le", formatFloat(b), float64(total),
+			)
+		}
+		writeLine(
+			w, bucketName, h.labels, s.labels,
+			"le", "+Inf", float64(s.count),
+		)
+		writeLine(w, h.name+"_sum", h.labels, s.labels, "", "", s.sum)
+		writeLine(
+			w, h.name+"_count", h.labels, s.labels, "", "",
+			float64(s.count),
+		)
+	}
+}
+
+func writeLine(
+	w *bufio.Writer, name string, labels, values []string,
+	extraLabel, extraValue string, v float64,
+) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeValue(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeValue(s string) string { return valueEscaper.Replace(s) }
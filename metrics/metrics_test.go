@@ -0,0 +1,75 @@
+// Copyright (C) 2023  Shanhu Tech Inc.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published by the
+// Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("reqs_total", "Number of requests.", "host", "code")
+	c.Inc("b.com", "200")
+	c.Inc("a.com", "404")
+	c.Add(2, "b.com", "200")
+
+	g := r.NewGauge("conns", "Active\nconnections.", "tag")
+	g.Inc("TCP")
+	g.Inc("TCP")
+	g.Dec("TCP")
+	g.Set(3, `x"y`)
+
+	h := r.NewHistogram("latency_seconds", "", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	r.NewCollector(
+		"up", "Is up.", TypeGauge, nil,
+		func() []*Sample { return []*Sample{{Value: 1}} },
+	)
+
+	out := new(strings.Builder)
+	if _, err := r.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"# HELP reqs_total Number of requests.",
+		"# TYPE reqs_total counter",
+		`reqs_total{host="a.com",code="404"} 1`,
+		`reqs_total{host="b.com",code="200"} 3`,
+		`# HELP conns Active\nconnections.`,
+		"# TYPE conns gauge",
+		`conns{tag="TCP"} 1`,
+		`conns{tag="x\"y"} 3`,
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{le="0.1"} 1`,
+		`latency_seconds_bucket{le="1"} 2`,
+		`latency_seconds_bucket{le="+Inf"} 3`,
+		"latency_seconds_sum 2.55",
+		"latency_seconds_count 3",
+		"# HELP up Is up.",
+		"# TYPE up gauge",
+		"up 1",
+		"",
+	}, "\n")
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}